@@ -0,0 +1,175 @@
+package ipv6ddns
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+	"github.com/miguelangel-nubla/ipv6disc"
+)
+
+// The reports below are served as JSON by the web server, see docs/api.md.
+// Fields are only ever added to them, so clients can rely on the existing ones.
+
+// StateReport is the state of every published hostname.
+type StateReport struct {
+	Providers []ProviderReport `json:"providers"`
+}
+
+type ProviderReport struct {
+	Name      string           `json:"name"`
+	Endpoints []EndpointReport `json:"endpoints"`
+}
+
+type EndpointReport struct {
+	Name      string           `json:"name"`
+	Hostnames []HostnameReport `json:"hostnames"`
+}
+
+type HostnameReport struct {
+	// Key is the hostname as configured, Domain the name it is published under.
+	Key            string     `json:"key"`
+	Domain         string     `json:"domain"`
+	UpdateRunning  bool       `json:"update_running"`
+	NextUpdate     *time.Time `json:"next_update,omitempty"`
+	LastUpdate     *time.Time `json:"last_update,omitempty"`
+	LastResync     *time.Time `json:"last_resync,omitempty"`
+	DriftCorrected *time.Time `json:"drift_corrected,omitempty"`
+	LastDryRun     *time.Time `json:"last_dry_run,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	// FailedAttempts are the updates failed since the last successful one,
+	// Failed is set once they reached the retry max attempts.
+	FailedAttempts int             `json:"failed_attempts"`
+	Failed         bool            `json:"failed"`
+	Addresses      []AddressReport `json:"addresses"`
+	PlannedChanges []string        `json:"planned_changes,omitempty"`
+}
+
+// AddressReport is an address with the device it belongs to and the
+// discovery sources it was seen over.
+type AddressReport struct {
+	Address string   `json:"address"`
+	MAC     string   `json:"mac,omitempty"`
+	Sources []string `json:"sources"`
+}
+
+// DiscoveryReport is every valid address known by discovery.
+type DiscoveryReport struct {
+	Addresses []AddressReport `json:"addresses"`
+}
+
+// Report returns the state of every hostname, sorted by provider, endpoint
+// and hostname. Update errors are hidden if hideSensible is set.
+func (s *State) Report(hideSensible bool) StateReport {
+	report := StateReport{Providers: []ProviderReport{}}
+
+	s.providersMutex.RLock()
+	defer s.providersMutex.RUnlock()
+	for _, providerKey := range sortedKeys(s.providers) {
+		provider := s.providers[providerKey]
+		providerReport := ProviderReport{Name: providerKey, Endpoints: []EndpointReport{}}
+
+		provider.endpointsMutex.RLock()
+		for _, endpointKey := range sortedKeys(provider.endpoints) {
+			endpoint := provider.endpoints[endpointKey]
+			endpointReport := EndpointReport{Name: endpointKey, Hostnames: []HostnameReport{}}
+
+			endpoint.hostnamesMutex.RLock()
+			for _, hostnameKey := range sortedKeys(endpoint.hostnames) {
+				hostname := endpoint.hostnames[hostnameKey]
+				endpointReport.Hostnames = append(endpointReport.Hostnames, hostname.report(hostnameKey, hideSensible))
+			}
+			endpoint.hostnamesMutex.RUnlock()
+
+			providerReport.Endpoints = append(providerReport.Endpoints, endpointReport)
+		}
+		provider.endpointsMutex.RUnlock()
+
+		report.Providers = append(report.Providers, providerReport)
+	}
+
+	return report
+}
+
+func (h *Hostname) report(key string, hideSensible bool) HostnameReport {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	report := HostnameReport{
+		Key:            key,
+		Domain:         h.domain,
+		UpdateRunning:  h.updateRunning,
+		LastUpdate:     optionalTime(h.updatedTime),
+		LastResync:     optionalTime(h.resyncTime),
+		DriftCorrected: optionalTime(h.driftTime),
+		LastDryRun:     optionalTime(h.plannedTime),
+		FailedAttempts: h.updateAttempts,
+		Failed:         h.updateFailed,
+		Addresses:      addressReports(h.AddrCollection.Get()),
+	}
+	if h.nextUpdateTime.After(time.Now()) {
+		report.NextUpdate = optionalTime(h.nextUpdateTime)
+	}
+	if h.updateError != nil {
+		report.LastError = h.updateError.Error()
+		if hideSensible {
+			report.LastError = "<sensible data hidden>"
+		}
+	}
+	for _, change := range h.plannedChanges {
+		report.PlannedChanges = append(report.PlannedChanges, change.String())
+	}
+
+	return report
+}
+
+// DiscoveryReport returns every valid address known by discovery, sorted by address.
+func (w *Worker) DiscoveryReport() DiscoveryReport {
+	addrs := w.discovered()
+	slices.SortFunc(addrs, func(a, b *ipv6disc.Addr) int {
+		if c := a.Addr.Compare(b.Addr); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Hw.String(), b.Hw.String())
+	})
+	return DiscoveryReport{Addresses: addressReports(addrs)}
+}
+
+// ConfigReport returns the running configuration, redacted if hideSensible is set.
+func (w *Worker) ConfigReport(hideSensible bool) config.Config {
+	w.configMutex.RLock()
+	defer w.configMutex.RUnlock()
+
+	if hideSensible {
+		return w.config.Redacted()
+	}
+	return w.config
+}
+
+func addressReports(addrs []*ipv6disc.Addr) []AddressReport {
+	reports := make([]AddressReport, 0, len(addrs))
+	for _, addr := range addrs {
+		report := AddressReport{
+			Address: addr.WithZone("").String(),
+			Sources: slices.Clone(addr.Sources),
+		}
+		// static and IPv4 command addresses carry an all-zero MAC
+		if slices.ContainsFunc(addr.Hw, func(b byte) bool { return b != 0 }) {
+			report.MAC = addr.Hw.String()
+		}
+		if report.Sources == nil {
+			report.Sources = []string{}
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// optionalTime returns nil for the zero time, so it is omitted.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,73 @@
+package ipv6ddns
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6disc"
+)
+
+func TestStateReport(t *testing.T) {
+	service := &testService{}
+	w := newTestWorker(t, service, "host", "other")
+	for _, selected := range w.State.selectHostnames("endpoint", nil) {
+		selected.SetAddrCollection(newTestCollection(newTestAddr("00:11:22:33:44:55", "2001:db8::1", time.Hour)))
+	}
+	service.fail(errors.New("token abc rejected"))
+	host := "host"
+	w.UpdateNow("endpoint", &host, false, false)
+
+	report := w.Report(false)
+	if len(report.Providers) != 1 || report.Providers[0].Name != "test" || len(report.Providers[0].Endpoints) != 1 {
+		t.Fatalf("Report() = %+v, want the endpoint of the test provider", report)
+	}
+	hostnames := report.Providers[0].Endpoints[0].Hostnames
+	if len(hostnames) != 2 || hostnames[0].Key != "host" || hostnames[1].Key != "other" {
+		t.Fatalf("hostnames = %+v, want host and other sorted", hostnames)
+	}
+
+	failed := hostnames[0]
+	if failed.Domain != "host.example.com" || failed.LastError != "token abc rejected" || failed.FailedAttempts != 1 || failed.LastUpdate != nil {
+		t.Errorf("failed hostname = %+v, want its error and no update", failed)
+	}
+	if failed.NextUpdate == nil {
+		t.Errorf("failed hostname has no next update, want the retry")
+	}
+	if len(failed.Addresses) != 1 || failed.Addresses[0].Address != "2001:db8::1" {
+		t.Errorf("addresses = %+v, want 2001:db8::1", failed.Addresses)
+	}
+	if hostnames[1].LastError != "" {
+		t.Errorf("error of other = %q, want none", hostnames[1].LastError)
+	}
+
+	if got := w.Report(true).Providers[0].Endpoints[0].Hostnames[0].LastError; got != "<sensible data hidden>" {
+		t.Errorf("error = %q, want it hidden", got)
+	}
+}
+
+func TestAddressReports(t *testing.T) {
+	withSources := newTestAddr("00:11:22:33:44:55", "fe80::1", time.Hour)
+	withSources.Addr = withSources.Addr.WithZone("eth0")
+	withSources.Seen("plugin")
+	withoutMAC := ipv6disc.NewAddr(net.HardwareAddr{0, 0, 0, 0, 0, 0}, netip.MustParseAddr("192.0.2.1"), "ipv4", time.Hour, nil)
+	withoutMAC.Sources = nil
+
+	reports := addressReports([]*ipv6disc.Addr{withSources, withoutMAC})
+
+	if got := reports[0]; got.Address != "fe80::1" || got.MAC != "00:11:22:33:44:55" || !slices.Equal(got.Sources, []string{"ndp", "plugin"}) {
+		t.Errorf("report = %+v, want the address without zone, its MAC and sources", got)
+	}
+	if got := reports[1]; got.Address != "192.0.2.1" || got.MAC != "" || got.Sources == nil {
+		t.Errorf("report = %+v, want no MAC and empty sources", got)
+	}
+
+	// the reports don't share the sources of the addresses
+	reports[0].Sources[0] = "changed"
+	if withSources.Sources[0] != "ndp" {
+		t.Errorf("sources of the address = %v, want them untouched", withSources.Sources)
+	}
+}
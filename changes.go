@@ -0,0 +1,252 @@
+package ipv6ddns
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+	"github.com/miguelangel-nubla/ipv6ddns/pkg/filter"
+	"github.com/miguelangel-nubla/ipv6disc"
+)
+
+// changeTracker keeps a snapshot of the discovered addresses so that only the
+// tasks affected by an added, expired or refreshed address are re-evaluated.
+// It also remembers since when each address is seen, and the addresses that
+// stopped being seen, for the stability settings of the tasks.
+type changeTracker struct {
+	addrs map[string]trackedAddr
+	lost  map[string]lostAddr
+	// retainLost is how long the lost addresses are remembered
+	retainLost time.Duration
+	ipv4       map[string]string
+	// wakeups holds, per task, when an address held back by the stability
+	// settings becomes publishable and the task must be re-evaluated
+	wakeups map[string]time.Time
+}
+
+type trackedAddr struct {
+	addr      *ipv6disc.Addr
+	sources   string
+	firstSeen time.Time
+}
+
+type lostAddr struct {
+	trackedAddr
+	lostTime time.Time
+}
+
+func addrKey(addr *ipv6disc.Addr) string {
+	return addr.Hw.String() + "|" + addr.WithZone("").String()
+}
+
+// scan diffs the currently valid addresses against the previous snapshot and
+// returns every address that appeared, expired or changed its sources. Expired
+// addresses are remembered for retainLost, and reported again once forgotten.
+func (c *changeTracker) scan(addrs []*ipv6disc.Addr, retainLost time.Duration) []*ipv6disc.Addr {
+	now := time.Now()
+
+	current := make(map[string]trackedAddr, len(addrs))
+	for _, addr := range addrs {
+		current[addrKey(addr)] = trackedAddr{
+			addr:      addr,
+			sources:   strings.Join(addr.Sources, ","),
+			firstSeen: now,
+		}
+	}
+
+	var changed []*ipv6disc.Addr
+	for key, tracked := range current {
+		previous, ok := c.addrs[key]
+		if ok {
+			tracked.firstSeen = previous.firstSeen
+			current[key] = tracked
+		}
+		if lost, wasLost := c.lost[key]; wasLost {
+			// seen again within the removal grace, it was never unpublished
+			tracked.firstSeen = lost.firstSeen
+			current[key] = tracked
+			delete(c.lost, key)
+		}
+		if !ok || previous.sources != tracked.sources {
+			changed = append(changed, tracked.addr)
+		}
+	}
+	for key, previous := range c.addrs {
+		if _, ok := current[key]; !ok {
+			c.lost[key] = lostAddr{trackedAddr: previous, lostTime: now}
+			changed = append(changed, previous.addr)
+		}
+	}
+	for key, lost := range c.lost {
+		if !now.Before(lost.lostTime.Add(retainLost)) {
+			delete(c.lost, key)
+			changed = append(changed, lost.addr)
+		}
+	}
+
+	c.addrs = current
+	c.retainLost = retainLost
+
+	return changed
+}
+
+// nextWakeup returns when the next scan is due without any change being
+// notified, for an address becoming publishable or a lost one being forgotten.
+func (c *changeTracker) nextWakeup() (time.Time, bool) {
+	var next time.Time
+	for _, wakeup := range c.wakeups {
+		if next.IsZero() || wakeup.Before(next) {
+			next = wakeup
+		}
+	}
+	for _, lost := range c.lost {
+		if forget := lost.lostTime.Add(c.retainLost); next.IsZero() || forget.Before(next) {
+			next = forget
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// firstSeen returns since when the address is continuously seen, the zero
+// time for addresses not coming from discovery.
+func (c *changeTracker) firstSeen(addr *ipv6disc.Addr) time.Time {
+	return c.addrs[addrKey(addr)].firstSeen
+}
+
+// stableTime returns when the address meets the stability settings, ok is
+// false when it does not have enough sightings to ever meet them.
+func stableTime(stability config.Stability, tracked trackedAddr) (time.Time, bool) {
+	if len(tracked.addr.Sources) < stability.MinSightings {
+		return time.Time{}, false
+	}
+	return tracked.firstSeen.Add(stability.MinAge), true
+}
+
+// stable reports whether the discovered address can be published by the task,
+// scheduling a re-evaluation of the task for when it becomes publishable.
+func (c *changeTracker) stable(taskName string, stability config.Stability, addr *ipv6disc.Addr) bool {
+	tracked, ok := c.addrs[addrKey(addr)]
+	if !ok {
+		return false
+	}
+
+	stableTime, ok := stableTime(stability, tracked)
+	if !ok {
+		return false
+	}
+	if time.Now().Before(stableTime) {
+		if wakeup, ok := c.wakeups[taskName]; !ok || stableTime.Before(wakeup) {
+			c.wakeups[taskName] = stableTime
+		}
+		return false
+	}
+
+	return true
+}
+
+// lostWithinGrace returns the addresses that were publishable under the stability settings
+// when they stopped being seen less than the removal grace ago. They are valid
+// for the rest of the grace period.
+func (c *changeTracker) lostWithinGrace(stability config.Stability) []*ipv6disc.Addr {
+	var addrs []*ipv6disc.Addr
+	for _, lost := range c.lost {
+		stableTime, ok := stableTime(stability, lost.trackedAddr)
+		if !ok || lost.lostTime.Before(stableTime) {
+			continue
+		}
+
+		remaining := time.Until(lost.lostTime.Add(stability.RemovalGrace))
+		if remaining <= 0 {
+			continue
+		}
+
+		addr := ipv6disc.NewAddr(lost.addr.Hw, lost.addr.Addr, lost.addr.Sources[0], remaining, nil)
+		for _, source := range lost.addr.Sources[1:] {
+			addr.Seen(source)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	return addrs
+}
+
+// ipv4Changed reports whether the valid addresses of the task IPv4 handler
+// differ from the ones seen on the previous call.
+func (c *changeTracker) ipv4Changed(taskName string, task config.Task) bool {
+	if task.IPv4 == nil {
+		return false
+	}
+
+	current := strings.Join(task.IPv4.FilterValid().Strings(), ",")
+	previous, ok := c.ipv4[taskName]
+	c.ipv4[taskName] = current
+
+	return !ok || previous != current
+}
+
+// affects reports whether any of the changed addresses is relevant to the task.
+func (c *changeTracker) affects(taskName string, task config.Task, changed []*ipv6disc.Addr) bool {
+	if c.ipv4Changed(taskName, task) {
+		return true
+	}
+
+	if wakeup, ok := c.wakeups[taskName]; ok && !time.Now().Before(wakeup) {
+		delete(c.wakeups, taskName)
+		return true
+	}
+
+	for _, addr := range changed {
+		if matchesFilters(addr, task.Filters) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forgetTask drops the IPv4 snapshot and pending re-evaluation of a task that was replaced or removed.
+func (c *changeTracker) forgetTask(taskName string) {
+	delete(c.ipv4, taskName)
+	delete(c.wakeups, taskName)
+}
+
+func matchesFilters(addr *ipv6disc.Addr, filters []config.Filters) bool {
+	for _, f := range filters {
+		if !filter.CheckMAC(addr.Hw, f.MAC.Address) {
+			continue
+		}
+		if !filter.CheckMACMask(addr.Hw, f.MAC.Mask) {
+			continue
+		}
+		if !filter.CheckMACType(addr.Hw, f.MAC.Type) {
+			continue
+		}
+		if !filter.CheckIPType(addr, f.IP.Type) {
+			continue
+		}
+		if !filter.CheckPrefix(addr.Addr, f.IP.Prefix) {
+			continue
+		}
+		if !filter.CheckSuffix(addr.Addr, f.IP.Suffix) {
+			continue
+		}
+		if !filter.CheckMask(addr.Addr, f.IP.Mask) {
+			continue
+		}
+		if !filter.CheckSource(addr, f.Source) {
+			continue
+		}
+		return true
+	}
+
+	return false
+}
+
+func newChangeTracker() *changeTracker {
+	return &changeTracker{
+		addrs:   make(map[string]trackedAddr),
+		lost:    make(map[string]lostAddr),
+		ipv4:    make(map[string]string),
+		wakeups: make(map[string]time.Time),
+	}
+}
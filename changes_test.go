@@ -0,0 +1,179 @@
+package ipv6ddns
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+	"github.com/miguelangel-nubla/ipv6disc"
+)
+
+func newTestAddr(mac string, ip string, lifetime time.Duration) *ipv6disc.Addr {
+	hw, _ := net.ParseMAC(mac)
+	return ipv6disc.NewAddr(hw, netip.MustParseAddr(ip), "ndp", lifetime, nil)
+}
+
+func prefixTask(prefix string) config.Task {
+	return config.Task{Filters: []config.Filters{{IP: config.IPFilters{Prefix: netip.MustParsePrefix(prefix)}}}}
+}
+
+func TestChangeTrackerScan(t *testing.T) {
+	a := newTestAddr("00:11:22:33:44:55", "2001:db8::1", time.Hour)
+	b := newTestAddr("00:11:22:33:44:66", "2001:db8::2", time.Hour)
+
+	t.Run("Reports Added Addresses", func(t *testing.T) {
+		c := newChangeTracker()
+		if changed := c.scan([]*ipv6disc.Addr{a, b}, 0); len(changed) != 2 {
+			t.Errorf("scan() reported %d changes, want 2", len(changed))
+		}
+	})
+
+	t.Run("Reports Nothing When Unchanged", func(t *testing.T) {
+		c := newChangeTracker()
+		c.scan([]*ipv6disc.Addr{a, b}, 0)
+		if changed := c.scan([]*ipv6disc.Addr{a, b}, 0); len(changed) != 0 {
+			t.Errorf("scan() reported %d changes, want 0", len(changed))
+		}
+	})
+
+	t.Run("Reports New Sources", func(t *testing.T) {
+		c := newChangeTracker()
+		c.scan([]*ipv6disc.Addr{a}, 0)
+		refreshed := newTestAddr("00:11:22:33:44:55", "2001:db8::1", time.Hour)
+		refreshed.Seen("plugin")
+		changed := c.scan([]*ipv6disc.Addr{refreshed}, 0)
+		if len(changed) != 1 || changed[0] != refreshed {
+			t.Errorf("scan() = %v, want the refreshed address", changed)
+		}
+	})
+
+	t.Run("Keeps First Seen", func(t *testing.T) {
+		c := newChangeTracker()
+		c.scan([]*ipv6disc.Addr{a}, 0)
+		firstSeen := c.firstSeen(a)
+		c.scan([]*ipv6disc.Addr{a, b}, 0)
+		if got := c.firstSeen(a); !got.Equal(firstSeen) {
+			t.Errorf("firstSeen() = %v, want %v", got, firstSeen)
+		}
+	})
+
+	t.Run("Retains Lost Addresses", func(t *testing.T) {
+		c := newChangeTracker()
+		c.scan([]*ipv6disc.Addr{a, b}, 0)
+		firstSeen := c.firstSeen(a)
+
+		changed := c.scan([]*ipv6disc.Addr{b}, time.Hour)
+		if len(changed) != 1 || changed[0] != a {
+			t.Fatalf("scan() = %v, want the lost address", changed)
+		}
+		if _, ok := c.nextWakeup(); !ok {
+			t.Errorf("nextWakeup() found nothing, want the lost address to be forgotten")
+		}
+
+		c.scan([]*ipv6disc.Addr{a, b}, time.Hour)
+		if got := c.firstSeen(a); !got.Equal(firstSeen) {
+			t.Errorf("firstSeen() = %v after seen again within grace, want %v", got, firstSeen)
+		}
+	})
+
+	t.Run("Forgets Lost Addresses", func(t *testing.T) {
+		c := newChangeTracker()
+		c.scan([]*ipv6disc.Addr{a, b}, 0)
+		c.scan([]*ipv6disc.Addr{b}, 0)
+		if len(c.lost) != 0 {
+			t.Errorf("lost = %v, want none without retention", c.lost)
+		}
+		if _, ok := c.nextWakeup(); ok {
+			t.Errorf("nextWakeup() found a wakeup, want none")
+		}
+	})
+}
+
+func TestChangeTrackerAffects(t *testing.T) {
+	inside := newTestAddr("00:11:22:33:44:55", "2001:db8::1", time.Hour)
+	outside := newTestAddr("00:11:22:33:44:66", "2001:db9::1", time.Hour)
+	task := prefixTask("2001:db8::/64")
+
+	tests := []struct {
+		name    string
+		changed []*ipv6disc.Addr
+		want    bool
+	}{
+		{"No Changes", nil, false},
+		{"Matching Change", []*ipv6disc.Addr{inside}, true},
+		{"Other Change", []*ipv6disc.Addr{outside}, false},
+		{"Mixed Changes", []*ipv6disc.Addr{outside, inside}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newChangeTracker()
+			if got := c.affects("task", task, tt.changed); got != tt.want {
+				t.Errorf("affects() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("Due Wakeup", func(t *testing.T) {
+		c := newChangeTracker()
+		c.wakeups["task"] = time.Now().Add(-time.Second)
+		if !c.affects("task", task, nil) {
+			t.Errorf("affects() = false, want true for a due wakeup")
+		}
+		if c.affects("task", task, nil) {
+			t.Errorf("affects() = true, want the wakeup to be consumed")
+		}
+	})
+
+	t.Run("Pending Wakeup", func(t *testing.T) {
+		c := newChangeTracker()
+		c.wakeups["task"] = time.Now().Add(time.Hour)
+		if c.affects("task", task, nil) {
+			t.Errorf("affects() = true, want false before the wakeup")
+		}
+		if next, ok := c.nextWakeup(); !ok || !next.Equal(c.wakeups["task"]) {
+			t.Errorf("nextWakeup() = %v, %v, want the pending wakeup", next, ok)
+		}
+	})
+}
+
+func TestChangeTrackerStable(t *testing.T) {
+	addr := newTestAddr("00:11:22:33:44:55", "2001:db8::1", time.Hour)
+
+	c := newChangeTracker()
+	c.scan([]*ipv6disc.Addr{addr}, 0)
+
+	if !c.stable("task", config.Stability{}, addr) {
+		t.Errorf("stable() = false, want true without stability settings")
+	}
+	if c.stable("task", config.Stability{MinSightings: 2}, addr) {
+		t.Errorf("stable() = true, want false with a single source")
+	}
+	if c.stable("task", config.Stability{MinAge: time.Hour}, addr) {
+		t.Errorf("stable() = true, want false before the minimum age")
+	}
+	if _, ok := c.wakeups["task"]; !ok {
+		t.Errorf("stable() did not schedule a wakeup for the minimum age")
+	}
+}
+
+func TestChangeTrackerForgetTask(t *testing.T) {
+	c := newChangeTracker()
+	c.wakeups["task"] = time.Now().Add(time.Hour)
+	c.wakeups["other"] = time.Now().Add(time.Hour)
+	c.ipv4["task"] = "192.0.2.1"
+
+	c.forgetTask("task")
+
+	if _, ok := c.wakeups["task"]; ok {
+		t.Errorf("forgetTask() kept the wakeup of the task")
+	}
+	if _, ok := c.ipv4["task"]; ok {
+		t.Errorf("forgetTask() kept the IPv4 snapshot of the task")
+	}
+	if _, ok := c.wakeups["other"]; !ok {
+		t.Errorf("forgetTask() dropped the wakeup of another task")
+	}
+}
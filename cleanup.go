@@ -0,0 +1,113 @@
+package ipv6ddns
+
+import (
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+	"github.com/miguelangel-nubla/ipv6ddns/ddns"
+	"github.com/miguelangel-nubla/ipv6disc"
+)
+
+// cleanupOrphans applies the cleanup policy to the hostnames published in a
+// previous run or configuration that are no longer configured, removing their
+// A/AAAA records from the provider when due. The hostnames generated by
+// templates become orphans once no device generates them, so nothing is
+// cleaned up during warm-up while discovery has not seen the devices yet.
+func (w *Worker) cleanupOrphans() {
+	if time.Now().Before(w.warmupEnd) {
+		return
+	}
+	if !w.cleanupMutex.TryLock() {
+		return
+	}
+	defer w.cleanupMutex.Unlock()
+
+	w.configMutex.RLock()
+	cfg := w.config
+	services := w.services
+	hostnameServices := w.hostnameServices
+	w.configMutex.RUnlock()
+
+	configured := cfg.Hostnames()
+	w.addGenerated(configured)
+
+	orphans, changed := w.store.orphans(configured)
+	for _, orphan := range orphans {
+		switch cfg.Cleanup.Policy {
+		case config.CleanupKeep:
+			continue
+		case config.CleanupDeleteAfter:
+			if time.Since(orphan.orphanedSince) < cfg.Cleanup.GracePeriod {
+				continue
+			}
+		}
+
+		credential, ok := cfg.Credentials[orphan.endpointKey]
+		if !ok {
+			w.logger.Warnf("endpoint %s is no longer configured, can't clean up orphaned hostname %s", orphan.endpointKey, orphan.hostnameKey)
+			continue
+		}
+
+		service, err := orphanService(orphan, credential, services, hostnameServices)
+		if err != nil {
+			w.logger.Errorf("endpoint %s error creating DNS service to clean up %s: %s", orphan.endpointKey, orphan.hostnameKey, err)
+			continue
+		}
+
+		reverseZones := orphan.origin.ReverseZones
+		if orphan.origin.Options == "" {
+			// published before the origin was stored, only the configuration is left
+			reverseZones = cfg.ReverseZones(orphan.endpointKey, orphan.hostnameKey)
+		}
+
+		previous, _, _ := w.store.restore(orphan.endpointKey, orphan.hostnameKey, w.lifetime)
+		ctx, cancel := w.updateContext(credential)
+		changes, err := w.update(ctx, service, reverseZones, orphan.hostnameKey, ipv6disc.NewAddrCollection(), previous)
+		cancel()
+		if err != nil {
+			w.logger.Errorf("endpoint %s error removing records of orphaned hostname %s: %s", orphan.endpointKey, orphan.hostnameKey, err)
+			continue
+		}
+		if credential.DryRun {
+			// keep it in the store, nothing was removed
+			w.logger.Infof("endpoint %s dry run, would remove records of orphaned hostname %s: %v", orphan.endpointKey, orphan.hostnameKey, changes)
+			continue
+		}
+
+		w.logger.Infof("endpoint %s removed records of orphaned hostname %s", orphan.endpointKey, orphan.hostnameKey)
+		w.store.forget(orphan.endpointKey, orphan.hostnameKey)
+		changed = true
+	}
+
+	if changed {
+		w.saveStore()
+	}
+}
+
+// orphanService returns the service the orphaned hostname was published
+// through, the one of its configured options if they still exist, else the
+// one of the endpoint with the stored overrides of the hostname applied.
+func orphanService(orphan orphan, credential config.Credential, services map[string]ddns.Service, hostnameServices map[string]map[string]ddns.Service) (ddns.Service, error) {
+	if service, ok := hostnameServices[orphan.endpointKey][orphan.origin.Options]; ok {
+		return service, nil
+	}
+	if len(orphan.origin.Overrides) == 0 {
+		if service, ok := services[orphan.endpointKey]; ok {
+			return service, nil
+		}
+		return ddns.NewService(credential.Provider, credential.RawSettings)
+	}
+
+	settings, err := config.Hostname{Name: orphan.hostnameKey, Settings: orphan.origin.Overrides}.ProviderSettings(credential.RawSettings)
+	if err != nil {
+		return nil, err
+	}
+	return ddns.NewService(credential.Provider, settings)
+}
+
+func (w *Worker) saveStore() {
+	err := w.store.Save()
+	if err != nil {
+		w.logger.Errorf("error saving state file: %s", err)
+	}
+}
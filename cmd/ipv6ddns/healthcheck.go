@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6ddns"
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+)
+
+// healthcheckTimeout bounds every request of the healthcheck command.
+const healthcheckTimeout = 5 * time.Second
+
+// healthcheck queries /healthz of a running instance, and /readyz with
+// -healthcheck_ready, at baseURL or else on the first address the web server
+// of the configuration listens on, for use as a container health check. The
+// failed checks are only listed with the token of -api_token_file. It returns
+// the exit code: 0 if they pass, 1 otherwise.
+func healthcheck(baseURL string) int {
+	client := &http.Client{Timeout: healthcheckTimeout}
+	if baseURL == "" {
+		var insecure bool
+		baseURL, insecure = localURL()
+		if baseURL == "" {
+			fmt.Fprintln(os.Stderr, "healthcheck needs the web server enabled, or its URL")
+			return 1
+		}
+		// the local instance may well use a self-signed certificate
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure}}
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	var token string
+	if apiTokenFile != "" {
+		var err error
+		token, err = readToken(apiTokenFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading API token: %s\n", err)
+			return 1
+		}
+	}
+
+	paths := []string{"/healthz"}
+	if healthcheckReady {
+		paths = append(paths, "/readyz")
+	}
+
+	code := 0
+	for _, path := range paths {
+		req, err := http.NewRequest(http.MethodGet, baseURL+path, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			return 1
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			code = 1
+			continue
+		}
+		var report ipv6ddns.HealthReport
+		err = json.NewDecoder(resp.Body).Decode(&report)
+		resp.Body.Close()
+
+		fmt.Printf("%s: %s\n", path, resp.Status)
+		if resp.StatusCode != http.StatusOK {
+			code = 1
+		}
+		if err != nil {
+			continue
+		}
+		for _, check := range report.Checks {
+			if !check.OK {
+				fmt.Printf("    %s: %s\n", check.Name, check.Detail)
+			}
+		}
+	}
+	return code
+}
+
+// localURL returns the URL of the web server on this host, from the first
+// listen address of the configuration or -webserver_port, and whether it
+// uses TLS. Wildcard addresses are reached through the loopback ones.
+func localURL() (string, bool) {
+	cfg, err := config.NewConfig(configFile)
+	if err != nil {
+		cfg = config.Config{}
+	}
+
+	addresses := listenAddresses(cfg)
+	if len(addresses) == 0 {
+		return "", false
+	}
+	host, port, err := net.SplitHostPort(addresses[0])
+	if err != nil {
+		return "", false
+	}
+	if addr, err := netip.ParseAddr(host); host == "" || (err == nil && addr.IsUnspecified()) {
+		host = "127.0.0.1"
+		if err == nil && addr.Is6() {
+			host = "::1"
+		}
+	}
+
+	scheme, secure := "http", cfg.WebServer.TLSCert != ""
+	if secure {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port), secure
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
+	"os/signal"
+	"reflect"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/miguelangel-nubla/ipv6ddns"
@@ -24,6 +28,12 @@ var logLevel string
 var lifetime time.Duration
 var live bool
 var webserverPort int
+var shutdownTimeout time.Duration
+var configWatchInterval time.Duration
+var dryRun bool
+var apiTokenFile string
+var readyFailureThreshold time.Duration
+var healthcheckReady bool
 
 func init() {
 	flag.BoolVar(&showVersion, "version", false, "Show the current version")
@@ -31,7 +41,13 @@ func init() {
 	flag.StringVar(&logLevel, "log_level", "info", "Logging level (debug, info, warn, error, fatal, panic) default: info")
 	flag.DurationVar(&lifetime, "lifetime", 1*time.Hour, "Time to keep a discovered host entry after it has been last seen, default: 1h")
 	flag.BoolVar(&live, "live", false, "Show the currrent state live on the terminal, default: false")
-	flag.IntVar(&webserverPort, "webserver_port", 0, "If port specified you can connect to this port to view the same live output from a browser, default: disabled")
+	flag.IntVar(&webserverPort, "webserver_port", 0, "If port specified you can connect to this port to view the same live output from a browser, and scrape Prometheus metrics from /metrics, unless the webserver listen addresses are configured, default: disabled")
+	flag.DurationVar(&configWatchInterval, "config_watch_interval", 10*time.Second, "How often to check the configuration and inventory files for changes to reload them, 0 to only reload on SIGHUP, default: 10s")
+	flag.BoolVar(&dryRun, "dry_run", false, "Only log and show the DNS changes that would be made, without applying them, default: false")
+	flag.StringVar(&apiTokenFile, "api_token_file", "", "File with a bearer token granting admin access to the web server, to trigger updates through its API, default: disabled")
+	flag.DurationVar(&readyFailureThreshold, "ready_failure_threshold", 1*time.Hour, "Time the updates of a hostname can keep failing before /readyz reports the service as not ready, 0 to ignore failures, default: 1h")
+	flag.BoolVar(&healthcheckReady, "healthcheck_ready", false, "Make the healthcheck command check /readyz as well as /healthz, default: false")
+	flag.DurationVar(&shutdownTimeout, "shutdown_timeout", 30*time.Second, "Time to wait for running updates to finish on shutdown, default: 30s")
 }
 
 func main() {
@@ -42,15 +58,27 @@ func main() {
 		os.Exit(0)
 	}
 
+	if flag.Arg(0) == "healthcheck" {
+		// accept the flags after the command as well
+		flag.CommandLine.Parse(flag.Args()[1:])
+		os.Exit(healthcheck(flag.Arg(0)))
+	}
+
 	sugar := initializeLogger()
 
-	config, err := config.NewConfig(configFile)
+	config, err := loadConfig()
 	if err != nil {
 		sugar.Fatalf("error reading config: %s", err)
 	}
+	if dryRun {
+		sugar.Warnf("dry run, no DNS changes will be applied")
+	}
 
 	rediscover := lifetime / 3
-	worker := ipv6ddns.NewWorker(sugar, rediscover, lifetime, config)
+	worker, err := ipv6ddns.NewWorker(sugar, rediscover, lifetime, config)
+	if err != nil {
+		sugar.Fatalf("can't create worker: %s", err)
+	}
 
 	for name, pCfg := range config.Discovery.Plugins {
 		p, err := plugins.Create(pCfg.Type, name, pCfg.Params, lifetime)
@@ -61,36 +89,153 @@ func main() {
 		worker.RegisterPlugin(p)
 	}
 
-	err = worker.Start()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = worker.Start(ctx)
 	if err != nil {
 		sugar.Fatalf("can't start worker: %s", err)
 	}
 
-	if webserverPort > 0 {
-		go func() {
-			http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-				w.Header().Set("Content-Type", "text/plain")
-				w.Write([]byte(wrapPrettyPrint(worker, "", true)))
-			})
-			sugar.Infof("Starting web server on port %d", webserverPort)
-			if err := http.ListenAndServe(fmt.Sprintf(":%d", webserverPort), nil); err != nil {
-				sugar.Fatalf("web server failed: %s", err)
-			}
-		}()
+	go watchConfig(ctx, worker, config, sugar)
+
+	var apiToken string
+	if apiTokenFile != "" {
+		apiToken, err = readToken(apiTokenFile)
+		if err != nil {
+			sugar.Fatalf("error reading API token: %s", err)
+		}
+	}
+
+	server, err := startWebServer(config, newWebHandler(worker, config.WebServer, apiToken), sugar)
+	if err != nil {
+		sugar.Fatalf("can't start web server: %s", err)
 	}
 
 	if live {
 		liveOutput := make(chan string)
 		go func() {
 			for {
-				liveOutput <- wrapPrettyPrint(worker, "", false)
+				select {
+				case liveOutput <- wrapPrettyPrint(worker, "", false):
+				case <-ctx.Done():
+					return
+				}
 				time.Sleep(1 * time.Second)
 			}
 		}()
-		terminal.LiveOutput(liveOutput)
-	} else {
-		select {}
+		go func() {
+			terminal.LiveOutput(liveOutput)
+			stop()
+		}()
+	}
+
+	<-ctx.Done()
+	sugar.Infof("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if server != nil {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			sugar.Errorf("error stopping web server: %s", err)
+		}
+	}
+
+	if err := worker.Stop(shutdownCtx); err != nil {
+		sugar.Errorf("error stopping worker: %s", err)
+	}
+}
+
+// watchConfig reloads the configuration on SIGHUP or when the configuration
+// file, or an inventory file it references, changes. An invalid configuration
+// is logged and the running one kept.
+func watchConfig(ctx context.Context, worker *ipv6ddns.Worker, cfg config.Config, sugar *zap.SugaredLogger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if configWatchInterval > 0 {
+		ticker := time.NewTicker(configWatchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	watched := watchedFiles(cfg)
+	lastModified := modTimes(watched)
+
+	reload := func() {
+		newConfig, err := loadConfig()
+		if err != nil {
+			sugar.Errorf("error reading config, keeping the running one: %s", err)
+			return
+		}
+
+		err = worker.Reload(newConfig)
+		if err != nil {
+			sugar.Errorf("error applying config, keeping the running one: %s", err)
+			return
+		}
+		// the web server keeps the settings it was started with
+		if !reflect.DeepEqual(newConfig.WebServer, cfg.WebServer) {
+			sugar.Warnf("web server settings changed, restart to apply them")
+		}
+
+		watched = watchedFiles(newConfig)
+		lastModified = modTimes(watched)
+		sugar.Infof("configuration reloaded from %s", configFile)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			lastModified = modTimes(watched)
+			reload()
+		case <-tick:
+			modified := modTimes(watched)
+			if !modified[0].IsZero() && !slices.EqualFunc(modified, lastModified, time.Time.Equal) {
+				lastModified = modified
+				reload()
+			}
+		}
+	}
+}
+
+// watchedFiles returns the configuration file followed by the inventory files it references.
+func watchedFiles(cfg config.Config) []string {
+	return append([]string{configFile}, cfg.InventoryFiles()...)
+}
+
+// loadConfig reads the configuration file, forcing a dry run on every
+// credential when requested from the command line.
+func loadConfig() (config.Config, error) {
+	cfg, err := config.NewConfig(configFile)
+	if err != nil {
+		return cfg, err
+	}
+
+	if dryRun {
+		for alias, credential := range cfg.Credentials {
+			credential.DryRun = true
+			cfg.Credentials[alias] = credential
+		}
+	}
+
+	return cfg, nil
+}
+
+// modTimes returns when each file was last modified, the zero time for the ones that can't be read.
+func modTimes(files []string) []time.Time {
+	result := make([]time.Time, len(files))
+	for i, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			result[i] = info.ModTime()
+		}
 	}
+	return result
 }
 
 func wrapPrettyPrint(worker *ipv6ddns.Worker, prefix string, hideSensible bool) string {
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6ddns"
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+	"go.uber.org/zap"
+)
+
+// accessHandler serves a request, hiding the sensible data unless it was
+// made with admin access.
+type accessHandler func(w http.ResponseWriter, r *http.Request, hideSensible bool)
+
+// authenticator resolves the access of the requests from their credentials.
+type authenticator struct {
+	settings config.WebServer
+	// apiToken is the admin bearer token read from -api_token_file
+	apiToken string
+}
+
+// access returns the access granted to the request, and whether it carried
+// credentials, valid or not.
+func (a authenticator) access(r *http.Request) (string, bool) {
+	if username, password, ok := r.BasicAuth(); ok {
+		for _, user := range a.settings.Users {
+			// compare both to take the same time whichever does not match
+			validUser := subtle.ConstantTimeCompare([]byte(username), []byte(user.Username))
+			validPassword := subtle.ConstantTimeCompare([]byte(password), []byte(user.Password))
+			if validUser&validPassword == 1 {
+				return user.Access, true
+			}
+		}
+		return config.AccessNone, true
+	}
+
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if a.apiToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.apiToken)) == 1 {
+			return config.AccessAdmin, true
+		}
+		for _, candidate := range a.settings.Tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(candidate.Token)) == 1 {
+				return candidate.Access, true
+			}
+		}
+		return config.AccessNone, true
+	}
+
+	return a.settings.Anonymous(), false
+}
+
+// authenticated reports whether the request carried valid credentials with at
+// least the required access, the anonymous access does not count.
+func (a authenticator) authenticated(r *http.Request, required string) bool {
+	access, authenticated := a.access(r)
+	return authenticated && config.AccessAtLeast(access, required)
+}
+
+// require only lets through the requests with at least the required access,
+// answering 401 to the ones that should authenticate and 403 to the others.
+func (a authenticator) require(required string, handler accessHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		access, authenticated := a.access(r)
+		if !config.AccessAtLeast(access, required) {
+			if authenticated && access != config.AccessNone {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			if len(a.settings.Users) > 0 {
+				w.Header().Add("WWW-Authenticate", `Basic realm="ipv6ddns"`)
+			}
+			if len(a.settings.Tokens) > 0 || a.apiToken != "" {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="ipv6ddns"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r, access != config.AccessAdmin)
+	}
+}
+
+// newWebHandler returns the handler of every page of the web server. The
+// health checks are public, the reports need viewer access and triggering
+// updates admin access. The health checks only list the checks to the
+// requests authenticated with viewer access, the others just get the status.
+func newWebHandler(worker *ipv6ddns.Worker, settings config.WebServer, apiToken string) http.Handler {
+	auth := authenticator{settings: settings, apiToken: apiToken}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /{$}", auth.require(config.AccessViewer, func(w http.ResponseWriter, r *http.Request, hideSensible bool) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(wrapPrettyPrint(worker, "", hideSensible)))
+	}))
+	mux.HandleFunc("GET /metrics", auth.require(config.AccessViewer, func(w http.ResponseWriter, r *http.Request, hideSensible bool) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		worker.WriteMetrics(w)
+	}))
+	mux.HandleFunc("GET /api/v1/state", auth.require(config.AccessViewer, func(w http.ResponseWriter, r *http.Request, hideSensible bool) {
+		writeJSON(w, http.StatusOK, worker.Report(hideSensible))
+	}))
+	mux.HandleFunc("GET /api/v1/discovery", auth.require(config.AccessViewer, func(w http.ResponseWriter, r *http.Request, hideSensible bool) {
+		writeJSON(w, http.StatusOK, worker.DiscoveryReport())
+	}))
+	mux.HandleFunc("GET /api/v1/config", auth.require(config.AccessViewer, func(w http.ResponseWriter, r *http.Request, hideSensible bool) {
+		writeJSON(w, http.StatusOK, worker.ConfigReport(hideSensible))
+	}))
+	mux.HandleFunc("POST /api/v1/update", auth.require(config.AccessAdmin, updateHandler(worker)))
+	mux.HandleFunc("POST /api/v1/reset", auth.require(config.AccessAdmin, resetHandler(worker)))
+
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, worker.Health(), auth.authenticated(r, config.AccessViewer))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, worker.Ready(readyFailureThreshold), auth.authenticated(r, config.AccessViewer))
+	})
+
+	return mux
+}
+
+// listenAddresses returns the addresses the web server listens on, the
+// configured ones or else every address on -webserver_port.
+func listenAddresses(cfg config.Config) []string {
+	if len(cfg.WebServer.Listen) > 0 {
+		return cfg.WebServer.Listen
+	}
+	if webserverPort > 0 {
+		return []string{fmt.Sprintf(":%d", webserverPort)}
+	}
+	return nil
+}
+
+// startWebServer listens on every address and serves the handler on them,
+// over TLS if configured. It returns a nil server if it is disabled.
+func startWebServer(cfg config.Config, handler http.Handler, sugar *zap.SugaredLogger) (*http.Server, error) {
+	addresses := listenAddresses(cfg)
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	certFile, keyFile := cfg.WebServerTLSFiles()
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading TLS certificate: %w", err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+	}
+
+	var listeners []net.Listener
+	for _, address := range addresses {
+		listener, err := net.Listen(cfg.WebServer.Network(address), address)
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	for _, listener := range listeners {
+		go func() {
+			var err error
+			if server.TLSConfig != nil {
+				sugar.Infof("Starting web server on https://%s", listener.Addr())
+				err = server.ServeTLS(listener, "", "")
+			} else {
+				sugar.Infof("Starting web server on http://%s", listener.Addr())
+				err = server.Serve(listener)
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				sugar.Fatalf("web server failed: %s", err)
+			}
+		}()
+	}
+
+	return server, nil
+}
+
+// readToken reads the API token from the file, trimming the trailing newline.
+func readToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", errors.New("empty token")
+	}
+	return token, nil
+}
+
+// hostnameSelection reads the endpoint and hostname query parameters, an
+// absent or empty hostname selects every hostname and @ the apex one.
+func hostnameSelection(r *http.Request) (string, *string) {
+	query := r.URL.Query()
+	endpointKey := query.Get("endpoint")
+
+	hostname := query.Get("hostname")
+	if hostname == "" {
+		return endpointKey, nil
+	}
+	if hostname == "@" {
+		hostname = ""
+	}
+	return endpointKey, &hostname
+}
+
+// updateHandler updates the selected hostnames right away and answers with
+// the results, 502 if any of them failed.
+func updateHandler(worker *ipv6ddns.Worker) accessHandler {
+	return func(w http.ResponseWriter, r *http.Request, hideSensible bool) {
+		endpointKey, hostnameKey := hostnameSelection(r)
+		resync := r.URL.Query().Get("resync") == "true"
+
+		results, err := worker.UpdateNow(endpointKey, hostnameKey, resync, hideSensible)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		status := http.StatusOK
+		for _, result := range results {
+			if result.Error != "" {
+				status = http.StatusBadGateway
+			}
+		}
+		writeJSON(w, status, results)
+	}
+}
+
+// resetHandler clears the errors and backoff of the selected hostnames.
+func resetHandler(worker *ipv6ddns.Worker) accessHandler {
+	return func(w http.ResponseWriter, r *http.Request, hideSensible bool) {
+		endpointKey, hostnameKey := hostnameSelection(r)
+
+		results, err := worker.Reset(endpointKey, hostnameKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, results)
+	}
+}
+
+// writeHealth writes the health report, with status 503 if a check failed.
+// Without details only the status is written, the checks name the tasks and
+// the domains.
+func writeHealth(w http.ResponseWriter, report ipv6ddns.HealthReport, details bool) {
+	status := http.StatusOK
+	if !report.OK {
+		status = http.StatusServiceUnavailable
+	}
+	if !details {
+		writeJSON(w, status, struct {
+			OK bool `json:"ok"`
+		}{report.OK})
+		return
+	}
+	writeJSON(w, status, report)
+}
+
+// writeJSON writes v as the indented JSON response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// CleanupKeep leaves the records of removed hostnames at the provider.
+	CleanupKeep = "keep"
+	// CleanupDelete removes the records of removed hostnames as soon as they are detected.
+	CleanupDelete = "delete"
+	// CleanupDeleteAfter removes the records of removed hostnames once the grace period has passed.
+	CleanupDeleteAfter = "delete_after"
+)
+
+// Cleanup is the policy applied to the records of hostnames that were
+// published by ipv6ddns and are no longer present in the configuration.
+type Cleanup struct {
+	Policy      string        `json:"policy"`
+	GracePeriod time.Duration `json:"grace_period,omitempty"`
+}
+
+func (c *Cleanup) UnmarshalJSON(b []byte) error {
+	type Alias Cleanup
+	aux := &struct {
+		GracePeriod interface{} `json:"grace_period"`
+		*Alias
+	}{
+		Alias: (*Alias)(c),
+	}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	if aux.GracePeriod == nil {
+		aux.GracePeriod = "24h"
+	}
+
+	var err error
+	c.GracePeriod, err = parseDuration("grace period", aux.GracePeriod)
+	return err
+}
+
+func (c Cleanup) MarshalJSON() ([]byte, error) {
+	type Alias Cleanup
+	return json.Marshal(&struct {
+		GracePeriod string `json:"grace_period,omitempty"`
+		*Alias
+	}{
+		GracePeriod: formatDuration(c.GracePeriod),
+		Alias:       (*Alias)(&c),
+	})
+}
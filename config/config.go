@@ -4,11 +4,13 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/xeipuuv/gojsonschema"
 	"sigs.k8s.io/yaml"
@@ -22,6 +24,12 @@ type Config struct {
 	Tasks       map[string]Task       `json:"tasks"`
 	Credentials map[string]Credential `json:"credentials"`
 	Discovery   Discovery             `json:"discovery"`
+	// StateFile persists the published hostnames across restarts, disabled if empty.
+	StateFile string  `json:"state_file,omitempty"`
+	Cleanup   Cleanup `json:"cleanup"`
+	// Warmup holds the DNS record removals after start until discovery has seen the network.
+	Warmup    time.Duration `json:"warmup"`
+	WebServer WebServer     `json:"webserver,omitzero"`
 }
 
 type Discovery struct {
@@ -35,6 +43,92 @@ type PluginConfig struct {
 	Params string `json:"params"`
 }
 
+func (c *Config) UnmarshalJSON(b []byte) error {
+	type Alias Config
+	aux := &struct {
+		Warmup interface{} `json:"warmup"`
+		*Alias
+	}{
+		Alias: (*Alias)(c),
+	}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	if aux.Warmup == nil {
+		return nil
+	}
+
+	var err error
+	c.Warmup, err = parseDuration("warmup", aux.Warmup)
+	return err
+}
+
+func (c Config) MarshalJSON() ([]byte, error) {
+	type Alias Config
+	return json.Marshal(&struct {
+		Warmup string `json:"warmup"`
+		*Alias
+	}{
+		Warmup: c.Warmup.String(),
+		Alias:  (*Alias)(&c),
+	})
+}
+
+// Redacted returns a copy of the configuration with the provider settings, the
+// plugin parameters, the IPv4 command arguments and the web server credentials
+// hidden, as they usually hold secrets.
+func (c Config) Redacted() Config {
+	hidden := json.RawMessage(`"<sensible data hidden>"`)
+
+	tasks := make(map[string]Task, len(c.Tasks))
+	for name, task := range c.Tasks {
+		endpoints := make(map[string][]Hostname, len(task.Endpoints))
+		for endpointKey, hostnames := range task.Endpoints {
+			redacted := slices.Clone(hostnames)
+			for i := range redacted {
+				if len(redacted[i].Settings) > 0 {
+					redacted[i].Settings = hidden
+				}
+			}
+			endpoints[endpointKey] = redacted
+		}
+		task.Endpoints = endpoints
+		if task.IPv4 != nil {
+			task.IPv4 = task.IPv4.redacted()
+		}
+		tasks[name] = task
+	}
+	c.Tasks = tasks
+
+	credentials := make(map[string]Credential, len(c.Credentials))
+	for alias, credential := range c.Credentials {
+		credential.RawSettings = hidden
+		credentials[alias] = credential
+	}
+	c.Credentials = credentials
+
+	plugins := make(map[string]PluginConfig, len(c.Discovery.Plugins))
+	for name, plugin := range c.Discovery.Plugins {
+		plugin.Params = "<sensible data hidden>"
+		plugins[name] = plugin
+	}
+	c.Discovery.Plugins = plugins
+
+	users := slices.Clone(c.WebServer.Users)
+	for i := range users {
+		users[i].Password = "<sensible data hidden>"
+	}
+	c.WebServer.Users = users
+	tokens := slices.Clone(c.WebServer.Tokens)
+	for i := range tokens {
+		tokens[i].Token = "<sensible data hidden>"
+	}
+	c.WebServer.Tokens = tokens
+
+	return c
+}
+
 func (c *Config) PrettyPrint(prefix string, hideSensible bool) string {
 	var result strings.Builder
 
@@ -54,7 +148,41 @@ func (c *Config) PrettyPrint(prefix string, hideSensible bool) string {
 		result.WriteString(prefix + "        " + name + ":\n")
 
 		if task.IPv4 != nil {
-			result.WriteString(task.IPv4.PrettyPrint(prefix + "            "))
+			result.WriteString(task.IPv4.PrettyPrint(prefix+"            ", hideSensible))
+		}
+
+		if len(task.ReverseZones) > 0 {
+			result.WriteString(prefix + "            Reverse zones: " + strings.Join(task.ReverseZones, ", ") + "\n")
+		}
+		if task.Inventory != "" {
+			result.WriteString(prefix + "            Inventory: " + c.InventoryPath(task) + "\n")
+		}
+		if len(task.RecordTypes) > 0 {
+			result.WriteString(prefix + "            Record types: " + strings.Join(task.RecordTypes, ", ") + "\n")
+		}
+		if task.EmptyPolicy != "" {
+			result.WriteString(prefix + "            Empty policy: " + task.EmptyPolicy + "\n")
+		}
+		if len(task.StaticAddresses) > 0 {
+			result.WriteString(prefix + "            Static addresses:\n")
+			for _, addr := range task.StaticAddresses {
+				result.WriteString(prefix + "                " + addr.String() + "\n")
+			}
+		}
+		if task.Stability != (Stability{}) {
+			result.WriteString(prefix + "            Stability:\n")
+			result.WriteString(prefix + "                Min age: " + task.Stability.MinAge.String() + "\n")
+			result.WriteString(prefix + "                Min sightings: " + fmt.Sprint(task.Stability.MinSightings) + "\n")
+			result.WriteString(prefix + "                Removal grace: " + task.Stability.RemovalGrace.String() + "\n")
+		}
+		if len(task.Selection.Prefer) > 0 || task.Selection.MaxA > 0 || task.Selection.MaxAAAA > 0 || task.Selection.MaxPerMAC > 0 {
+			result.WriteString(prefix + "            Selection:\n")
+			if len(task.Selection.Prefer) > 0 {
+				result.WriteString(prefix + "                Prefer: " + strings.Join(task.Selection.Prefer, ", ") + "\n")
+			}
+			result.WriteString(prefix + "                Max A: " + fmt.Sprint(task.Selection.MaxA) + "\n")
+			result.WriteString(prefix + "                Max AAAA: " + fmt.Sprint(task.Selection.MaxAAAA) + "\n")
+			result.WriteString(prefix + "                Max per MAC: " + fmt.Sprint(task.Selection.MaxPerMAC) + "\n")
 		}
 
 		for _, filter := range task.Filters {
@@ -107,16 +235,45 @@ func (c *Config) PrettyPrint(prefix string, hideSensible bool) string {
 			hostnames := task.Endpoints[endpointKey]
 
 			// Sort hostnames
-			sortedHostnames := make([]string, len(hostnames))
+			sortedHostnames := make([]Hostname, len(hostnames))
 			copy(sortedHostnames, hostnames)
-			sort.Strings(sortedHostnames)
+			sort.Slice(sortedHostnames, func(i, j int) bool {
+				return sortedHostnames[i].Key() < sortedHostnames[j].Key()
+			})
 
 			// Iterate over sorted hostnames
 			for _, hostname := range sortedHostnames {
-				if hostname == "" {
-					hostname = "@"
+				name := hostname.Name
+				if name == "" {
+					name = "@"
+				}
+				if hostname.Template != "" {
+					name = "template " + hostname.Template
+				}
+				result.WriteString(prefix + "                " + name + " (" + endpointKey + ")")
+				for _, mac := range hostname.MACs {
+					result.WriteString(" mac: " + mac.String())
+				}
+				if hostname.MaxHostnames > 0 {
+					result.WriteString(" max hostnames: " + fmt.Sprint(hostname.MaxHostnames))
+				}
+				if hostname.TTL > 0 {
+					result.WriteString(" ttl: " + hostname.TTL.String())
+				}
+				if len(hostname.RecordTypes) > 0 {
+					result.WriteString(" record types: " + strings.Join(hostname.RecordTypes, ", "))
 				}
-				result.WriteString(prefix + "                " + hostname + " (" + endpointKey + ")\n")
+				if hostname.Proxied != nil {
+					result.WriteString(fmt.Sprintf(" proxied: %t", *hostname.Proxied))
+				}
+				if len(hostname.Settings) > 0 {
+					if hideSensible {
+						result.WriteString(" settings: <sensible data hidden>")
+					} else {
+						result.WriteString(" settings: " + string(hostname.Settings))
+					}
+				}
+				result.WriteString("\n")
 			}
 		}
 	}
@@ -136,6 +293,13 @@ func (c *Config) PrettyPrint(prefix string, hideSensible bool) string {
 		result.WriteString(prefix + "        Endpoint: " + alias + "\n")
 		result.WriteString(prefix + "            Provider: " + credential.Provider + "\n")
 		result.WriteString(prefix + "            Debounce time: " + credential.DebounceTime.String() + "\n")
+		result.WriteString(prefix + "            Timeout: " + credential.Timeout.String() + "\n")
+		if credential.DryRun {
+			result.WriteString(prefix + "            Dry run: true\n")
+		}
+		if len(credential.ReverseZones) > 0 {
+			result.WriteString(prefix + "            Reverse zones: " + strings.Join(credential.ReverseZones, ", ") + "\n")
+		}
 
 		result.WriteString(prefix + "            Settings: ")
 		if hideSensible {
@@ -147,6 +311,20 @@ func (c *Config) PrettyPrint(prefix string, hideSensible bool) string {
 		}
 	}
 
+	result.WriteString(prefix + "    Warm-up: " + c.Warmup.String() + "\n")
+	if c.StateFile != "" {
+		result.WriteString(prefix + "    State file: " + c.StateFilePath() + "\n")
+	} else {
+		result.WriteString(prefix + "    State file: disabled\n")
+	}
+	result.WriteString(prefix + "    Cleanup: " + c.Cleanup.Policy)
+	if c.Cleanup.Policy == CleanupDeleteAfter {
+		result.WriteString(" (" + c.Cleanup.GracePeriod.String() + ")")
+	}
+	result.WriteString("\n")
+
+	result.WriteString(c.WebServer.PrettyPrint(prefix + "    "))
+
 	result.WriteString(prefix + "    Discovery:\n")
 	result.WriteString(prefix + "        Listen: " + fmt.Sprintf("%t", c.Discovery.Listen) + "\n")
 	result.WriteString(prefix + "        Active: " + fmt.Sprintf("%t", c.Discovery.Active) + "\n")
@@ -175,22 +353,201 @@ func (c *Config) PrettyPrint(prefix string, hideSensible bool) string {
 	return result.String()
 }
 
-func validateConfig(configData []byte) {
+func validateConfig(configData []byte) error {
 	schemaLoader := gojsonschema.NewBytesLoader(configSchema)
 	dataLoader := gojsonschema.NewBytesLoader(configData)
 
 	result, err := gojsonschema.Validate(schemaLoader, dataLoader)
 	if err != nil {
-		log.Fatal(err.Error())
+		return err
 	}
 
 	if !result.Valid() {
-		fmt.Printf("The configuration is NOT valid. Errors:\n")
+		var errs strings.Builder
 		for _, desc := range result.Errors() {
-			fmt.Printf("- %s\n", desc)
+			fmt.Fprintf(&errs, "\n- %s", desc)
 		}
-		os.Exit(1)
+		return fmt.Errorf("configuration is not valid:%s", errs.String())
 	}
+
+	return nil
+}
+
+// validate checks the references between the sections of the configuration.
+func (c *Config) validate() error {
+	for endpointKey, credential := range c.Credentials {
+		if len(credential.ReverseZones) > 0 && !slices.Contains(reverseZoneProviders, credential.Provider) {
+			return fmt.Errorf("credential %s sets reverse zones, which provider %s does not support", endpointKey, credential.Provider)
+		}
+	}
+
+	for taskName, task := range c.Tasks {
+		for endpointKey, hostnames := range task.Endpoints {
+			if _, ok := c.Credentials[endpointKey]; !ok {
+				return fmt.Errorf("task %s references unknown credential %s", taskName, endpointKey)
+			}
+			if len(task.ReverseZones) > 0 && !slices.Contains(reverseZoneProviders, c.Credentials[endpointKey].Provider) {
+				return fmt.Errorf("task %s sets reverse zones, which provider %s of endpoint %s does not support", taskName, c.Credentials[endpointKey].Provider, endpointKey)
+			}
+			for _, hostname := range hostnames {
+				if err := hostname.validateOverrides(c.Credentials[endpointKey].Provider); err != nil {
+					return fmt.Errorf("task %s endpoint %s: %w", taskName, endpointKey, err)
+				}
+				if other, ok := c.Hostname(endpointKey, hostname.Key()); ok && !reflect.DeepEqual(hostname, other) {
+					return fmt.Errorf("hostname %s of endpoint %s is configured with different options across tasks", hostname.Key(), endpointKey)
+				}
+				if hostname.Template != "" {
+					if _, err := hostname.ParseTemplate(); err != nil {
+						return fmt.Errorf("task %s endpoint %s: %w", taskName, endpointKey, err)
+					}
+				}
+			}
+		}
+		if task.EmptyPolicy == EmptyStatic && len(task.StaticAddresses) == 0 {
+			return fmt.Errorf("task %s uses the %s empty policy without static addresses", taskName, EmptyStatic)
+		}
+	}
+
+	return c.WebServer.validate()
+}
+
+// Warnings returns the settings that are valid but not fully effective
+// without a state file, as the published hostnames are forgotten on restart.
+func (c *Config) Warnings() []string {
+	if c.StateFile != "" {
+		return nil
+	}
+
+	var warnings []string
+	if c.Warmup > 0 {
+		warnings = append(warnings, fmt.Sprintf("no state_file set, the addresses published before a restart are only kept during the %s warm-up and the hostnames are all updated again", c.Warmup))
+	}
+	if c.Cleanup.Policy != CleanupKeep {
+		warnings = append(warnings, fmt.Sprintf("no state_file set, the %s cleanup policy can't remove the hostnames removed from the configuration across a restart", c.Cleanup.Policy))
+	}
+	return warnings
+}
+
+// StateFilePath returns the path of the state file, relative paths are
+// resolved against the directory of the configuration file. It is empty when
+// the state is not persisted.
+func (c *Config) StateFilePath() string {
+	if c.StateFile == "" || filepath.IsAbs(c.StateFile) {
+		return c.StateFile
+	}
+	return filepath.Join(c.BaseDir, c.StateFile)
+}
+
+// Hostnames returns the set of hostnames configured for each endpoint across
+// all tasks, the ones generated by templates are not known here.
+func (c *Config) Hostnames() map[string]map[string]bool {
+	result := make(map[string]map[string]bool)
+	for _, task := range c.Tasks {
+		for endpointKey, hostnames := range task.Endpoints {
+			if _, ok := result[endpointKey]; !ok {
+				result[endpointKey] = make(map[string]bool)
+			}
+			for _, hostname := range hostnames {
+				if hostname.Template == "" {
+					result[endpointKey][hostname.Name] = true
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// Hostname returns the options of a hostname of an endpoint by its key, as
+// found in the first task that lists it.
+func (c *Config) Hostname(endpointKey string, key string) (Hostname, bool) {
+	taskNames := make([]string, 0, len(c.Tasks))
+	for taskName := range c.Tasks {
+		taskNames = append(taskNames, taskName)
+	}
+	sort.Strings(taskNames)
+
+	for _, taskName := range taskNames {
+		for _, hostname := range c.Tasks[taskName].Endpoints[endpointKey] {
+			if hostname.Key() == key {
+				return hostname, true
+			}
+		}
+	}
+
+	return Hostname{}, false
+}
+
+// SharedHostnames returns the hostnames listed by more than one task, by
+// endpoint and hostname key, with the sorted names of those tasks. Their
+// hostnames publish the union of the addresses of every task.
+func (c *Config) SharedHostnames() map[string]map[string][]string {
+	tasks := make(map[string]map[string][]string)
+	for taskName, task := range c.Tasks {
+		for endpointKey, hostnames := range task.Endpoints {
+			if tasks[endpointKey] == nil {
+				tasks[endpointKey] = make(map[string][]string)
+			}
+			for _, hostname := range hostnames {
+				if !slices.Contains(tasks[endpointKey][hostname.Key()], taskName) {
+					tasks[endpointKey][hostname.Key()] = append(tasks[endpointKey][hostname.Key()], taskName)
+				}
+			}
+		}
+	}
+
+	result := make(map[string]map[string][]string)
+	for endpointKey, hostnames := range tasks {
+		for key, taskNames := range hostnames {
+			if len(taskNames) < 2 {
+				continue
+			}
+			if result[endpointKey] == nil {
+				result[endpointKey] = make(map[string][]string)
+			}
+			slices.Sort(taskNames)
+			result[endpointKey][key] = taskNames
+		}
+	}
+
+	return result
+}
+
+// ReverseZones returns the reverse zones where the PTR records of a hostname
+// of an endpoint, by its key, are kept. Those of every task listing it, or
+// else the ones of the credential.
+func (c *Config) ReverseZones(endpointKey string, key string) []string {
+	var zones []string
+	for _, task := range c.Tasks {
+		if !slices.ContainsFunc(task.Endpoints[endpointKey], func(h Hostname) bool { return h.Key() == key }) {
+			continue
+		}
+		for _, zone := range task.ReverseZones {
+			if !slices.Contains(zones, zone) {
+				zones = append(zones, zone)
+			}
+		}
+	}
+	if len(zones) == 0 {
+		return c.Credentials[endpointKey].ReverseZones
+	}
+
+	slices.Sort(zones)
+	return zones
+}
+
+// UsesReverseZones reports whether the PTR records of any hostname of an
+// endpoint are kept in reverse zones.
+func (c *Config) UsesReverseZones(endpointKey string) bool {
+	if len(c.Credentials[endpointKey].ReverseZones) > 0 {
+		return true
+	}
+	for _, task := range c.Tasks {
+		if _, ok := task.Endpoints[endpointKey]; ok && len(task.ReverseZones) > 0 {
+			return true
+		}
+	}
+	return false
 }
 
 func NewConfig(filename string) (config Config, err error) {
@@ -206,15 +563,28 @@ func NewConfig(filename string) (config Config, err error) {
 		}
 	}
 
-	validateConfig(byteValue)
+	err = validateConfig(byteValue)
+	if err != nil {
+		return config, err
+	}
 
 	// Set defaults
 	config.Discovery.Listen = true
 	config.Discovery.Active = true
+	config.Cleanup.Policy = CleanupKeep
+	config.Warmup = time.Minute
 
 	err = json.Unmarshal(byteValue, &config)
+	if err != nil {
+		return config, err
+	}
 
 	config.BaseDir = filepath.Dir(filename)
 
-	return config, err
+	err = config.expandInventories()
+	if err != nil {
+		return config, err
+	}
+
+	return config, config.validate()
 }
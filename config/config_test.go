@@ -1,9 +1,12 @@
 package config
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewConfig(t *testing.T) {
@@ -172,4 +175,621 @@ credentials:
 			t.Error("Filter Source mismatch")
 		}
 	})
+
+	t.Run("Reject Invalid Config", func(t *testing.T) {
+		yamlContent := `
+tasks:
+  my_task:
+    unknown_option: true
+    endpoints:
+      creds: ["host"]
+credentials:
+  creds:
+    provider: test
+    settings: {}
+`
+		path := filepath.Join(tempDir, "config_invalid.yaml")
+		_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+		_, err := NewConfig(path)
+		if err == nil {
+			t.Fatal("Expected an error for an invalid config")
+		}
+	})
+
+	t.Run("Reject Unknown Credential", func(t *testing.T) {
+		yamlContent := `
+tasks:
+  my_task:
+    endpoints:
+      missing: ["host"]
+credentials: {}
+`
+		path := filepath.Join(tempDir, "config_unknown_credential.yaml")
+		_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+		_, err := NewConfig(path)
+		if err == nil {
+			t.Fatal("Expected an error for a task referencing an unknown credential")
+		}
+	})
+	t.Run("Reject Static Empty Policy Without Addresses", func(t *testing.T) {
+		yamlContent := `
+tasks:
+  my_task:
+    empty_policy: static
+    endpoints:
+      my_cred: ["host"]
+credentials:
+  my_cred:
+    provider: duckdns
+    settings: {}
+`
+		path := filepath.Join(tempDir, "config_static_empty.yaml")
+		_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+		_, err := NewConfig(path)
+		if err == nil {
+			t.Fatal("Expected an error for the static empty policy without static addresses")
+		}
+	})
+
+	t.Run("Load Hostname Options", func(t *testing.T) {
+		yamlContent := `
+tasks:
+  my_task:
+    endpoints:
+      my_cred:
+        - plain
+        - name: custom
+          ttl: 5m
+          record_types: [AAAA]
+          proxied: false
+          settings:
+            zone: other.com
+credentials:
+  my_cred:
+    provider: cloudflare
+    settings:
+      zone: example.com
+      ttl: 1h
+      proxied: true
+`
+		path := filepath.Join(tempDir, "config_hostnames.yaml")
+		_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+		cfg, err := NewConfig(path)
+		if err != nil {
+			t.Fatalf("NewConfig failed: %v", err)
+		}
+
+		hostnames := cfg.Tasks["my_task"].Endpoints["my_cred"]
+		if len(hostnames) != 2 || hostnames[0].Name != "plain" || hostnames[0].Overrides() {
+			t.Fatalf("Unexpected plain hostname: %+v", hostnames)
+		}
+		custom := hostnames[1]
+		if custom.Name != "custom" || custom.TTL != 5*time.Minute || !custom.Overrides() {
+			t.Fatalf("Unexpected custom hostname: %+v", custom)
+		}
+		if custom.RecordTypes.Publishes(RecordTypeA) || !custom.RecordTypes.Publishes(RecordTypeAAAA) {
+			t.Error("Expected only AAAA records for the custom hostname")
+		}
+
+		settings, err := custom.ProviderSettings(cfg.Credentials["my_cred"].RawSettings)
+		if err != nil {
+			t.Fatalf("ProviderSettings failed: %v", err)
+		}
+		want := `{"proxied":false,"ttl":"5m0s","zone":"other.com"}`
+		if string(settings) != want {
+			t.Errorf("ProviderSettings = %s, want %s", settings, want)
+		}
+	})
+
+	t.Run("Reject Conflicting Hostname Options", func(t *testing.T) {
+		yamlContent := `
+tasks:
+  task_a:
+    endpoints:
+      my_cred: [{name: host, ttl: 5m}]
+  task_b:
+    endpoints:
+      my_cred: [host]
+credentials:
+  my_cred:
+    provider: cloudflare
+    settings: {}
+`
+		path := filepath.Join(tempDir, "config_conflicting_hostnames.yaml")
+		_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+		_, err := NewConfig(path)
+		if err == nil {
+			t.Fatal("Expected an error for a hostname configured with different options")
+		}
+	})
+
+	t.Run("Load Hostname Template", func(t *testing.T) {
+		yamlContent := `
+tasks:
+  lab:
+    endpoints:
+      my_cred:
+        - template: '{{ .MAC | replace ":" "-" }}.lab'
+          max_hostnames: 10
+credentials:
+  my_cred:
+    provider: duckdns
+    settings: {}
+`
+		path := filepath.Join(tempDir, "config_template.yaml")
+		_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+		cfg, err := NewConfig(path)
+		if err != nil {
+			t.Fatalf("NewConfig failed: %v", err)
+		}
+
+		hostname := cfg.Tasks["lab"].Endpoints["my_cred"][0]
+		if hostname.MaxHostnames != 10 || hostname.Key() != hostname.Template {
+			t.Fatalf("Unexpected templated hostname: %+v", hostname)
+		}
+		if len(cfg.Hostnames()["my_cred"]) != 0 {
+			t.Errorf("Expected no configured hostnames, got %v", cfg.Hostnames())
+		}
+
+		tmpl, err := hostname.ParseTemplate()
+		if err != nil {
+			t.Fatalf("ParseTemplate failed: %v", err)
+		}
+		var name strings.Builder
+		if err := tmpl.Execute(&name, struct{ MAC string }{"00:11:22:33:44:55"}); err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		if name.String() != "00-11-22-33-44-55.lab" || !ValidHostname(name.String()) {
+			t.Errorf("Unexpected generated hostname %q", name.String())
+		}
+	})
+
+	t.Run("Reject Invalid Hostname Template", func(t *testing.T) {
+		yamlContent := `
+tasks:
+  lab:
+    endpoints:
+      my_cred:
+        - template: 'host-{{ .IIDHex'
+credentials:
+  my_cred:
+    provider: duckdns
+    settings: {}
+`
+		path := filepath.Join(tempDir, "config_invalid_template.yaml")
+		_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+		_, err := NewConfig(path)
+		if err == nil {
+			t.Fatal("Expected an error for an invalid hostname template")
+		}
+	})
+
+	t.Run("Load Inventory", func(t *testing.T) {
+		ethers := `
+# lab devices
+00:11:22:33:44:55 printer
+00:11:22:33:44:56  nas
+00:11:22:33:44:57  nas # second interface
+`
+		csvContent := "mac,hostname,endpoint\n00:11:22:33:44:58,camera,other_cred\n"
+		_ = os.WriteFile(filepath.Join(tempDir, "ethers"), []byte(ethers), 0644)
+		_ = os.WriteFile(filepath.Join(tempDir, "devices.csv"), []byte(csvContent), 0644)
+
+		yamlContent := `
+tasks:
+  lab:
+    inventory: ethers
+    endpoints:
+      my_cred: [router]
+  cameras:
+    inventory: devices.csv
+    endpoints:
+      my_cred: []
+      other_cred: []
+credentials:
+  my_cred:
+    provider: duckdns
+    settings: {}
+  other_cred:
+    provider: duckdns
+    settings: {}
+`
+		path := filepath.Join(tempDir, "config_inventory.yaml")
+		_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+		cfg, err := NewConfig(path)
+		if err != nil {
+			t.Fatalf("NewConfig failed: %v", err)
+		}
+
+		hostnames := cfg.Tasks["lab"].Endpoints["my_cred"]
+		if len(hostnames) != 3 || hostnames[0].Name != "router" || len(hostnames[0].MACs) != 0 {
+			t.Fatalf("Unexpected hostnames: %+v", hostnames)
+		}
+		if hostnames[1].Name != "printer" || len(hostnames[1].MACs) != 1 || hostnames[1].MACs[0].String() != "00:11:22:33:44:55" {
+			t.Errorf("Unexpected printer hostname: %+v", hostnames[1])
+		}
+		if hostnames[2].Name != "nas" || len(hostnames[2].MACs) != 2 {
+			t.Errorf("Expected both MAC addresses for nas, got %+v", hostnames[2])
+		}
+
+		cameras := cfg.Tasks["cameras"].Endpoints
+		if len(cameras["my_cred"]) != 0 || len(cameras["other_cred"]) != 1 || cameras["other_cred"][0].Name != "camera" {
+			t.Errorf("Expected camera only on other_cred, got %+v", cameras)
+		}
+
+		want := []string{filepath.Join(tempDir, "devices.csv"), filepath.Join(tempDir, "ethers")}
+		if files := cfg.InventoryFiles(); len(files) != 2 || files[0] != want[0] || files[1] != want[1] {
+			t.Errorf("InventoryFiles = %v, want %v", files, want)
+		}
+	})
+
+	t.Run("Reject Invalid Inventory", func(t *testing.T) {
+		_ = os.WriteFile(filepath.Join(tempDir, "invalid.yaml"), []byte("- mac: not-a-mac\n  hostname: host\n"), 0644)
+
+		yamlContent := `
+tasks:
+  lab:
+    inventory: invalid.yaml
+    endpoints:
+      my_cred: []
+credentials:
+  my_cred:
+    provider: duckdns
+    settings: {}
+`
+		path := filepath.Join(tempDir, "config_invalid_inventory.yaml")
+		_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+		_, err := NewConfig(path)
+		if err == nil {
+			t.Fatal("Expected an error for an invalid inventory entry")
+		}
+	})
+
+	t.Run("Reject Conflicting Inventory", func(t *testing.T) {
+		_ = os.WriteFile(filepath.Join(tempDir, "conflicting.csv"), []byte("00:11:22:33:44:55,printer\n00:11:22:33:44:56,camera,other_cred\n"), 0644)
+		_ = os.WriteFile(filepath.Join(tempDir, "other.csv"), []byte("00:11:22:33:44:57,printer\n"), 0644)
+
+		tests := []struct {
+			name  string
+			tasks string
+			want  string
+		}{
+			{"Hostname Also Configured", `
+  lab:
+    inventory: conflicting.csv
+    endpoints:
+      my_cred: [printer]
+      other_cred: []`, "inventory file " + filepath.Join(tempDir, "conflicting.csv") + ": hostname printer of endpoint my_cred is also configured by task lab"},
+			{"Hostname In Another Inventory", `
+  lab:
+    inventory: conflicting.csv
+    endpoints:
+      my_cred: []
+      other_cred: []
+  office:
+    inventory: other.csv
+    endpoints:
+      my_cred: []`, "is also listed by inventory file " + filepath.Join(tempDir, "conflicting.csv")},
+			{"Endpoint Not Listed", `
+  lab:
+    inventory: conflicting.csv
+    endpoints:
+      my_cred: []`, "uses endpoint other_cred, which the task does not list"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				yamlContent := `
+tasks:` + tt.tasks + `
+credentials:
+  my_cred:
+    provider: duckdns
+    settings: {}
+  other_cred:
+    provider: duckdns
+    settings: {}
+`
+				path := filepath.Join(tempDir, "config_conflicting_inventory.yaml")
+				_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+				_, err := NewConfig(path)
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+				}
+			})
+		}
+	})
+
+	t.Run("Load Shared Hostname", func(t *testing.T) {
+		yamlContent := `
+tasks:
+  ipv6:
+    record_types: [AAAA]
+    endpoints:
+      my_cred: [host, other]
+  ipv4:
+    record_types: [A]
+    endpoints:
+      my_cred: [host]
+credentials:
+  my_cred:
+    provider: duckdns
+    settings: {}
+`
+		path := filepath.Join(tempDir, "config_shared_hostname.yaml")
+		_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+		cfg, err := NewConfig(path)
+		if err != nil {
+			t.Fatalf("NewConfig failed: %v", err)
+		}
+
+		shared := cfg.SharedHostnames()
+		if len(shared) != 1 || len(shared["my_cred"]) != 1 {
+			t.Fatalf("Unexpected shared hostnames: %v", shared)
+		}
+		if tasks := shared["my_cred"]["host"]; len(tasks) != 2 || tasks[0] != "ipv4" || tasks[1] != "ipv6" {
+			t.Errorf("Expected host shared by ipv4 and ipv6, got %v", tasks)
+		}
+	})
+
+	t.Run("Marshal Redacted Config", func(t *testing.T) {
+		yamlContent := `
+tasks:
+  my_task:
+    filter:
+      - ip:
+          prefix: "2001:db8::/64"
+    stability:
+      min_age: 5m
+    ipv4:
+      interval: 1m
+      command: curl
+      args: ["-u", "user:secret_password", "https://example.com/ip"]
+      lifetime: 5m
+    endpoints:
+      my_cred:
+        - host
+        - name: other
+          ttl: 2m
+          settings:
+            zone_id: "secret_zone"
+        - template: "{{ .MAC }}"
+credentials:
+  my_cred:
+    provider: cloudflare
+    debounce_time: 1s
+    settings:
+      api_token: "secret_token"
+discovery:
+  plugins:
+    router:
+      type: mikrotik
+      params: "secret_params"
+`
+		path := filepath.Join(tempDir, "config_redacted.yaml")
+		_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+		cfg, err := NewConfig(path)
+		if err != nil {
+			t.Fatalf("NewConfig failed: %v", err)
+		}
+
+		redacted, err := json.Marshal(cfg.Redacted())
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if strings.Contains(string(redacted), "secret") {
+			t.Errorf("Expected secrets hidden, got %s", redacted)
+		}
+		if printed := cfg.PrettyPrint("", true); strings.Contains(printed, "secret") {
+			t.Errorf("Expected secrets hidden, got %s", printed)
+		}
+		if len(cfg.Tasks["my_task"].IPv4.Args) != 3 {
+			t.Errorf("Expected the original IPv4 arguments untouched, got %v", cfg.Tasks["my_task"].IPv4.Args)
+		}
+		if cfg.Credentials["my_cred"].RawSettings == nil || !strings.Contains(string(cfg.Credentials["my_cred"].RawSettings), "secret_token") {
+			t.Errorf("Expected the original config untouched")
+		}
+
+		// the marshaled config loads back to the same settings
+		marshaled, err := json.Marshal(cfg)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		path = filepath.Join(tempDir, "config_marshaled.json")
+		_ = os.WriteFile(path, marshaled, 0644)
+
+		reloaded, err := NewConfig(path)
+		if err != nil {
+			t.Fatalf("NewConfig failed for %s: %v", marshaled, err)
+		}
+		if reloaded.Credentials["my_cred"].DebounceTime != time.Second {
+			t.Errorf("Expected debounce time 1s, got %v", reloaded.Credentials["my_cred"].DebounceTime)
+		}
+		if reloaded.Tasks["my_task"].Stability.MinAge != 5*time.Minute {
+			t.Errorf("Expected min age 5m, got %v", reloaded.Tasks["my_task"].Stability.MinAge)
+		}
+		if hostnames := reloaded.Tasks["my_task"].Endpoints["my_cred"]; len(hostnames) != 3 || hostnames[1].TTL != 2*time.Minute || hostnames[2].Template == "" {
+			t.Errorf("Unexpected hostnames: %+v", hostnames)
+		}
+	})
+
+	t.Run("Load Web Server", func(t *testing.T) {
+		yamlContent := `
+tasks: {}
+credentials: {}
+webserver:
+  listen: ["[::]:8053", "127.0.0.1:8053"]
+  ipv6_only: true
+  users:
+    - username: admin
+      password: secret_password
+      access: admin
+  tokens:
+    - token: secret_token_0123456789
+      access: viewer
+`
+		path := filepath.Join(tempDir, "config_webserver.yaml")
+		_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+		cfg, err := NewConfig(path)
+		if err != nil {
+			t.Fatalf("NewConfig failed: %v", err)
+		}
+
+		if cfg.WebServer.Anonymous() != AccessNone {
+			t.Errorf("Expected no anonymous access with users, got %s", cfg.WebServer.Anonymous())
+		}
+		if network := cfg.WebServer.Network("[::]:8053"); network != "tcp6" {
+			t.Errorf("Expected tcp6 for [::], got %s", network)
+		}
+		if network := cfg.WebServer.Network("127.0.0.1:8053"); network != "tcp" {
+			t.Errorf("Expected tcp for 127.0.0.1, got %s", network)
+		}
+
+		redacted, err := json.Marshal(cfg.Redacted())
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if strings.Contains(string(redacted), "secret") {
+			t.Errorf("Expected secrets hidden, got %s", redacted)
+		}
+		for _, hideSensible := range []bool{true, false} {
+			printed := cfg.PrettyPrint("", hideSensible)
+			if strings.Contains(printed, "secret") {
+				t.Errorf("Expected secrets never printed, got %s", printed)
+			}
+			if !strings.Contains(printed, "Token: (viewer)") {
+				t.Errorf("Expected the token access printed, got %s", printed)
+			}
+		}
+	})
+
+	t.Run("Reject Web Server Without TLS Key", func(t *testing.T) {
+		yamlContent := `
+tasks: {}
+credentials: {}
+webserver:
+  tls_cert: cert.pem
+`
+		path := filepath.Join(tempDir, "config_webserver_tls.yaml")
+		_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+		if _, err := NewConfig(path); err == nil {
+			t.Error("Expected an error for a TLS certificate without key")
+		}
+	})
+
+	t.Run("Reject Unsupported Hostname Overrides", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			provider string
+			hostname string
+			want     string
+		}{
+			{"TTL On DuckDNS", "duckdns", "{name: host, ttl: 5m}", "provider duckdns"},
+			{"Proxied On Route53", "route53", "{name: host, proxied: true}", "provider route53"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				yamlContent := `
+tasks:
+  my_task:
+    endpoints:
+      my_cred: [` + tt.hostname + `]
+credentials:
+  my_cred:
+    provider: ` + tt.provider + `
+    settings: {}
+`
+				path := filepath.Join(tempDir, "config_unsupported_overrides.yaml")
+				_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+				_, err := NewConfig(path)
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("Expected an error naming the %s, got %v", tt.want, err)
+				}
+			})
+		}
+	})
+	t.Run("Reject Unsupported Reverse Zones", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			credential string
+			task       string
+			want       string
+		}{
+			{"On The Credential", "reverse_zones: [2.0.192.in-addr.arpa]", "", "credential my_cred sets reverse zones"},
+			{"On The Task", "", "reverse_zones: [2.0.192.in-addr.arpa]", "task my_task sets reverse zones"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				yamlContent := `
+tasks:
+  my_task:
+    ` + tt.task + `
+    endpoints:
+      my_cred: [host]
+credentials:
+  my_cred:
+    provider: mikrotik
+    ` + tt.credential + `
+    settings: {address: 192.168.88.1:8728, username: admin, password: password, zone: lan, ttl: 5m}
+`
+				path := filepath.Join(tempDir, "config_unsupported_reverse_zones.yaml")
+				_ = os.WriteFile(path, []byte(yamlContent), 0644)
+
+				_, err := NewConfig(path)
+				if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), "provider mikrotik") {
+					t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+				}
+			})
+		}
+	})
+	t.Run("Warn Without State File", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			settings string
+			want     []string
+		}{
+			{"Defaults", "", []string{"1m0s warm-up"}},
+			{"Delete Cleanup", "warmup: 0s\ncleanup: {policy: delete}", []string{"delete cleanup policy"}},
+			{"No Warm-up", "warmup: 0s", nil},
+			{"State File", "state_file: state.json\ncleanup: {policy: delete}", nil},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				path := filepath.Join(tempDir, "config_warnings.yaml")
+				_ = os.WriteFile(path, []byte(yamlContent+tt.settings+"\n"), 0644)
+
+				loadedConfig, err := NewConfig(path)
+				if err != nil {
+					t.Fatalf("NewConfig failed: %v", err)
+				}
+
+				warnings := loadedConfig.Warnings()
+				if len(warnings) != len(tt.want) {
+					t.Fatalf("Warnings() = %v, want %d warnings", warnings, len(tt.want))
+				}
+				for i, want := range tt.want {
+					if !strings.Contains(warnings[i], want) {
+						t.Errorf("Warnings()[%d] = %q, want it containing %q", i, warnings[i], want)
+					}
+				}
+			})
+		}
+	})
 }
@@ -2,27 +2,66 @@ package config
 
 import (
 	"encoding/json"
-	"errors"
-	"fmt"
+	"math"
+	"math/rand/v2"
 	"time"
 )
 
 type Credential struct {
-	Provider     string          `json:"provider"`
-	DebounceTime time.Duration   `json:"debounce_time,omitempty"`
-	RetryTime    time.Duration   `json:"retry_time,omitempty"`
+	Provider     string        `json:"provider"`
+	DebounceTime time.Duration `json:"debounce_time,omitempty"`
+	MaxDelay     time.Duration `json:"max_delay,omitempty"`
+	RetryTime    time.Duration `json:"retry_time,omitempty"`
+	// ResyncInterval re-runs the update of every hostname to repair changes
+	// made at the provider, zero disables it.
+	ResyncInterval time.Duration `json:"resync_interval,omitempty"`
+	// Timeout bounds every update, a provider not answering in time is retried.
+	// Zero disables it.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// DryRun only computes the changes against the live records, without applying them.
+	DryRun bool `json:"dry_run,omitempty"`
+	// ReverseZones are the reverse zones hosted by the provider where the PTR
+	// records of the published addresses are kept.
+	ReverseZones []string        `json:"reverse_zones,omitempty"`
+	Retry        RetryPolicy     `json:"retry"`
 	RawSettings  json.RawMessage `json:"settings"`
 }
 
+// reverseZoneProviders are the providers able to manage the PTR records of
+// reverse zones. RouterOS static DNS entries can't be of type PTR, and there is
+// no RFC 2136 provider yet.
+var reverseZoneProviders = []string{"cloudflare", "route53", "technitium"}
+
+// RetryPolicy controls how failed updates are retried. The wait starts at the
+// credential RetryTime and is multiplied on every consecutive failure up to
+// MaxTime. Permanent errors, like rejected credentials, wait PermanentTime.
+type RetryPolicy struct {
+	MaxTime       time.Duration `json:"max_time"`
+	Multiplier    float64       `json:"multiplier"`
+	Jitter        float64       `json:"jitter"`
+	MaxAttempts   int           `json:"max_attempts"`
+	PermanentTime time.Duration `json:"permanent_time"`
+}
+
 func (c *Credential) UnmarshalJSON(b []byte) error {
 	type Alias Credential
 	aux := &struct {
-		DebounceTime interface{} `json:"debounce_time"`
-		RetryTime    interface{} `json:"retry_time"`
+		DebounceTime   interface{} `json:"debounce_time"`
+		MaxDelay       interface{} `json:"max_delay"`
+		RetryTime      interface{} `json:"retry_time"`
+		ResyncInterval interface{} `json:"resync_interval"`
+		Timeout        interface{} `json:"timeout"`
 		*Alias
 	}{
 		Alias: (*Alias)(c),
 	}
+
+	c.Retry = RetryPolicy{
+		MaxTime:    30 * time.Minute,
+		Multiplier: 2,
+		Jitter:     0.1,
+	}
+
 	if err := json.Unmarshal(b, &aux); err != nil {
 		return err
 	}
@@ -30,35 +69,132 @@ func (c *Credential) UnmarshalJSON(b []byte) error {
 	if aux.DebounceTime == nil {
 		aux.DebounceTime = "10s"
 	}
+	if aux.MaxDelay == nil {
+		aux.MaxDelay = "60s"
+	}
 	if aux.RetryTime == nil {
 		aux.RetryTime = "60s"
 	}
+	if aux.Timeout == nil {
+		aux.Timeout = "60s"
+	}
+
+	var err error
+	c.DebounceTime, err = parseDuration("debounce time", aux.DebounceTime)
+	if err != nil {
+		return err
+	}
 
-	switch value := aux.DebounceTime.(type) {
-	case float64:
-		c.DebounceTime = time.Duration(value) * time.Second
-	case string:
-		var err error
-		c.DebounceTime, err = time.ParseDuration(value)
+	c.MaxDelay, err = parseDuration("max delay", aux.MaxDelay)
+	if err != nil {
+		return err
+	}
+	if c.MaxDelay < c.DebounceTime {
+		c.MaxDelay = c.DebounceTime
+	}
+
+	c.RetryTime, err = parseDuration("retry time", aux.RetryTime)
+	if err != nil {
+		return err
+	}
+
+	c.Timeout, err = parseDuration("timeout", aux.Timeout)
+	if err != nil {
+		return err
+	}
+
+	if aux.ResyncInterval != nil {
+		c.ResyncInterval, err = parseDuration("resync interval", aux.ResyncInterval)
 		if err != nil {
 			return err
 		}
-	default:
-		return errors.New("invalid debounce time: " + fmt.Sprintf("%#v", value))
 	}
 
-	switch value := aux.RetryTime.(type) {
-	case float64:
-		c.RetryTime = time.Duration(value) * time.Second
-	case string:
-		var err error
-		c.RetryTime, err = time.ParseDuration(value)
+	if c.Retry.MaxTime < c.RetryTime {
+		c.Retry.MaxTime = c.RetryTime
+	}
+	if c.Retry.PermanentTime == 0 {
+		c.Retry.PermanentTime = c.Retry.MaxTime
+	}
+
+	return nil
+}
+
+func (c Credential) MarshalJSON() ([]byte, error) {
+	type Alias Credential
+	return json.Marshal(&struct {
+		DebounceTime   string `json:"debounce_time,omitempty"`
+		MaxDelay       string `json:"max_delay,omitempty"`
+		RetryTime      string `json:"retry_time,omitempty"`
+		ResyncInterval string `json:"resync_interval,omitempty"`
+		Timeout        string `json:"timeout,omitempty"`
+		*Alias
+	}{
+		DebounceTime:   formatDuration(c.DebounceTime),
+		MaxDelay:       formatDuration(c.MaxDelay),
+		RetryTime:      formatDuration(c.RetryTime),
+		ResyncInterval: formatDuration(c.ResyncInterval),
+		Timeout:        formatDuration(c.Timeout),
+		Alias:          (*Alias)(&c),
+	})
+}
+
+func (r RetryPolicy) MarshalJSON() ([]byte, error) {
+	type Alias RetryPolicy
+	return json.Marshal(&struct {
+		MaxTime       string `json:"max_time"`
+		PermanentTime string `json:"permanent_time"`
+		*Alias
+	}{
+		MaxTime:       r.MaxTime.String(),
+		PermanentTime: r.PermanentTime.String(),
+		Alias:         (*Alias)(&r),
+	})
+}
+
+func (r *RetryPolicy) UnmarshalJSON(b []byte) error {
+	type Alias RetryPolicy
+	aux := &struct {
+		MaxTime       interface{} `json:"max_time"`
+		PermanentTime interface{} `json:"permanent_time"`
+		*Alias
+	}{
+		Alias: (*Alias)(r),
+	}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	var err error
+	if aux.MaxTime != nil {
+		r.MaxTime, err = parseDuration("retry max time", aux.MaxTime)
+		if err != nil {
+			return err
+		}
+	}
+	if aux.PermanentTime != nil {
+		r.PermanentTime, err = parseDuration("retry permanent time", aux.PermanentTime)
 		if err != nil {
 			return err
 		}
-	default:
-		return errors.New("invalid retry time: " + fmt.Sprintf("%#v", value))
 	}
 
 	return nil
 }
+
+// RetryInterval returns how long to wait before retrying after the given
+// number of consecutive failed attempts.
+func (c *Credential) RetryInterval(attempts int, permanent bool) time.Duration {
+	interval := c.Retry.PermanentTime
+	if !permanent {
+		backoff := float64(c.RetryTime) * math.Pow(c.Retry.Multiplier, float64(max(attempts-1, 0)))
+		interval = time.Duration(min(backoff, float64(c.Retry.MaxTime)))
+	}
+
+	if c.Retry.Jitter > 0 {
+		// spread hostnames failing at the same time over +-jitter of the interval
+		interval += time.Duration((rand.Float64()*2 - 1) * c.Retry.Jitter * float64(interval))
+	}
+
+	return interval
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCredentialRetryInterval(t *testing.T) {
+	var credential Credential
+	err := json.Unmarshal([]byte(`{
+		"provider": "test",
+		"retry_time": "10s",
+		"retry": {"max_time": "1m", "jitter": 0},
+		"settings": {}
+	}`), &credential)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		attempts  int
+		permanent bool
+		want      time.Duration
+	}{
+		{"first retry", 1, false, 10 * time.Second},
+		{"second retry", 2, false, 20 * time.Second},
+		{"third retry", 3, false, 40 * time.Second},
+		{"capped at max time", 5, false, time.Minute},
+		{"permanent defaults to max time", 1, true, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := credential.RetryInterval(tt.attempts, tt.permanent); got != tt.want {
+				t.Errorf("RetryInterval(%d, %v) = %v, want %v", tt.attempts, tt.permanent, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCredentialRetryIntervalJitter(t *testing.T) {
+	var credential Credential
+	err := json.Unmarshal([]byte(`{
+		"provider": "test",
+		"retry_time": "10s",
+		"retry": {"jitter": 0.5},
+		"settings": {}
+	}`), &credential)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		got := credential.RetryInterval(1, false)
+		if got < 5*time.Second || got > 15*time.Second {
+			t.Fatalf("RetryInterval(1, false) = %v, want between 5s and 15s", got)
+		}
+	}
+}
+
+func TestCredentialMaxDelay(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want time.Duration
+	}{
+		{"default", `{"provider": "test", "settings": {}}`, time.Minute},
+		{"configured", `{"provider": "test", "max_delay": "5m", "settings": {}}`, 5 * time.Minute},
+		{"not below debounce time", `{"provider": "test", "debounce_time": "30s", "max_delay": "10s", "settings": {}}`, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var credential Credential
+			if err := json.Unmarshal([]byte(tt.json), &credential); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if credential.MaxDelay != tt.want {
+				t.Errorf("MaxDelay = %v, want %v", credential.MaxDelay, tt.want)
+			}
+		})
+	}
+}
+
+func TestCredentialTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want time.Duration
+	}{
+		{"default", `{"provider": "test", "settings": {}}`, time.Minute},
+		{"configured", `{"provider": "test", "timeout": "15s", "settings": {}}`, 15 * time.Second},
+		{"disabled", `{"provider": "test", "timeout": 0, "settings": {}}`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var credential Credential
+			if err := json.Unmarshal([]byte(tt.json), &credential); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if credential.Timeout != tt.want {
+				t.Errorf("Timeout = %v, want %v", credential.Timeout, tt.want)
+			}
+		})
+	}
+}
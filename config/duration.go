@@ -0,0 +1,27 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// parseDuration accepts either a number of seconds or a duration string.
+func parseDuration(name string, value interface{}) (time.Duration, error) {
+	switch value := value.(type) {
+	case float64:
+		return time.Duration(value) * time.Second, nil
+	case string:
+		return time.ParseDuration(value)
+	default:
+		return 0, fmt.Errorf("invalid %s: %#v", name, value)
+	}
+}
+
+// formatDuration is the inverse of parseDuration, empty for zero so optional
+// durations are omitted.
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	RecordTypeA    = "A"
+	RecordTypeAAAA = "AAAA"
+)
+
+// Hostname is a hostname of a task endpoint. It is written either as a plain
+// string or as an object overriding the credential settings for it. Instead
+// of a name, the object can set a template generating a hostname for every
+// device matching the task.
+type Hostname struct {
+	Name string `json:"name"`
+	// Template generates the names from the devices, see TemplateFuncs.
+	Template string `json:"template,omitempty"`
+	// MaxHostnames limits the names the template creates, zero means no limit.
+	MaxHostnames int `json:"max_hostnames,omitempty"`
+	// TTL overrides the ttl setting of the provider.
+	TTL time.Duration `json:"ttl,omitempty"`
+	// RecordTypes overrides the record types of the task.
+	RecordTypes RecordTypes `json:"record_types,omitempty"`
+	// Proxied overrides the proxied setting of the provider.
+	Proxied *bool `json:"proxied,omitempty"`
+	// Settings overrides any other provider setting.
+	Settings json.RawMessage `json:"settings,omitempty"`
+	// MACs restricts the hostname to the devices with these MAC addresses,
+	// set for the hostnames read from the inventory file of the task.
+	MACs []net.HardwareAddr `json:"-"`
+}
+
+func (h *Hostname) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &h.Name); err == nil {
+		return nil
+	}
+
+	type Alias Hostname
+	aux := &struct {
+		TTL interface{} `json:"ttl"`
+		*Alias
+	}{
+		Alias: (*Alias)(h),
+	}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	if aux.TTL == nil {
+		return nil
+	}
+
+	var err error
+	h.TTL, err = parseDuration("hostname ttl", aux.TTL)
+	return err
+}
+
+// MarshalJSON writes the hostname as a plain string unless it has options.
+func (h Hostname) MarshalJSON() ([]byte, error) {
+	if h.Template == "" && h.MaxHostnames == 0 && len(h.RecordTypes) == 0 && !h.Overrides() {
+		return json.Marshal(h.Name)
+	}
+
+	// the name is left out of templates, the schema accepts only one of both
+	var name *string
+	if h.Template == "" {
+		name = &h.Name
+	}
+
+	type Alias Hostname
+	return json.Marshal(&struct {
+		Name *string `json:"name,omitempty"`
+		TTL  string  `json:"ttl,omitempty"`
+		*Alias
+	}{
+		Name:  name,
+		TTL:   formatDuration(h.TTL),
+		Alias: (*Alias)(&h),
+	})
+}
+
+// Key identifies the hostname among the ones of an endpoint, it is the
+// template for the templated ones.
+func (h Hostname) Key() string {
+	if h.Template != "" {
+		return h.Template
+	}
+	return h.Name
+}
+
+// TemplateFuncs are the functions available to hostname templates besides the
+// builtin ones, as in {{ .MAC | replace ":" "-" }}.
+var TemplateFuncs = template.FuncMap{
+	"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+}
+
+// ParseTemplate parses the template of the hostname.
+func (h Hostname) ParseTemplate() (*template.Template, error) {
+	tmpl, err := template.New(h.Template).Funcs(TemplateFuncs).Option("missingkey=error").Parse(h.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hostname template %q: %w", h.Template, err)
+	}
+	return tmpl, nil
+}
+
+var hostnamePattern = regexp.MustCompile(`^([a-z0-9_]([a-z0-9_-]*[a-z0-9])?)(\.[a-z0-9_]([a-z0-9_-]*[a-z0-9])?)*$`)
+
+// ValidHostname reports whether name, as generated by a template, can be used as a hostname.
+func ValidHostname(name string) bool {
+	return len(name) <= 253 && hostnamePattern.MatchString(name)
+}
+
+// overrideProviders are the providers honoring each setting the hostnames
+// override with their own options.
+var overrideProviders = map[string][]string{
+	"ttl":     {"cloudflare", "mikrotik", "opnsense_unbound", "pfsense_restapi_unbound", "route53", "technitium", "windows"},
+	"proxied": {"cloudflare"},
+}
+
+// validateOverrides checks that the provider honors the settings the hostname overrides.
+func (h Hostname) validateOverrides(provider string) error {
+	overridden := map[string]bool{"ttl": h.TTL > 0, "proxied": h.Proxied != nil}
+	for _, setting := range []string{"ttl", "proxied"} {
+		if overridden[setting] && !slices.Contains(overrideProviders[setting], provider) {
+			return fmt.Errorf("hostname %s overrides %s, which provider %s does not support", h.Key(), setting, provider)
+		}
+	}
+	return nil
+}
+
+// Overrides reports whether the hostname changes any setting of its credential.
+func (h Hostname) Overrides() bool {
+	return h.TTL > 0 || h.Proxied != nil || len(h.Settings) > 0
+}
+
+// ProviderSettings returns the credential settings with the overrides of the hostname applied.
+func (h Hostname) ProviderSettings(settings json.RawMessage) (json.RawMessage, error) {
+	merged := make(map[string]interface{})
+	if err := json.Unmarshal(settings, &merged); err != nil {
+		return nil, err
+	}
+
+	if len(h.Settings) > 0 {
+		overrides := make(map[string]interface{})
+		if err := json.Unmarshal(h.Settings, &overrides); err != nil {
+			return nil, fmt.Errorf("invalid settings of hostname %s: %w", h.Name, err)
+		}
+		for key, value := range overrides {
+			merged[key] = value
+		}
+	}
+	if h.TTL > 0 {
+		merged["ttl"] = h.TTL.String()
+	}
+	if h.Proxied != nil {
+		merged["proxied"] = *h.Proxied
+	}
+
+	return json.Marshal(merged)
+}
+
+// RecordTypes lists the types of the records to publish, both A and AAAA if empty.
+type RecordTypes []string
+
+// Publishes reports whether records of the given type are published.
+func (r RecordTypes) Publishes(recordType string) bool {
+	return len(r) == 0 || slices.Contains(r, recordType)
+}
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// InventoryEntry maps the MAC address of a device to the hostname it is
+// published under, on a single endpoint of the task or on all of them if empty.
+type InventoryEntry struct {
+	MAC      string `json:"mac"`
+	Hostname string `json:"hostname"`
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// ReadInventory reads an inventory file, a YAML list of entries if named
+// .yaml or .yml, CSV with mac,hostname[,endpoint] lines if named .csv, or
+// the ethers format of whitespace separated fields otherwise.
+func ReadInventory(path string) ([]InventoryEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []InventoryEntry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &entries)
+	case ".csv":
+		entries, err = readInventoryCSV(data)
+	default:
+		entries, err = readInventoryEthers(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid inventory file %s: %w", path, err)
+	}
+
+	for i, entry := range entries {
+		if _, err := net.ParseMAC(entry.MAC); err != nil {
+			return nil, fmt.Errorf("invalid inventory file %s entry %d: %w", path, i+1, err)
+		}
+		if entry.Hostname == "" {
+			return nil, fmt.Errorf("invalid inventory file %s entry %d: missing hostname", path, i+1)
+		}
+	}
+
+	return entries, nil
+}
+
+func readInventoryCSV(data []byte) ([]InventoryEntry, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var entries []InventoryEntry
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// optional header
+		if len(entries) == 0 && strings.EqualFold(record[0], "mac") {
+			continue
+		}
+
+		entry, err := inventoryEntry(record)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func readInventoryEthers(data []byte) ([]InventoryEntry, error) {
+	var entries []InventoryEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		entry, err := inventoryEntry(fields)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+func inventoryEntry(fields []string) (InventoryEntry, error) {
+	if len(fields) < 2 || len(fields) > 3 {
+		return InventoryEntry{}, fmt.Errorf("expected mac, hostname and optional endpoint, got %q", strings.Join(fields, " "))
+	}
+
+	entry := InventoryEntry{MAC: fields[0], Hostname: fields[1]}
+	if len(fields) == 3 {
+		entry.Endpoint = fields[2]
+	}
+	return entry, nil
+}
+
+// InventoryPath returns the path of the inventory file of a task, relative
+// paths are resolved against the directory of the configuration file.
+func (c *Config) InventoryPath(task Task) string {
+	if task.Inventory == "" || filepath.IsAbs(task.Inventory) {
+		return task.Inventory
+	}
+	return filepath.Join(c.BaseDir, task.Inventory)
+}
+
+// InventoryFiles returns the paths of the inventory files of every task.
+func (c *Config) InventoryFiles() []string {
+	var files []string
+	for _, task := range c.Tasks {
+		if path := c.InventoryPath(task); path != "" && !slices.Contains(files, path) {
+			files = append(files, path)
+		}
+	}
+	slices.Sort(files)
+	return files
+}
+
+// expandInventories adds the devices of the inventory file of every task to
+// its endpoints, as hostnames restricted to their MAC addresses. A hostname
+// listed several times collects all the MAC addresses. Entries can only use
+// the endpoints of their task, and their hostnames can't be configured
+// directly or by another inventory file.
+func (c *Config) expandInventories() error {
+	// the inventory file each hostname comes from, by endpoint and name
+	origins := make(map[string]map[string]string)
+
+	taskNames := slices.Sorted(maps.Keys(c.Tasks))
+	for _, taskName := range taskNames {
+		task := c.Tasks[taskName]
+		if task.Inventory == "" {
+			continue
+		}
+
+		path := c.InventoryPath(task)
+		entries, err := ReadInventory(path)
+		if err != nil {
+			return fmt.Errorf("task %s: %w", taskName, err)
+		}
+
+		endpoints := make(map[string][]Hostname, len(task.Endpoints))
+		for endpointKey, hostnames := range task.Endpoints {
+			endpoints[endpointKey] = slices.Clone(hostnames)
+		}
+		for _, entry := range entries {
+			mac, _ := net.ParseMAC(entry.MAC)
+			endpointKeys := slices.Sorted(maps.Keys(task.Endpoints))
+			if entry.Endpoint != "" {
+				if _, ok := task.Endpoints[entry.Endpoint]; !ok {
+					return fmt.Errorf("task %s inventory file %s: hostname %s uses endpoint %s, which the task does not list", taskName, path, entry.Hostname, entry.Endpoint)
+				}
+				endpointKeys = []string{entry.Endpoint}
+			}
+
+			for _, endpointKey := range endpointKeys {
+				if origin, ok := origins[endpointKey][entry.Hostname]; ok && origin != path {
+					return fmt.Errorf("task %s inventory file %s: hostname %s of endpoint %s is also listed by inventory file %s", taskName, path, entry.Hostname, endpointKey, origin)
+				}
+				if directTask, ok := c.directHostname(endpointKey, entry.Hostname); ok {
+					return fmt.Errorf("task %s inventory file %s: hostname %s of endpoint %s is also configured by task %s", taskName, path, entry.Hostname, endpointKey, directTask)
+				}
+				if origins[endpointKey] == nil {
+					origins[endpointKey] = make(map[string]string)
+				}
+				origins[endpointKey][entry.Hostname] = path
+				endpoints[endpointKey] = addInventoryHostname(endpoints[endpointKey], entry.Hostname, mac)
+			}
+		}
+
+		task.Endpoints = endpoints
+		c.Tasks[taskName] = task
+	}
+
+	return nil
+}
+
+// directHostname returns the first task, sorted by name, configuring the
+// hostname on the endpoint itself rather than through its inventory file.
+func (c *Config) directHostname(endpointKey string, name string) (string, bool) {
+	for _, taskName := range slices.Sorted(maps.Keys(c.Tasks)) {
+		for _, hostname := range c.Tasks[taskName].Endpoints[endpointKey] {
+			if hostname.Key() == name && len(hostname.MACs) == 0 {
+				return taskName, true
+			}
+		}
+	}
+	return "", false
+}
+
+func addInventoryHostname(hostnames []Hostname, name string, mac net.HardwareAddr) []Hostname {
+	for i, hostname := range hostnames {
+		if hostname.Name == name && len(hostname.MACs) > 0 {
+			if !slices.ContainsFunc(hostname.MACs, func(m net.HardwareAddr) bool { return bytes.Equal(m, mac) }) {
+				hostnames[i].MACs = append(hostname.MACs, mac)
+			}
+			return hostnames
+		}
+	}
+	return append(hostnames, Hostname{Name: name, MACs: []net.HardwareAddr{mac}})
+}
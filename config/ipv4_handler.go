@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/miguelangel-nubla/ipv6ddns/pkg/cmd"
@@ -20,17 +22,27 @@ type IPv4Handler struct {
 	Command  string        `json:"command"`
 	Args     []string      `json:"args"`
 	Lifetime time.Duration `json:"lifetime"`
-	running  bool
+	// running is read by the health checks while the worker starts and stops the handler
+	running atomic.Bool
+	// lastRun is when the last command finished, in Unix nanoseconds
+	lastRun  atomic.Int64
 	ticker   *time.Ticker
+	done     chan struct{}
 	logger   *zap.SugaredLogger
 	basedir  string
+	onChange func()
 }
 
-func (h *IPv4Handler) PrettyPrint(prefix string) string {
+func (h *IPv4Handler) PrettyPrint(prefix string, hideSensible bool) string {
 	var result strings.Builder
 	fmt.Fprintf(&result, prefix+"IPv4 (%v): %s", h.Interval, h.Command)
-	for _, arg := range h.Args {
-		fmt.Fprintf(&result, " %q", arg)
+	if hideSensible && len(h.Args) > 0 {
+		// the arguments may well carry credentials, as in curl -u
+		result.WriteString(" <sensible data hidden>")
+	} else {
+		for _, arg := range h.Args {
+			fmt.Fprintf(&result, " %q", arg)
+		}
 	}
 	fmt.Fprintf(&result, "\n")
 	if h.AddrCollection != nil {
@@ -83,22 +95,59 @@ func (h *IPv4Handler) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (h *IPv4Handler) Start(baseDir string, sugaredLogger *zap.SugaredLogger) error {
-	if h.running {
+// redacted returns a handler with the settings of h and the arguments of the
+// command hidden, without the collected addresses.
+func (h *IPv4Handler) redacted() *IPv4Handler {
+	redacted := &IPv4Handler{Interval: h.Interval, Command: h.Command, Lifetime: h.Lifetime}
+	if len(h.Args) > 0 {
+		redacted.Args = []string{"<sensible data hidden>"}
+	}
+	return redacted
+}
+
+// MarshalJSON writes the settings of the handler, without the collected addresses.
+func (h *IPv4Handler) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Interval string   `json:"interval"`
+		Command  string   `json:"command"`
+		Args     []string `json:"args,omitempty"`
+		Lifetime string   `json:"lifetime"`
+	}{
+		Interval: h.Interval.String(),
+		Command:  h.Command,
+		Args:     h.Args,
+		Lifetime: h.Lifetime.String(),
+	})
+}
+
+// Start runs the command periodically, calling onChange after every run so the
+// caller can look for changes in the collected addresses.
+func (h *IPv4Handler) Start(baseDir string, sugaredLogger *zap.SugaredLogger, onChange func()) error {
+	if h.running.Load() {
 		return errors.New("already running")
 	}
 
 	h.basedir = baseDir
 	h.logger = sugaredLogger
+	h.onChange = onChange
 
 	h.ticker = time.NewTicker(h.Interval)
-	h.running = true
+	h.done = make(chan struct{})
+	h.running.Store(true)
+	h.lastRun.Store(time.Now().UnixNano())
 
 	go func() {
 		h.runCommand()
-
-		for range h.ticker.C {
-			h.runCommand()
+		h.lastRun.Store(time.Now().UnixNano())
+
+		for {
+			select {
+			case <-h.ticker.C:
+				h.runCommand()
+				h.lastRun.Store(time.Now().UnixNano())
+			case <-h.done:
+				return
+			}
 		}
 	}()
 
@@ -106,12 +155,34 @@ func (h *IPv4Handler) Start(baseDir string, sugaredLogger *zap.SugaredLogger) er
 }
 
 func (h *IPv4Handler) Stop() {
+	if !h.running.CompareAndSwap(true, false) {
+		return
+	}
+
 	h.ticker.Stop()
-	h.running = false
+	close(h.done)
+}
+
+// SameSettings reports whether both handlers run the same command with the same timings.
+func (h *IPv4Handler) SameSettings(other *IPv4Handler) bool {
+	if h == nil || other == nil {
+		return h == other
+	}
+
+	return h.Interval == other.Interval &&
+		h.Command == other.Command &&
+		slices.Equal(h.Args, other.Args) &&
+		h.Lifetime == other.Lifetime
 }
 
 func (h *IPv4Handler) Running() bool {
-	return h.running
+	return h.running.Load()
+}
+
+// Alive reports whether the handler is running and its command keeps being
+// run: every run is bounded by the interval, so it finishes within two of them.
+func (h *IPv4Handler) Alive() bool {
+	return h.running.Load() && time.Since(time.Unix(0, h.lastRun.Load())) < 2*h.Interval
 }
 
 func (h *IPv4Handler) runCommand() {
@@ -142,4 +213,8 @@ func (h *IPv4Handler) runCommand() {
 		addr := ipv6disc.NewAddr(net.HardwareAddr{0, 0, 0, 0, 0, 0}, netipAddr, "ipv4", h.Lifetime, nil)
 		h.AddrCollection.Seen(addr, "ipv4")
 	}
+
+	if h.onChange != nil {
+		h.onChange()
+	}
 }
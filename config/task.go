@@ -1,31 +1,138 @@
 package config
 
 import (
+	"encoding/json"
 	"net/netip"
+	"time"
+)
+
+// The empty policies apply to each hostname of a task left without addresses,
+// after the selection and record types.
+const (
+	// EmptyPublish publishes an empty set, removing the records, when every address expired.
+	EmptyPublish = "publish"
+	// EmptyKeep keeps the last published records when every address expired.
+	EmptyKeep = "keep"
+	// EmptyStatic publishes the static addresses of the task when every address expired.
+	EmptyStatic = "static"
 )
 
 type Task struct {
-	Name      string              `json:"name"`
-	Filters   []Filters           `json:"filter"`
-	Endpoints map[string][]string `json:"endpoints"`
-	IPv4      *IPv4Handler        `json:"ipv4,omitempty"`
+	Name            string                `json:"name,omitempty"`
+	Filters         []Filters             `json:"filter,omitempty"`
+	Endpoints       map[string][]Hostname `json:"endpoints"`
+	IPv4            *IPv4Handler          `json:"ipv4,omitempty"`
+	EmptyPolicy     string                `json:"empty_policy,omitempty"`
+	StaticAddresses []netip.Addr          `json:"static_addresses,omitempty"`
+	Stability       Stability             `json:"stability"`
+	Selection       Selection             `json:"selection"`
+	// RecordTypes limits the records published for every hostname of the task.
+	RecordTypes RecordTypes `json:"record_types,omitempty"`
+	// Inventory is a file mapping MAC addresses to hostnames, added to the
+	// endpoints of the task when the configuration is loaded.
+	Inventory string `json:"inventory,omitempty"`
+	// ReverseZones replace the reverse zones of the credentials for the
+	// hostnames of the task.
+	ReverseZones []string `json:"reverse_zones,omitempty"`
+}
+
+// HostnameRecordTypes returns the record types published for a hostname of
+// the task, its own if set or else the ones of the task.
+func (t Task) HostnameRecordTypes(hostname Hostname) RecordTypes {
+	if len(hostname.RecordTypes) > 0 {
+		return hostname.RecordTypes
+	}
+	return t.RecordTypes
 }
 
 type Filters struct {
-	MAC    MACFilters `json:"mac"`
-	IP     IPFilters  `json:"ip"`
-	Source []string   `json:"source"`
+	MAC    MACFilters `json:"mac,omitzero"`
+	IP     IPFilters  `json:"ip,omitzero"`
+	Source []string   `json:"source,omitempty"`
 }
 
 type MACFilters struct {
-	Address string   `json:"address"`
-	Mask    []string `json:"mask"`
-	Type    []string `json:"type"`
+	Address string   `json:"address,omitempty"`
+	Mask    []string `json:"mask,omitempty"`
+	Type    []string `json:"type,omitempty"`
 }
 
 type IPFilters struct {
-	Type   []string     `json:"type"`
-	Prefix netip.Prefix `json:"prefix"`
-	Suffix string       `json:"suffix"`
-	Mask   []string     `json:"mask"`
+	Type   []string     `json:"type,omitempty"`
+	Prefix netip.Prefix `json:"prefix,omitzero"`
+	Suffix string       `json:"suffix,omitempty"`
+	Mask   []string     `json:"mask,omitempty"`
+}
+
+// Stability holds back addresses until they have been seen long enough, and
+// keeps publishing them for a while after they are no longer seen.
+type Stability struct {
+	// MinAge is how long an address must be continuously seen before it is published.
+	MinAge time.Duration `json:"min_age,omitempty"`
+	// MinSightings is the number of discovery sources that must report an address before it is published.
+	MinSightings int `json:"min_sightings,omitempty"`
+	// RemovalGrace is how long an address stays published after it stopped being seen.
+	RemovalGrace time.Duration `json:"removal_grace,omitempty"`
+}
+
+func (s *Stability) UnmarshalJSON(b []byte) error {
+	type Alias Stability
+	aux := &struct {
+		MinAge       interface{} `json:"min_age"`
+		RemovalGrace interface{} `json:"removal_grace"`
+		*Alias
+	}{
+		Alias: (*Alias)(s),
+	}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	var err error
+	if aux.MinAge != nil {
+		s.MinAge, err = parseDuration("min age", aux.MinAge)
+		if err != nil {
+			return err
+		}
+	}
+	if aux.RemovalGrace != nil {
+		s.RemovalGrace, err = parseDuration("removal grace", aux.RemovalGrace)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s Stability) MarshalJSON() ([]byte, error) {
+	type Alias Stability
+	return json.Marshal(&struct {
+		MinAge       string `json:"min_age,omitempty"`
+		RemovalGrace string `json:"removal_grace,omitempty"`
+		*Alias
+	}{
+		MinAge:       formatDuration(s.MinAge),
+		RemovalGrace: formatDuration(s.RemovalGrace),
+		Alias:        (*Alias)(&s),
+	})
+}
+
+const (
+	// PreferEUI64 prefers the stable addresses derived from the MAC address.
+	PreferEUI64 = "eui64"
+	// PreferNewest prefers the addresses that appeared most recently.
+	PreferNewest = "newest"
+	// PreferOldest prefers the addresses that have been seen for the longest time.
+	PreferOldest = "oldest"
+)
+
+// Selection chooses which of the filtered addresses are published for the
+// hostnames of a task. The limits apply after sorting by the preferences, in
+// order, and zero means no limit.
+type Selection struct {
+	Prefer    []string `json:"prefer,omitempty"`
+	MaxA      int      `json:"max_a,omitempty"`
+	MaxAAAA   int      `json:"max_aaaa,omitempty"`
+	MaxPerMAC int      `json:"max_per_mac,omitempty"`
 }
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// AccessNone denies every request but the health checks.
+	AccessNone = "none"
+	// AccessViewer allows the reports, with the sensible data hidden.
+	AccessViewer = "viewer"
+	// AccessAdmin allows the full reports and triggering updates.
+	AccessAdmin = "admin"
+)
+
+// WebServer configures the web server serving the reports, metrics and API.
+// It is read on start, changes need a restart.
+type WebServer struct {
+	// Listen are the addresses to listen on, as in [::]:8053 or
+	// 127.0.0.1:8053. If empty, every address on -webserver_port is used.
+	Listen []string `json:"listen,omitempty"`
+	// IPv6Only makes the IPv6 wildcard address [::] accept only IPv6 connections.
+	IPv6Only bool `json:"ipv6_only,omitempty"`
+	// TLSCert and TLSKey enable HTTPS, relative paths are resolved against
+	// the directory of the configuration file.
+	TLSCert string `json:"tls_cert,omitempty"`
+	TLSKey  string `json:"tls_key,omitempty"`
+	// AnonymousAccess is the access of the requests without credentials,
+	// viewer by default, or none once users or tokens are configured.
+	AnonymousAccess string     `json:"anonymous_access,omitempty"`
+	Users           []WebUser  `json:"users,omitempty"`
+	Tokens          []WebToken `json:"tokens,omitempty"`
+}
+
+// WebUser is allowed through HTTP basic authentication.
+type WebUser struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Access   string `json:"access"`
+}
+
+// WebToken is allowed as an HTTP bearer token.
+type WebToken struct {
+	Token  string `json:"token"`
+	Access string `json:"access"`
+}
+
+// Anonymous returns the access of the requests without credentials.
+func (w WebServer) Anonymous() string {
+	if w.AnonymousAccess != "" {
+		return w.AnonymousAccess
+	}
+	if len(w.Users) > 0 || len(w.Tokens) > 0 {
+		return AccessNone
+	}
+	return AccessViewer
+}
+
+// Network returns the network to listen on the address with, tcp6 for the
+// IPv6 addresses when IPv6Only is set so the wildcard does not accept IPv4.
+func (w WebServer) Network(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "tcp"
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && addr.Is6() && w.IPv6Only {
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+// AccessAtLeast reports whether access grants everything required does.
+func AccessAtLeast(access string, required string) bool {
+	rank := map[string]int{AccessNone: 0, AccessViewer: 1, AccessAdmin: 2}
+	return rank[access] >= rank[required]
+}
+
+// WebServerTLSFiles returns the paths of the TLS certificate and key, relative
+// paths resolved against the directory of the configuration file.
+func (c *Config) WebServerTLSFiles() (string, string) {
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(c.BaseDir, path)
+	}
+	return resolve(c.WebServer.TLSCert), resolve(c.WebServer.TLSKey)
+}
+
+func (w WebServer) validate() error {
+	if (w.TLSCert == "") != (w.TLSKey == "") {
+		return fmt.Errorf("webserver tls_cert and tls_key must be set together")
+	}
+	for _, address := range w.Listen {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return fmt.Errorf("invalid webserver listen address %s: %w", address, err)
+		}
+	}
+	usernames := make(map[string]bool)
+	for _, user := range w.Users {
+		if usernames[user.Username] {
+			return fmt.Errorf("webserver user %s is configured more than once", user.Username)
+		}
+		usernames[user.Username] = true
+	}
+	return nil
+}
+
+func (w WebServer) PrettyPrint(prefix string) string {
+	var result strings.Builder
+
+	result.WriteString(prefix + "Web server:\n")
+	if len(w.Listen) > 0 {
+		result.WriteString(prefix + "    Listen: " + strings.Join(w.Listen, ", "))
+		if w.IPv6Only {
+			result.WriteString(" (IPv6 only)")
+		}
+		result.WriteString("\n")
+	}
+	if w.TLSCert != "" {
+		result.WriteString(prefix + "    TLS: " + w.TLSCert + "\n")
+	}
+	result.WriteString(prefix + "    Anonymous access: " + w.Anonymous() + "\n")
+	for _, user := range w.Users {
+		result.WriteString(prefix + "    User: " + user.Username + " (" + user.Access + ")\n")
+	}
+	// the tokens are credentials, only their access is ever printed
+	for _, token := range w.Tokens {
+		result.WriteString(prefix + "    Token: (" + token.Access + ")\n")
+	}
+
+	return result.String()
+}
@@ -0,0 +1,102 @@
+package ddns
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+type ChangeAction string
+
+const (
+	ChangeCreate ChangeAction = "create"
+	ChangeUpdate ChangeAction = "update"
+	ChangeDelete ChangeAction = "delete"
+)
+
+// Change is a record modification made at the provider by an update, or
+// planned by a dry run.
+type Change struct {
+	Action ChangeAction
+	Type   string
+	Value  string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s %s", c.Action, c.Type, c.Value)
+}
+
+// recordType returns the DNS record type for an IP address string.
+func recordType(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err == nil && addr.Is4() {
+		return "A"
+	}
+	return "AAAA"
+}
+
+// setChanges returns the changes that turn the current addresses into the desired ones.
+func setChanges(current []string, desired []string) []Change {
+	var changes []Change
+	for _, ip := range desired {
+		if !slices.Contains(current, ip) {
+			changes = append(changes, Change{Action: ChangeCreate, Type: recordType(ip), Value: ip})
+		}
+	}
+	for _, ip := range current {
+		if !slices.Contains(desired, ip) {
+			changes = append(changes, Change{Action: ChangeDelete, Type: recordType(ip), Value: ip})
+		}
+	}
+	return changes
+}
+
+type dryRunKey struct{}
+
+// WithDryRun returns a context that makes Update only compute the changes
+// against the live records, without applying them.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// IsDryRun reports whether ctx was returned by WithDryRun.
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+type holdDeletesKey struct{}
+
+// WithDeletesHeld returns a context that makes Update leave the records of the
+// addresses no longer published in place, while still creating the new ones.
+func WithDeletesHeld(ctx context.Context) context.Context {
+	return context.WithValue(ctx, holdDeletesKey{}, true)
+}
+
+// DeletesHeld reports whether ctx was returned by WithDeletesHeld.
+func DeletesHeld(ctx context.Context) bool {
+	held, _ := ctx.Value(holdDeletesKey{}).(bool)
+	return held
+}
+
+// step is a planned change and the call that applies it at the provider.
+type step struct {
+	Change
+	apply func() error
+}
+
+// applySteps applies the planned steps in order, stopping at the first error.
+// It returns the changes applied, or every planned change on dry runs.
+func applySteps(ctx context.Context, steps []step) ([]Change, error) {
+	var changes []Change
+	for _, step := range steps {
+		if !IsDryRun(ctx) {
+			if err := step.apply(); err != nil {
+				return changes, err
+			}
+		}
+		changes = append(changes, step.Change)
+	}
+	return changes, nil
+}
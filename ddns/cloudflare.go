@@ -5,13 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/netip"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/cloudflare/cloudflare-go"
 	"github.com/miguelangel-nubla/ipv6disc"
-	"github.com/xeipuuv/gojsonschema"
 )
 
 type Cloudflare struct {
@@ -25,14 +23,18 @@ func init() {
 	RegisterProvider("cloudflare", NewCloudflare)
 }
 
-func NewCloudflare(settings ProviderSettings) Service {
+func NewCloudflare(settings ProviderSettings) (Service, error) {
 	var service Cloudflare
-	cloudflareValidateConfig(settings.(json.RawMessage))
-	json.Unmarshal(settings.(json.RawMessage), &service)
-	return &service
+	if err := cloudflareValidateConfig(settings.(json.RawMessage)); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(settings.(json.RawMessage), &service); err != nil {
+		return nil, err
+	}
+	return &service, nil
 }
 
-func cloudflareValidateConfig(config json.RawMessage) {
+func cloudflareValidateConfig(config json.RawMessage) error {
 	var configSchema = []byte(`
 	{
 		"$schema": "http://json-schema.org/draft-07/schema#",
@@ -63,122 +65,180 @@ func cloudflareValidateConfig(config json.RawMessage) {
 	}
 	`)
 
-	schemaLoader := gojsonschema.NewBytesLoader(configSchema)
-	dataLoader := gojsonschema.NewBytesLoader([]byte(config))
+	return validateSettings("Cloudflare", configSchema, config)
+}
 
-	result, err := gojsonschema.Validate(schemaLoader, dataLoader)
+func (c *Cloudflare) Update(ctx context.Context, hostname string, addrCollection *ipv6disc.AddrCollection) ([]Change, error) {
+	// Initialize the Cloudflare API with the provided API token
+	api, err := cloudflare.NewWithAPIToken(c.APIToken)
 	if err != nil {
-		panic(err.Error())
+		return nil, fmt.Errorf("failed to initialize: %v", err)
 	}
 
-	if !result.Valid() {
-		fmt.Printf("Cloudflare configuration is not valid.\nErrors:\n")
-		for _, desc := range result.Errors() {
-			fmt.Printf("- %s\n", desc)
-		}
-		os.Exit(1)
+	rc, err := cloudflareZone(ctx, api, c.Zone)
+	if err != nil {
+		return nil, err
 	}
+
+	records := &cloudflareRecords{
+		Cloudflare: c,
+		api:        api,
+		rc:         rc,
+		hostname:   hostname,
+		fqdn:       FQDN(hostname, c.Zone),
+	}
+	return Reconcile(ctx, records, addrCollection, c.TTL)
 }
 
-func (c *Cloudflare) Update(hostname string, addrCollection *ipv6disc.AddrCollection) error {
-	// Initialize the Cloudflare API with the provided API token
+// UpdateReverse publishes the PTR records of the addresses in a reverse zone of the account.
+func (c *Cloudflare) UpdateReverse(ctx context.Context, zone string, hostname string, addrCollection *ipv6disc.AddrCollection, previous *ipv6disc.AddrCollection) ([]Change, error) {
 	api, err := cloudflare.NewWithAPIToken(c.APIToken)
 	if err != nil {
-		return fmt.Errorf("failed to initialize: %v", err)
+		return nil, fmt.Errorf("failed to initialize: %v", err)
 	}
 
-	// Get Zone ID
-	zoneID, err := api.ZoneIDByName(c.Zone)
+	rc, err := cloudflareZone(ctx, api, strings.Trim(zone, "."))
 	if err != nil {
-		return fmt.Errorf("failed to read zone ID: %v", err)
+		return nil, err
 	}
 
-	fqdn := FQDN(hostname, c.Zone)
+	records := &cloudflarePTRs{
+		api:    api,
+		rc:     rc,
+		target: FQDN(hostname, c.Zone),
+	}
+	return ReconcileReverse(ctx, records, addrCollection, c.TTL)
+}
 
-	// Create a new *ResourceContainer for the zone
-	rc := cloudflare.ZoneIdentifier(zoneID)
-	params := cloudflare.ListDNSRecordsParams{
-		Name: fqdn,
+// cloudflareZone returns the identifier of a zone of the account by its name.
+func cloudflareZone(ctx context.Context, api *cloudflare.API, zone string) (*cloudflare.ResourceContainer, error) {
+	zones, err := api.ListZonesContext(ctx, cloudflare.WithZoneFilters(zone, "", ""))
+	if err != nil {
+		return nil, cloudflareError(fmt.Errorf("failed to read zone ID: %w", err))
+	}
+	if len(zones.Result) != 1 {
+		return nil, Permanent(fmt.Errorf("failed to read zone ID: zone %s could not be found", zone))
 	}
-	currentRecords, _, err := api.ListDNSRecords(context.Background(), rc, params)
+	return cloudflare.ZoneIdentifier(zones.Result[0].ID), nil
+}
+
+// cloudflareRecords is the RecordSet of a hostname in a Cloudflare zone.
+type cloudflareRecords struct {
+	*Cloudflare
+	api      *cloudflare.API
+	rc       *cloudflare.ResourceContainer
+	hostname string
+	fqdn     string
+}
+
+func (r *cloudflareRecords) List(ctx context.Context) ([]Record, error) {
+	currentRecords, _, err := r.api.ListDNSRecords(ctx, r.rc, cloudflare.ListDNSRecordsParams{Name: r.fqdn})
 	if err != nil {
-		return fmt.Errorf("failed to list DNS records for %s: %s", hostname, err)
+		return nil, cloudflareError(fmt.Errorf("failed to list DNS records for %s: %w", r.hostname, err))
 	}
 
-	// Build a set of current IP addresses in Cloudflare
-	currentIPs := make(map[string]string)
+	var records []Record
 	for _, record := range currentRecords {
-		if record.Type == "AAAA" || record.Type == "A" {
-			addr, err := netip.ParseAddr(record.Content)
-			if err != nil {
-				return fmt.Errorf("invalid existing record found: %v", err)
-			}
-			ip := addr.WithZone("").String()
-			currentIPs[ip] = record.Type
-		}
+		proxied := record.Proxied != nil && *record.Proxied
+		records = append(records, Record{
+			ID:    record.ID,
+			Type:  record.Type,
+			Value: record.Content,
+			TTL:   time.Duration(record.TTL) * time.Second,
+			Stale: proxied != r.Proxied,
+		})
 	}
+	return records, nil
+}
 
-	// Build a set of desired IP addresses
-	desiredIPs := make(map[string]string)
-	for _, addr := range addrCollection.Get() {
-		recordType := "AAAA"
-		if addr.Addr.Is4() {
-			recordType = "A"
-		}
-		ip := addr.WithZone("").String()
-		desiredIPs[ip] = recordType
-	}
-
-	// Create records as necessary
-	for ip, recordType := range desiredIPs {
-		_, exists := currentIPs[ip]
-		if !exists {
-			newRecord := cloudflare.CreateDNSRecordParams{
-				Type:    recordType,
-				Name:    fqdn,
-				Content: ip,
-				TTL:     int(c.TTL.Seconds()),
-				Proxied: &c.Proxied,
-			}
-			_, err := api.CreateDNSRecord(context.Background(), rc, newRecord)
-			if err != nil {
-				return fmt.Errorf("failed to create %s DNS record for %s: %v", hostname, ip, err)
-			}
-		}
+func (r *cloudflareRecords) Create(ctx context.Context, record Record) error {
+	_, err := r.api.CreateDNSRecord(ctx, r.rc, cloudflare.CreateDNSRecordParams{
+		Type:    record.Type,
+		Name:    r.fqdn,
+		Content: record.Value,
+		TTL:     int(record.TTL.Seconds()),
+		Proxied: &r.Proxied,
+	})
+	if err != nil {
+		return cloudflareError(fmt.Errorf("failed to create %s DNS record for %s: %w", r.hostname, record.Value, err))
+	}
+	return nil
+}
+
+func (r *cloudflareRecords) Delete(ctx context.Context, record Record) error {
+	err := r.api.DeleteDNSRecord(ctx, r.rc, record.ID)
+	if err != nil {
+		return cloudflareError(fmt.Errorf("failed to delete %s DNS record for %s: %w", r.hostname, record.Value, err))
+	}
+	return nil
+}
+
+func (r *cloudflareRecords) UpdateTTL(ctx context.Context, record Record) error {
+	_, err := r.api.UpdateDNSRecord(ctx, r.rc, cloudflare.UpdateDNSRecordParams{
+		ID:      record.ID,
+		TTL:     int(record.TTL.Seconds()),
+		Proxied: &r.Proxied,
+	})
+	if err != nil {
+		return cloudflareError(fmt.Errorf("failed to update %s DNS record for %s: %w", r.hostname, record.Value, err))
+	}
+	return nil
+}
+
+// cloudflarePTRs is the PTRSet of a hostname in a Cloudflare reverse zone.
+type cloudflarePTRs struct {
+	api    *cloudflare.API
+	rc     *cloudflare.ResourceContainer
+	target string
+}
+
+func (r *cloudflarePTRs) List(ctx context.Context) ([]PTRRecord, error) {
+	currentRecords, _, err := r.api.ListDNSRecords(ctx, r.rc, cloudflare.ListDNSRecordsParams{Type: "PTR", Content: r.target})
+	if err != nil {
+		return nil, cloudflareError(fmt.Errorf("failed to list PTR records for %s: %w", r.target, err))
 	}
 
-	// Update or delete records as necessary
+	var records []PTRRecord
 	for _, record := range currentRecords {
-		if record.Type != "AAAA" && record.Type != "A" {
-			continue
-		}
+		records = append(records, PTRRecord{
+			ID:   record.ID,
+			Name: record.Name,
+			TTL:  time.Duration(record.TTL) * time.Second,
+		})
+	}
+	return records, nil
+}
 
-		ip := record.Content
-		_, exists := desiredIPs[ip]
-		if !exists {
-			err := api.DeleteDNSRecord(context.Background(), rc, record.ID)
-			if err != nil {
-				return fmt.Errorf("failed to delete %s DNS record for %s: %v", hostname, ip, err)
-			}
-		} else {
-			// Update the DNS record if TTL or Proxied is different
-			if record.TTL != int(c.TTL.Seconds()) || *record.Proxied != c.Proxied {
-				updateRecord := cloudflare.UpdateDNSRecordParams{
-					ID:      record.ID,
-					TTL:     int(c.TTL.Seconds()),
-					Proxied: &c.Proxied,
-				}
-				_, err := api.UpdateDNSRecord(context.Background(), rc, updateRecord)
-				if err != nil {
-					return fmt.Errorf("failed to update %s DNS record for %s: %v", hostname, ip, err)
-				}
-			}
-		}
+func (r *cloudflarePTRs) Create(ctx context.Context, record PTRRecord) error {
+	_, err := r.api.CreateDNSRecord(ctx, r.rc, cloudflare.CreateDNSRecordParams{
+		Type:    "PTR",
+		Name:    record.Name,
+		Content: r.target,
+		TTL:     int(record.TTL.Seconds()),
+	})
+	if err != nil {
+		return cloudflareError(fmt.Errorf("failed to create PTR record %s for %s: %w", record.Name, r.target, err))
 	}
+	return nil
+}
 
+func (r *cloudflarePTRs) Delete(ctx context.Context, record PTRRecord) error {
+	err := r.api.DeleteDNSRecord(ctx, r.rc, record.ID)
+	if err != nil {
+		return cloudflareError(fmt.Errorf("failed to delete PTR record %s for %s: %w", record.Name, r.target, err))
+	}
 	return nil
 }
 
+// cloudflareError marks err as permanent when the token was rejected.
+func cloudflareError(err error) error {
+	var apiErr *cloudflare.Error
+	if errors.As(err, &apiErr) && (apiErr.Type == cloudflare.ErrorTypeAuthentication || apiErr.Type == cloudflare.ErrorTypeAuthorization) {
+		return Permanent(err)
+	}
+	return err
+}
+
 func (c *Cloudflare) PrettyPrint(prefix string) ([]byte, error) {
 	return json.MarshalIndent(c, prefix, "    ")
 }
@@ -1,6 +1,7 @@
 package ddns
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/miguelangel-nubla/ipv6disc"
@@ -9,12 +10,14 @@ import (
 type ProviderSettings interface{}
 
 type Service interface {
-	Update(hostname string, addresses *ipv6disc.AddrCollection) error
+	// Update publishes the addresses for hostname and returns the changes it had to make.
+	// It must give up when ctx is done.
+	Update(ctx context.Context, hostname string, addresses *ipv6disc.AddrCollection) ([]Change, error)
 	PrettyPrint(string) ([]byte, error)
 	Domain(hostname string) string
 }
 
-type ProviderFactory func(ProviderSettings) Service
+type ProviderFactory func(ProviderSettings) (Service, error)
 
 var providers = make(map[string]ProviderFactory)
 
@@ -27,5 +30,5 @@ func NewService(provider string, config ProviderSettings) (Service, error) {
 	if !ok {
 		return nil, fmt.Errorf("unsupported provider: %s", provider)
 	}
-	return factory(config), nil
+	return factory(config)
 }
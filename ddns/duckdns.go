@@ -1,15 +1,17 @@
 package ddns
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
-	"os"
+	"strings"
 
 	"github.com/miguelangel-nubla/ipv6disc"
-	"github.com/xeipuuv/gojsonschema"
 )
 
 type DuckDNS struct {
@@ -20,14 +22,18 @@ func init() {
 	RegisterProvider("duckdns", NewDuckDNS)
 }
 
-func NewDuckDNS(settings ProviderSettings) Service {
+func NewDuckDNS(settings ProviderSettings) (Service, error) {
 	var service DuckDNS
-	duckDNSValidateConfig(settings.(json.RawMessage))
-	json.Unmarshal(settings.(json.RawMessage), &service)
-	return &service
+	if err := duckDNSValidateConfig(settings.(json.RawMessage)); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(settings.(json.RawMessage), &service); err != nil {
+		return nil, err
+	}
+	return &service, nil
 }
 
-func duckDNSValidateConfig(config json.RawMessage) {
+func duckDNSValidateConfig(config json.RawMessage) error {
 	var configSchema = []byte(`
 	{
 		"$schema": "http://json-schema.org/draft-07/schema#",
@@ -44,55 +50,104 @@ func duckDNSValidateConfig(config json.RawMessage) {
 	}
 	`)
 
-	schemaLoader := gojsonschema.NewBytesLoader(configSchema)
-	dataLoader := gojsonschema.NewBytesLoader([]byte(config))
+	return validateSettings("DuckDNS", configSchema, config)
+}
 
-	result, err := gojsonschema.Validate(schemaLoader, dataLoader)
-	if err != nil {
-		panic(err.Error())
+func (d *DuckDNS) Update(ctx context.Context, hostname string, addrCollection *ipv6disc.AddrCollection) ([]Change, error) {
+	// DuckDNS holds a single address of each type
+	addresses := ipv6disc.NewAddrCollection()
+	if v4 := addrCollection.Filter4().Get(); len(v4) > 0 {
+		addresses.Add(v4[0])
 	}
-
-	if !result.Valid() {
-		fmt.Printf("DuckDNS configuration is not valid.\nErrors:\n")
-		for _, desc := range result.Errors() {
-			fmt.Printf("- %s\n", desc)
-		}
-		os.Exit(1)
+	if v6 := addrCollection.Filter6().Get(); len(v6) > 0 {
+		addresses.Add(v6[0])
 	}
+
+	records := &duckDNSRecords{DuckDNS: d, hostname: hostname, values: make(map[string]string)}
+	return Reconcile(ctx, records, addresses, 0)
 }
 
-func (d *DuckDNS) Update(hostname string, addrCollection *ipv6disc.AddrCollection) error {
-	v4 := addrCollection.Filter4().Get()
-	var ipv4 string
-	if len(v4) == 0 {
-		ipv4 = ""
-	} else {
-		ipv4 = v4[0].String()
+// duckDNSNameserver answers authoritatively for duckdns.org, so the records
+// are read without the delay of caching resolvers.
+const duckDNSNameserver = "ns1.duckdns.org:53"
+
+// duckDNSRecords is the RecordSet of a DuckDNS domain. Its API has no way to
+// read the records without updating them, so they are resolved instead, and
+// both are written at once on Commit.
+type duckDNSRecords struct {
+	*DuckDNS
+	hostname string
+	// values are the addresses by record type once the changes are applied
+	values map[string]string
+}
+
+func (r *duckDNSRecords) List(ctx context.Context) ([]Record, error) {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, duckDNSNameserver)
+		},
 	}
-	v6 := addrCollection.Filter6().Get()
-	var ipv6 string
-	if len(v6) == 0 {
-		ipv6 = ""
-	} else {
-		ipv6 = v6[0].WithZone("").String()
+	addrs, err := resolver.LookupNetIP(ctx, "ip", r.Domain(r.hostname))
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		return nil, fmt.Errorf("failed to resolve current records: %v", err)
+	}
+
+	var records []Record
+	for _, addr := range addrs {
+		record := Record{Type: recordType(addr.String()), Value: addr.WithZone("").String()}
+		r.values[record.Type] = record.Value
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (r *duckDNSRecords) Create(ctx context.Context, record Record) error {
+	r.values[record.Type] = record.Value
+	return nil
+}
+
+func (r *duckDNSRecords) Delete(ctx context.Context, record Record) error {
+	if r.values[record.Type] == record.Value {
+		delete(r.values, record.Type)
 	}
+	return nil
+}
 
+// UpdateTTL is never called, DuckDNS records have a fixed TTL.
+func (r *duckDNSRecords) UpdateTTL(ctx context.Context, record Record) error {
+	return nil
+}
+
+func (r *duckDNSRecords) Commit(ctx context.Context) error {
 	baseURL := "https://www.duckdns.org/update"
 	params := url.Values{}
-	params.Add("token", d.APIToken)
-	params.Add("domains", hostname)
-	params.Add("ip", ipv4)
-	params.Add("ipv6", ipv6)
+	params.Add("token", r.APIToken)
+	params.Add("domains", r.hostname)
+	params.Add("verbose", "true")
+	if len(r.values) == 0 {
+		// without addresses DuckDNS would detect them from the request, clear the records instead
+		params.Add("clear", "true")
+	} else {
+		params.Add("ip", r.values["A"])
+		params.Add("ipv6", r.values["AAAA"])
+	}
 
 	updateURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
-	resp, err := http.Get(updateURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, updateURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to update record: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+		return statusError(resp.StatusCode, fmt.Errorf("received non-200 status code: %d", resp.StatusCode))
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -100,11 +155,15 @@ func (d *DuckDNS) Update(hostname string, addrCollection *ipv6disc.AddrCollectio
 		return fmt.Errorf("failed to read response body: %v", err)
 	}
 
-	responseBody := string(body)
-	if responseBody != "OK" {
-		return fmt.Errorf("response body does not contain 'OK': %s", responseBody)
+	// verbose responses are OK or KO followed by the IPv4, the IPv6 and UPDATED or NOCHANGE, one per line
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if lines[0] == "KO" {
+		// DuckDNS only answers KO for an invalid token or a domain not owned by it
+		return Permanent(fmt.Errorf("update rejected, check the token and domain: %s", lines[0]))
+	}
+	if lines[0] != "OK" {
+		return fmt.Errorf("response body does not contain 'OK': %s", string(body))
 	}
-
 	return nil
 }
 
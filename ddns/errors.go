@@ -0,0 +1,54 @@
+package ddns
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// PermanentError is an update error that retrying will not fix by itself,
+// like rejected credentials or a zone that does not exist.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks err as a permanent error, nil is returned unchanged.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err or any error it wraps is permanent.
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// statusError marks err as permanent when the HTTP status code means the
+// request was rejected because of the credentials.
+func statusError(statusCode int, err error) error {
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return Permanent(err)
+	}
+	return err
+}
+
+// sshError marks err as permanent when the SSH server rejected the credentials.
+func sshError(err error) error {
+	// x/crypto/ssh does not return a typed error for failed authentication
+	if strings.Contains(err.Error(), "unable to authenticate") {
+		return Permanent(err)
+	}
+	return err
+}
@@ -6,14 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"net/netip"
-	"os"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/miguelangel-nubla/ipv6ddns/ddns/gravity"
 	"github.com/miguelangel-nubla/ipv6disc"
-	"github.com/xeipuuv/gojsonschema"
 )
 
 type Gravity struct {
@@ -27,14 +24,18 @@ func init() {
 	RegisterProvider("gravity", NewGravity)
 }
 
-func NewGravity(settings ProviderSettings) Service {
+func NewGravity(settings ProviderSettings) (Service, error) {
 	var service Gravity
-	gravityValidateConfig(settings.(json.RawMessage))
-	json.Unmarshal(settings.(json.RawMessage), &service)
-	return &service
+	if err := gravityValidateConfig(settings.(json.RawMessage)); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(settings.(json.RawMessage), &service); err != nil {
+		return nil, err
+	}
+	return &service, nil
 }
 
-func gravityValidateConfig(config json.RawMessage) {
+func gravityValidateConfig(config json.RawMessage) error {
 	var configSchema = []byte(`
 	{
 		"$schema": "http://json-schema.org/draft-07/schema#",
@@ -66,137 +67,108 @@ func gravityValidateConfig(config json.RawMessage) {
 	}
 	`)
 
-	schemaLoader := gojsonschema.NewBytesLoader(configSchema)
-	dataLoader := gojsonschema.NewBytesLoader([]byte(config))
+	return validateSettings("Gravity", configSchema, config)
+}
 
-	result, err := gojsonschema.Validate(schemaLoader, dataLoader)
+func (g *Gravity) Update(ctx context.Context, hostname string, addrCollection *ipv6disc.AddrCollection) ([]Change, error) {
+	apiClient, err := gravity.NewClientWithResponses(g.Server)
 	if err != nil {
-		panic(err.Error())
+		return nil, fmt.Errorf("failed to create gravity client: %v", err)
 	}
 
-	if !result.Valid() {
-		fmt.Printf("Gravity configuration is not valid.\nErrors:\n")
-		for _, desc := range result.Errors() {
-			fmt.Printf("- %s\n", desc)
-		}
-		os.Exit(1)
+	records := &gravityRecords{
+		Gravity:   g,
+		apiClient: apiClient,
+		hostname:  hostname,
+		requestEditors: []gravity.RequestEditorFn{
+			func(ctx context.Context, req *http.Request) error {
+				req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", g.APIKey))
+				return nil
+			},
+		},
 	}
+	return Reconcile(ctx, records, addrCollection, g.TTL)
 }
 
-func (g *Gravity) Update(hostname string, addrCollection *ipv6disc.AddrCollection) error {
-	requestEditors := []gravity.RequestEditorFn{
-		func(ctx context.Context, req *http.Request) error {
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", g.APIKey))
-			return nil
-		},
-	}
-	apiClient, err := gravity.NewClientWithResponses(g.Server)
-	if err != nil {
-		return fmt.Errorf("failed to create gravity client: %v", err)
-	}
+// gravityRecords is the RecordSet of a hostname in a Gravity zone.
+type gravityRecords struct {
+	*Gravity
+	apiClient      *gravity.ClientWithResponses
+	requestEditors []gravity.RequestEditorFn
+	hostname       string
+}
 
+func (r *gravityRecords) List(ctx context.Context) ([]Record, error) {
 	params := gravity.DnsGetRecordsParams{
-		Zone:     &g.Zone,
-		Hostname: &hostname,
+		Zone:     &r.Zone,
+		Hostname: &r.hostname,
 	}
-	currentRecords, err := apiClient.DnsGetRecordsWithResponse(context.Background(), &params, requestEditors...)
+	currentRecords, err := r.apiClient.DnsGetRecordsWithResponse(ctx, &params, r.requestEditors...)
 	if err != nil {
-		return fmt.Errorf("failed to call current records: %v", err)
+		return nil, fmt.Errorf("failed to call current records: %v", err)
 	}
 
 	if currentRecords.JSON200 == nil {
-		return fmt.Errorf("failed to get current records: %v", currentRecords.Status())
-	}
-
-	if currentRecords.JSON200.Records == nil {
-		records := make([]gravity.DnsAPIRecord, 0)
-		currentRecords.JSON200.Records = &records
+		return nil, statusError(currentRecords.StatusCode(), fmt.Errorf("failed to get current records: %v", currentRecords.Status()))
 	}
 
-	// Build a set of current IP addresses
-	currentIPs := make(map[string]string)
-	for _, record := range *currentRecords.JSON200.Records {
-		if record.Type != "AAAA" && record.Type != "A" {
-			continue
+	var records []Record
+	if currentRecords.JSON200.Records != nil {
+		for _, record := range *currentRecords.JSON200.Records {
+			records = append(records, Record{ID: record.Uid, Type: record.Type, Value: record.Data})
 		}
-		addr, err := netip.ParseAddr(record.Data)
-		if err != nil {
-			return fmt.Errorf("invalid existing record found: %v", err)
-		}
-		ip := addr.WithZone("").String()
-		currentIPs[ip] = record.Type
 	}
+	return records, nil
+}
 
-	// Build a set of desired IP addresses
-	desiredIPs := make(map[string]string)
-	for _, addr := range addrCollection.Get() {
-		recordType := "AAAA"
-		if addr.Addr.Is4() {
-			recordType = "A"
-		}
-		desiredIPs[addr.WithZone("").String()] = recordType
-	}
-
-	// Create records as necessary
-	for ip, recordType := range desiredIPs {
-		_, exists := currentIPs[ip]
-		if !exists {
-			uid := uuid.New().String()
-			response, err := apiClient.DnsPutRecordsWithResponse(
-				context.Background(),
-				&gravity.DnsPutRecordsParams{
-					Zone:     g.Zone,
-					Hostname: hostname,
-					Uid:      &uid,
-				},
-				gravity.DnsPutRecordsJSONRequestBody{
-					Type: recordType,
-					Data: ip,
-				},
-				requestEditors...,
-			)
-			if err != nil {
-				return fmt.Errorf("failed to call create DNS record: %v", err)
-			}
-
-			if response.StatusCode() < 200 || response.StatusCode() >= 300 {
-				return fmt.Errorf("failed to create DNS record: %v", response.Status())
-			}
-		}
+func (r *gravityRecords) Create(ctx context.Context, record Record) error {
+	uid := uuid.New().String()
+	response, err := r.apiClient.DnsPutRecordsWithResponse(
+		ctx,
+		&gravity.DnsPutRecordsParams{
+			Zone:     r.Zone,
+			Hostname: r.hostname,
+			Uid:      &uid,
+		},
+		gravity.DnsPutRecordsJSONRequestBody{
+			Type: record.Type,
+			Data: record.Value,
+		},
+		r.requestEditors...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to call create DNS record: %v", err)
 	}
 
-	// Update or delete records as necessary
-	for _, record := range *currentRecords.JSON200.Records {
-		if record.Type != "AAAA" && record.Type != "A" {
-			continue
-		}
+	if response.StatusCode() < 200 || response.StatusCode() >= 300 {
+		return statusError(response.StatusCode(), fmt.Errorf("failed to create DNS record: %v", response.Status()))
+	}
+	return nil
+}
 
-		ip := record.Data
-		_, exists := desiredIPs[ip]
-		if !exists {
-			// Delete the DNS record
-			response, err := apiClient.DnsDeleteRecordsWithResponse(
-				context.Background(),
-				&gravity.DnsDeleteRecordsParams{
-					Zone:     g.Zone,
-					Hostname: hostname,
-					Type:     record.Type,
-					Uid:      record.Uid,
-				},
-				requestEditors...,
-			)
-			if err != nil {
-				return fmt.Errorf("failed to call delete DNS record: %v", err)
-			}
+func (r *gravityRecords) Delete(ctx context.Context, record Record) error {
+	response, err := r.apiClient.DnsDeleteRecordsWithResponse(
+		ctx,
+		&gravity.DnsDeleteRecordsParams{
+			Zone:     r.Zone,
+			Hostname: r.hostname,
+			Type:     record.Type,
+			Uid:      record.ID,
+		},
+		r.requestEditors...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to call delete DNS record: %v", err)
+	}
 
-			if response.StatusCode() < 200 || response.StatusCode() >= 300 {
-				return fmt.Errorf("failed to delete DNS record: %v", response.Status())
-			}
-		} else {
-			// Nothing to update for now
-		}
+	if response.StatusCode() < 200 || response.StatusCode() >= 300 {
+		return statusError(response.StatusCode(), fmt.Errorf("failed to delete DNS record: %v", response.Status()))
 	}
+	return nil
+}
 
+// UpdateTTL is never called, the records listed carry no TTL.
+func (r *gravityRecords) UpdateTTL(ctx context.Context, record Record) error {
 	return nil
 }
 
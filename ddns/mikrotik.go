@@ -1,19 +1,17 @@
 package ddns
 
 import (
+	"context"
 	"crypto/sha256"
 	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/netip"
-	"os"
 	"time"
 
 	"github.com/go-routeros/routeros/v3"
 	"github.com/miguelangel-nubla/ipv6disc"
-	"github.com/xeipuuv/gojsonschema"
 )
 
 type Mikrotik struct {
@@ -30,14 +28,18 @@ func init() {
 	RegisterProvider("mikrotik", NewMikrotik)
 }
 
-func NewMikrotik(settings ProviderSettings) Service {
+func NewMikrotik(settings ProviderSettings) (Service, error) {
 	var service Mikrotik
-	mikrotikValidateConfig(settings.(json.RawMessage))
-	json.Unmarshal(settings.(json.RawMessage), &service)
-	return &service
+	if err := mikrotikValidateConfig(settings.(json.RawMessage)); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(settings.(json.RawMessage), &service); err != nil {
+		return nil, err
+	}
+	return &service, nil
 }
 
-func mikrotikValidateConfig(config json.RawMessage) {
+func mikrotikValidateConfig(config json.RawMessage) error {
 	var configSchema = []byte(`
 	{
 		"$schema": "http://json-schema.org/draft-07/schema#",
@@ -79,24 +81,10 @@ func mikrotikValidateConfig(config json.RawMessage) {
 	}
 	`)
 
-	schemaLoader := gojsonschema.NewBytesLoader(configSchema)
-	dataLoader := gojsonschema.NewBytesLoader([]byte(config))
-
-	result, err := gojsonschema.Validate(schemaLoader, dataLoader)
-	if err != nil {
-		panic(err.Error())
-	}
-
-	if !result.Valid() {
-		fmt.Printf("Mikrotik configuration is not valid.\nErrors:\n")
-		for _, desc := range result.Errors() {
-			fmt.Printf("- %s\n", desc)
-		}
-		os.Exit(1)
-	}
+	return validateSettings("Mikrotik", configSchema, config)
 }
 
-func (m *Mikrotik) Update(hostname string, addrCollection *ipv6disc.AddrCollection) error {
+func (m *Mikrotik) Update(ctx context.Context, hostname string, addrCollection *ipv6disc.AddrCollection) ([]Change, error) {
 	var client *routeros.Client
 	var err error
 
@@ -114,87 +102,75 @@ func (m *Mikrotik) Update(hostname string, addrCollection *ipv6disc.AddrCollecti
 				return fmt.Errorf("certificate fingerprint mismatch")
 			}
 		}
-		client, err = routeros.DialTLS(m.Address, m.Username, m.Password, tlsConfig)
+		client, err = routeros.DialTLSContext(ctx, m.Address, m.Username, m.Password, tlsConfig)
 	} else {
-		client, err = routeros.Dial(m.Address, m.Username, m.Password)
+		client, err = routeros.DialContext(ctx, m.Address, m.Username, m.Password)
 	}
 
 	if err != nil {
-		return fmt.Errorf("failed to connect to Mikrotik: %v", err)
+		err = fmt.Errorf("failed to connect to Mikrotik: %w", err)
+		// the device only replies with an error on login when the credentials are rejected
+		var deviceErr *routeros.DeviceError
+		if errors.As(err, &deviceErr) {
+			return nil, Permanent(err)
+		}
+		return nil, err
 	}
 	defer client.Close()
 
-	fqdn := FQDN(hostname, m.Zone)
+	records := &mikrotikRecords{
+		Mikrotik: m,
+		client:   client,
+		fqdn:     FQDN(hostname, m.Zone),
+	}
+	return Reconcile(ctx, records, addrCollection, m.TTL)
+}
+
+// mikrotikRecords is the RecordSet of a hostname in the RouterOS static DNS.
+type mikrotikRecords struct {
+	*Mikrotik
+	client *routeros.Client
+	fqdn   string
+}
 
-	// Fetch existing records for this hostname
-	reply, err := client.Run("/ip/dns/static/print", "?name="+fqdn)
+func (r *mikrotikRecords) List(ctx context.Context) ([]Record, error) {
+	reply, err := r.client.RunContext(ctx, "/ip/dns/static/print", "?name="+r.fqdn)
 	if err != nil {
-		return fmt.Errorf("failed to fetch DNS records: %v", err)
+		return nil, fmt.Errorf("failed to fetch DNS records: %v", err)
 	}
 
-	type dnsRecord struct {
-		id  string
-		ttl string
-	}
-	currentIPs := make(map[string]dnsRecord) // IP -> Record
+	var records []Record
 	for _, re := range reply.Re {
-		id := re.Map[".id"]
-		addr := re.Map["address"]
-		recordType := re.Map["type"]
-		ttl := re.Map["ttl"]
-
-		// Filter only A and AAAA records
-		if recordType != "A" && recordType != "AAAA" {
-			continue
-		}
-
-		if _, err := netip.ParseAddr(addr); err == nil {
-			currentIPs[addr] = dnsRecord{id: id, ttl: ttl}
-		}
+		record := Record{ID: re.Map[".id"], Type: re.Map["type"], Value: re.Map["address"]}
+		record.TTL, err = time.ParseDuration(re.Map["ttl"])
+		// If parsing fails we force update to be safe.
+		record.Stale = err != nil
+		records = append(records, record)
 	}
+	return records, nil
+}
 
-	desiredIPs := make(map[string]bool)
-	for _, addr := range addrCollection.Get() {
-		ip := addr.WithZone("").String()
-		desiredIPs[ip] = true
+func (r *mikrotikRecords) Create(ctx context.Context, record Record) error {
+	_, err := r.client.RunContext(ctx, "/ip/dns/static/add", "=name="+r.fqdn, "=address="+record.Value, "=type="+record.Type, "=ttl="+record.TTL.String())
+	if err != nil {
+		return fmt.Errorf("failed to add DNS record %s -> %s: %v", r.fqdn, record.Value, err)
 	}
+	return nil
+}
 
-	// Create missing records
-	for ip := range desiredIPs {
-		if _, exists := currentIPs[ip]; !exists {
-			// Determine type
-			recordType := "A"
-			if addr, err := netip.ParseAddr(ip); err == nil && addr.Is6() {
-				recordType = "AAAA"
-			}
-
-			_, err := client.Run("/ip/dns/static/add", "=name="+fqdn, "=address="+ip, "=type="+recordType, "=ttl="+m.TTL.String())
-			if err != nil {
-				return fmt.Errorf("failed to add DNS record %s -> %s: %v", fqdn, ip, err)
-			}
-		}
+func (r *mikrotikRecords) Delete(ctx context.Context, record Record) error {
+	_, err := r.client.RunContext(ctx, "/ip/dns/static/remove", "=.id="+record.ID)
+	if err != nil {
+		return fmt.Errorf("failed to remove DNS record %s -> %s: %v", r.fqdn, record.Value, err)
 	}
+	return nil
+}
 
-	// Remove obsolete records
-	for ip, record := range currentIPs {
-		if _, keep := desiredIPs[ip]; !keep {
-			_, err := client.Run("/ip/dns/static/remove", "=.id="+record.id)
-			if err != nil {
-				return fmt.Errorf("failed to remove DNS record %s -> %s: %v", fqdn, ip, err)
-			}
-		} else {
-			// Update TTL if needed
-			currentTTL, err := time.ParseDuration(record.ttl)
-			// If parsing fails we force update to be safe.
-			if err != nil || currentTTL != m.TTL {
-				_, err := client.Run("/ip/dns/static/set", "=.id="+record.id, "=ttl="+m.TTL.String())
-				if err != nil {
-					return fmt.Errorf("failed to update DNS record TTL %s -> %s: %v", fqdn, ip, err)
-				}
-			}
-		}
+func (r *mikrotikRecords) UpdateTTL(ctx context.Context, record Record) error {
+	_, err := r.client.RunContext(ctx, "/ip/dns/static/set", "=.id="+record.ID, "=ttl="+record.TTL.String())
+	if err != nil {
+		return fmt.Errorf("failed to update DNS record TTL %s -> %s: %v", r.fqdn, record.Value, err)
 	}
-
 	return nil
 }
 
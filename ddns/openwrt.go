@@ -1,18 +1,17 @@
 package ddns
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/netip"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/miguelangel-nubla/ipv6disc"
-	"github.com/xeipuuv/gojsonschema"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -29,14 +28,18 @@ func init() {
 	RegisterProvider("openwrt", NewOpenWrt)
 }
 
-func NewOpenWrt(settings ProviderSettings) Service {
+func NewOpenWrt(settings ProviderSettings) (Service, error) {
 	var service OpenWrt
-	openwrtValidateConfig(settings.(json.RawMessage))
-	json.Unmarshal(settings.(json.RawMessage), &service)
-	return &service
+	if err := openwrtValidateConfig(settings.(json.RawMessage)); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(settings.(json.RawMessage), &service); err != nil {
+		return nil, err
+	}
+	return &service, nil
 }
 
-func openwrtValidateConfig(config json.RawMessage) {
+func openwrtValidateConfig(config json.RawMessage) error {
 	var configSchema = []byte(`
 	{
 		"$schema": "http://json-schema.org/draft-07/schema#",
@@ -73,24 +76,10 @@ func openwrtValidateConfig(config json.RawMessage) {
 	}
 	`)
 
-	schemaLoader := gojsonschema.NewBytesLoader(configSchema)
-	dataLoader := gojsonschema.NewBytesLoader([]byte(config))
-
-	result, err := gojsonschema.Validate(schemaLoader, dataLoader)
-	if err != nil {
-		panic(err.Error())
-	}
-
-	if !result.Valid() {
-		fmt.Printf("OpenWrt configuration is not valid.\nErrors:\n")
-		for _, desc := range result.Errors() {
-			fmt.Printf("- %s\n", desc)
-		}
-		os.Exit(1)
-	}
+	return validateSettings("OpenWrt", configSchema, config)
 }
 
-func (o *OpenWrt) Update(hostname string, addrCollection *ipv6disc.AddrCollection) error {
+func (o *OpenWrt) Update(ctx context.Context, hostname string, addrCollection *ipv6disc.AddrCollection) ([]Change, error) {
 	// 1. Establish SSH connection
 	config := &ssh.ClientConfig{
 		User:            o.Username,
@@ -101,11 +90,11 @@ func (o *OpenWrt) Update(hostname string, addrCollection *ipv6disc.AddrCollectio
 	if o.SSHKey != "" {
 		key, err := os.ReadFile(o.SSHKey)
 		if err != nil {
-			return fmt.Errorf("unable to read private key: %v", err)
+			return nil, Permanent(fmt.Errorf("unable to read private key: %v", err))
 		}
 		signer, err := ssh.ParsePrivateKey(key)
 		if err != nil {
-			return fmt.Errorf("unable to parse private key: %v", err)
+			return nil, Permanent(fmt.Errorf("unable to parse private key: %v", err))
 		}
 		config.Auth = []ssh.AuthMethod{
 			ssh.PublicKeys(signer),
@@ -115,7 +104,7 @@ func (o *OpenWrt) Update(hostname string, addrCollection *ipv6disc.AddrCollectio
 			ssh.Password(o.Password),
 		}
 	} else {
-		return fmt.Errorf("no authentication method provided for OpenWrt")
+		return nil, Permanent(fmt.Errorf("no authentication method provided for OpenWrt"))
 	}
 
 	// Default port 22 if not specified
@@ -124,26 +113,49 @@ func (o *OpenWrt) Update(hostname string, addrCollection *ipv6disc.AddrCollectio
 		address = address + ":22"
 	}
 
-	client, err := ssh.Dial("tcp", address, config)
+	client, err := sshDial(ctx, address, config)
 	if err != nil {
-		return fmt.Errorf("failed to dial: %v", err)
+		return nil, sshError(fmt.Errorf("failed to dial: %w", err))
 	}
 	defer client.Close()
 
-	session, err := client.NewSession()
+	records := &openWrtRecords{
+		client: client,
+		fqdn:   FQDN(hostname, o.Zone),
+	}
+	return Reconcile(ctx, records, addrCollection, o.TTL)
+}
+
+// openWrtRecords is the RecordSet of a hostname in the dnsmasq domains of the
+// OpenWrt dhcp configuration, the changes are staged until committed.
+type openWrtRecords struct {
+	client *ssh.Client
+	fqdn   string
+}
+
+// run runs a command in a new session and returns its output.
+func (r *openWrtRecords) run(cmd string) (string, error) {
+	session, err := r.client.NewSession()
 	if err != nil {
-		return fmt.Errorf("failed to create session: %v", err)
+		return "", fmt.Errorf("failed to create session: %v", err)
 	}
 	defer session.Close()
 
-	// 2. Fetch current configuration
-	output, err := session.CombinedOutput("uci show dhcp")
+	output, err := session.CombinedOutput(cmd)
+	if err != nil {
+		return string(output), fmt.Errorf("command %s failed: %v, output: %s", cmd, err, string(output))
+	}
+	return string(output), nil
+}
+
+func (r *openWrtRecords) List(ctx context.Context) ([]Record, error) {
+	// Fetch current configuration
+	uciOutput, err := r.run("uci show dhcp")
 	if err != nil {
-		return fmt.Errorf("failed to run uci show dhcp: %v", err)
+		return nil, fmt.Errorf("failed to run uci show dhcp: %v", err)
 	}
-	uciOutput := string(output)
 
-	// 3. Parse existing records
+	// Parse existing records
 	type uciRecord struct {
 		id   string
 		name string
@@ -151,9 +163,7 @@ func (o *OpenWrt) Update(hostname string, addrCollection *ipv6disc.AddrCollectio
 	}
 
 	// Map of ID -> Record
-	records := make(map[string]*uciRecord)
-
-	fqdn := FQDN(hostname, o.Zone)
+	uciRecords := make(map[string]*uciRecord)
 
 	lines := strings.Split(uciOutput, "\n")
 	for _, line := range lines {
@@ -185,8 +195,8 @@ func (o *OpenWrt) Update(hostname string, addrCollection *ipv6disc.AddrCollectio
 			// pathParts[1] is ID (e.g. @domain[0] or cfg...)
 			id := pathParts[1]
 
-			if _, ok := records[id]; !ok {
-				records[id] = &uciRecord{id: id}
+			if _, ok := uciRecords[id]; !ok {
+				uciRecords[id] = &uciRecord{id: id}
 			}
 
 			if len(pathParts) == 3 {
@@ -194,100 +204,59 @@ func (o *OpenWrt) Update(hostname string, addrCollection *ipv6disc.AddrCollectio
 				field := pathParts[2]
 				switch field {
 				case "name":
-					records[id].name = value
+					uciRecords[id].name = value
 				case "ip":
-					records[id].ip = value
+					uciRecords[id].ip = value
 				}
 			}
 		}
 	}
 
 	// Filter records that match our hostname
-	existingIPs := make(map[string]string) // IP -> ID
-	var idsToDelete []string
-
-	for id, rec := range records {
-		if rec.name == fqdn && rec.ip != "" {
-			if _, err := netip.ParseAddr(rec.ip); err == nil {
-				existingIPs[rec.ip] = id
-			}
-		}
-	}
-
-	desiredIPs := make(map[string]bool)
-	for _, addr := range addrCollection.Get() {
-		ip := addr.WithZone("").String()
-		desiredIPs[ip] = true
-	}
-
-	// 4. Calculate diff
-	// IPs to delete
-	for ip, id := range existingIPs {
-		if !desiredIPs[ip] {
-			idsToDelete = append(idsToDelete, id)
+	var records []Record
+	for id, rec := range uciRecords {
+		if rec.name == r.fqdn && rec.ip != "" {
+			records = append(records, Record{ID: id, Type: recordType(rec.ip), Value: rec.ip})
 		}
 	}
+	return records, nil
+}
 
-	// IPs to add
-	var ipsToAdd []string
-	for ip := range desiredIPs {
-		if _, exists := existingIPs[ip]; !exists {
-			ipsToAdd = append(ipsToAdd, ip)
-		}
-	}
+func (r *openWrtRecords) Create(ctx context.Context, record Record) error {
+	// Create deterministic ID
+	hash := sha256.Sum256([]byte(r.fqdn + record.Value))
+	id := "ipv6ddns_" + hex.EncodeToString(hash[:])[:8]
 
-	if len(idsToDelete) == 0 && len(ipsToAdd) == 0 {
-		return nil // No changes needed
+	// Add new section (named)
+	if _, err := r.run(fmt.Sprintf("uci set dhcp.%s=domain", id)); err != nil {
+		return err
 	}
-
-	// 5. Apply changes
-	// We execute commands sequentially to ensure reliability and capture proper IDs from uci add.
-	// Helper to run a command and return output
-	runCmd := func(cmd string) (string, error) {
-		session, err := client.NewSession()
-		if err != nil {
-			return "", fmt.Errorf("failed to create session: %v", err)
-		}
-		defer session.Close()
-
-		output, err := session.CombinedOutput(cmd)
-		if err != nil {
-			return string(output), fmt.Errorf("command %s failed: %v, output: %s", cmd, err, string(output))
-		}
-		return string(output), nil
+	if _, err := r.run(fmt.Sprintf("uci set dhcp.%s.name='%s'", id, r.fqdn)); err != nil {
+		return err
 	}
-
-	for _, id := range idsToDelete {
-		if _, err := runCmd(fmt.Sprintf("uci delete dhcp.%s", id)); err != nil {
-			return err
-		}
+	if _, err := r.run(fmt.Sprintf("uci set dhcp.%s.ip='%s'", id, record.Value)); err != nil {
+		return err
 	}
+	return nil
+}
 
-	for _, ip := range ipsToAdd {
-		// Create deterministic ID
-		hash := sha256.Sum256([]byte(fqdn + ip))
-		id := "ipv6ddns_" + hex.EncodeToString(hash[:])[:8]
+func (r *openWrtRecords) Delete(ctx context.Context, record Record) error {
+	_, err := r.run(fmt.Sprintf("uci delete dhcp.%s", record.ID))
+	return err
+}
 
-		// Add new section (named)
-		if _, err := runCmd(fmt.Sprintf("uci set dhcp.%s=domain", id)); err != nil {
-			return err
-		}
-		if _, err := runCmd(fmt.Sprintf("uci set dhcp.%s.name='%s'", id, fqdn)); err != nil {
-			return err
-		}
-		if _, err := runCmd(fmt.Sprintf("uci set dhcp.%s.ip='%s'", id, ip)); err != nil {
-			return err
-		}
-	}
+// UpdateTTL is never called, dnsmasq domains have no TTL.
+func (r *openWrtRecords) UpdateTTL(ctx context.Context, record Record) error {
+	return nil
+}
 
-	if _, err := runCmd("uci commit dhcp"); err != nil {
+func (r *openWrtRecords) Commit(ctx context.Context) error {
+	if _, err := r.run("uci commit dhcp"); err != nil {
 		return err
 	}
-	if _, err := runCmd("/etc/init.d/dnsmasq reload"); err != nil {
-		return err
-	}
-
-	return nil
+	// uci changes only take effect once committed
+	_, err := r.run("/etc/init.d/dnsmasq reload")
+	return err
 }
 
 func (o *OpenWrt) PrettyPrint(prefix string) ([]byte, error) {
@@ -2,6 +2,7 @@ package ddns
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
@@ -11,12 +12,10 @@ import (
 	"io"
 	"net/http"
 	"net/netip"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/miguelangel-nubla/ipv6disc"
-	"github.com/xeipuuv/gojsonschema"
 )
 
 type OpnsenseUnbound struct {
@@ -32,14 +31,18 @@ func init() {
 	RegisterProvider("opnsense_unbound", NewOpnsenseUnbound)
 }
 
-func NewOpnsenseUnbound(settings ProviderSettings) Service {
+func NewOpnsenseUnbound(settings ProviderSettings) (Service, error) {
 	var service OpnsenseUnbound
-	opnsenseUnboundValidateConfig(settings.(json.RawMessage))
-	json.Unmarshal(settings.(json.RawMessage), &service)
-	return &service
+	if err := opnsenseUnboundValidateConfig(settings.(json.RawMessage)); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(settings.(json.RawMessage), &service); err != nil {
+		return nil, err
+	}
+	return &service, nil
 }
 
-func opnsenseUnboundValidateConfig(config json.RawMessage) {
+func opnsenseUnboundValidateConfig(config json.RawMessage) error {
 	var configSchema = []byte(`
 	{
 		"$schema": "http://json-schema.org/draft-07/schema#",
@@ -79,24 +82,10 @@ func opnsenseUnboundValidateConfig(config json.RawMessage) {
 	}
 	`)
 
-	schemaLoader := gojsonschema.NewBytesLoader(configSchema)
-	dataLoader := gojsonschema.NewBytesLoader([]byte(config))
-
-	result, err := gojsonschema.Validate(schemaLoader, dataLoader)
-	if err != nil {
-		panic(err.Error())
-	}
-
-	if !result.Valid() {
-		fmt.Printf("OpnsenseUnbound configuration is not valid.\nErrors:\n")
-		for _, desc := range result.Errors() {
-			fmt.Printf("- %s\n", desc)
-		}
-		os.Exit(1)
-	}
+	return validateSettings("OpnsenseUnbound", configSchema, config)
 }
 
-func (u *OpnsenseUnbound) Update(hostname string, addrCollection *ipv6disc.AddrCollection) error {
+func (u *OpnsenseUnbound) Update(ctx context.Context, hostname string, addrCollection *ipv6disc.AddrCollection) ([]Change, error) {
 	tlsConfig := &tls.Config{}
 
 	// Use custom verification to support fallback to fingerprint
@@ -132,7 +121,7 @@ func (u *OpnsenseUnbound) Update(hostname string, addrCollection *ipv6disc.AddrC
 				// Fingerprint matched
 				return nil
 			}
-			fmt.Printf("Certificate fingerprint mismatch. Expected: %s, Found: %s\n", u.TLSFingerprint, fp)
+			return fmt.Errorf("certificate verification failed: %w (fingerprint mismatch, expected: %s, found: %s)", err, u.TLSFingerprint, fp)
 		}
 
 		// Both methods failed
@@ -146,77 +135,68 @@ func (u *OpnsenseUnbound) Update(hostname string, addrCollection *ipv6disc.AddrC
 		Timeout: 30 * time.Second,
 	}
 
-	// 1. Fetch existing Host Overrides
-	existingOverrides, err := u.getOverrides(client)
-	if err != nil {
-		return fmt.Errorf("failed to fetch existing Host Overrides: %v", err)
-	}
-
 	fqdn := FQDN(hostname, u.Zone)
-	desiredIPs := make(map[string]bool)
-	for _, addr := range addrCollection.Get() {
-		ip := addr.WithZone("").String()
-		desiredIPs[ip] = true
-	}
-
-	// Filter existing overrides for this FQDN
 	hostPart, domainPart := SplitFQDN(fqdn)
+	records := &opnsenseUnboundRecords{
+		OpnsenseUnbound: u,
+		client:          client,
+		fqdn:            fqdn,
+		host:            hostPart,
+		domain:          domainPart,
+	}
+	return Reconcile(ctx, records, addrCollection, u.TTL)
+}
 
-	currentIPs := make(map[string][]string) // IP -> list of UUIDs
-	for _, row := range existingOverrides {
-		if row.Hostname == hostPart && row.Domain == domainPart {
-			upperRR := strings.ToUpper(row.RR)
-			if upperRR == "AAAA" || upperRR == "A" {
-				currentIPs[row.Server] = append(currentIPs[row.Server], row.UUID)
-			}
-		}
-	}
+// opnsenseUnboundRecords is the RecordSet of a hostname in the Unbound Host
+// Overrides, the changes take effect once Unbound is reconfigured.
+type opnsenseUnboundRecords struct {
+	*OpnsenseUnbound
+	client *http.Client
+	fqdn   string
+	host   string
+	domain string
+}
 
-	changesMade := false
+func (r *opnsenseUnboundRecords) List(ctx context.Context) ([]Record, error) {
+	existingOverrides, err := r.getOverrides(ctx, r.client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch existing Host Overrides: %w", err)
+	}
 
-	// 2. Manage IPs
-	// Add missing IPs and clean up duplicates for existing ones
-	for ip := range desiredIPs {
-		uuids, exists := currentIPs[ip]
-		if !exists {
-			err := u.addOverride(client, hostPart, domainPart, ip)
-			if err != nil {
-				return fmt.Errorf("failed to add override %s -> %s: %v", fqdn, ip, err)
-			}
-			changesMade = true
-		} else if len(uuids) > 1 {
-			// Duplicate records exist for this IP, remove extra ones
-			for i := 1; i < len(uuids); i++ {
-				err := u.deleteOverride(client, uuids[i])
-				if err != nil {
-					fmt.Printf("Warning: failed to delete duplicate override %s -> %s (UUID: %s): %v\n", fqdn, ip, uuids[i], err)
-				} else {
-					changesMade = true
-				}
-			}
+	var records []Record
+	for _, row := range existingOverrides {
+		if row.Hostname == r.host && row.Domain == r.domain {
+			records = append(records, Record{ID: row.UUID, Type: strings.ToUpper(row.RR), Value: row.Server})
 		}
 	}
+	return records, nil
+}
 
-	// 3. Remove obsolete IPs
-	for ip, uuids := range currentIPs {
-		if _, keep := desiredIPs[ip]; !keep {
-			for _, uuid := range uuids {
-				err := u.deleteOverride(client, uuid)
-				if err != nil {
-					return fmt.Errorf("failed to delete override %s -> %s: %v", fqdn, ip, err)
-				}
-				changesMade = true
-			}
-		}
+func (r *opnsenseUnboundRecords) Create(ctx context.Context, record Record) error {
+	err := r.addOverride(ctx, r.client, r.host, r.domain, record.Value)
+	if err != nil {
+		return fmt.Errorf("failed to add override %s -> %s: %w", r.fqdn, record.Value, err)
 	}
+	return nil
+}
 
-	// 4. Trigger Reconfigure if changes made
-	if changesMade {
-		if err := u.reconfigure(client); err != nil {
-			return fmt.Errorf("failed to reconfigure Unbound: %v", err)
-		}
+func (r *opnsenseUnboundRecords) Delete(ctx context.Context, record Record) error {
+	err := r.deleteOverride(ctx, r.client, record.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete override %s -> %s: %w", r.fqdn, record.Value, err)
 	}
+	return nil
+}
+
+// UpdateTTL is never called, the Host Overrides listed carry no TTL.
+func (r *opnsenseUnboundRecords) UpdateTTL(ctx context.Context, record Record) error {
+	return nil
+}
 
+func (r *opnsenseUnboundRecords) Commit(ctx context.Context) error {
+	if err := r.reconfigure(ctx, r.client); err != nil {
+		return fmt.Errorf("failed to reconfigure Unbound: %w", err)
+	}
 	return nil
 }
 
@@ -240,9 +220,9 @@ type opnsenseResponse struct {
 	Status string `json:"status"`
 }
 
-func (u *OpnsenseUnbound) getOverrides(client *http.Client) ([]unboundOverrideRow, error) {
+func (u *OpnsenseUnbound) getOverrides(ctx context.Context, client *http.Client) ([]unboundOverrideRow, error) {
 	url := fmt.Sprintf("%s/api/unbound/settings/searchHostOverride", strings.TrimRight(u.Address, "/"))
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -256,7 +236,7 @@ func (u *OpnsenseUnbound) getOverrides(client *http.Client) ([]unboundOverrideRo
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, statusError(resp.StatusCode, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body)))
 	}
 
 	var searchResp unboundSearchResponse
@@ -264,15 +244,10 @@ func (u *OpnsenseUnbound) getOverrides(client *http.Client) ([]unboundOverrideRo
 		return nil, err
 	}
 
-	fmt.Printf("getOverrides found %d rows\n", len(searchResp.Rows))
-	for _, row := range searchResp.Rows {
-		fmt.Printf("  Row: UUID=%s, Hostname=%s, Domain=%s, RR=%s, Server=%s\n", row.UUID, row.Hostname, row.Domain, row.RR, row.Server)
-	}
-
 	return searchResp.Rows, nil
 }
 
-func (u *OpnsenseUnbound) addOverride(client *http.Client, hostname, domain, ip string) error {
+func (u *OpnsenseUnbound) addOverride(ctx context.Context, client *http.Client, hostname, domain, ip string) error {
 	url := fmt.Sprintf("%s/api/unbound/settings/addHostOverride", strings.TrimRight(u.Address, "/"))
 
 	recordType := "A"
@@ -297,7 +272,7 @@ func (u *OpnsenseUnbound) addOverride(client *http.Client, hostname, domain, ip
 		return err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(data))
 	if err != nil {
 		return err
 	}
@@ -311,9 +286,8 @@ func (u *OpnsenseUnbound) addOverride(client *http.Client, hostname, domain, ip
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
-	fmt.Printf("addHostOverride response: %s\n", string(body))
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return statusError(resp.StatusCode, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body)))
 	}
 
 	var apiResp opnsenseResponse
@@ -328,10 +302,10 @@ func (u *OpnsenseUnbound) addOverride(client *http.Client, hostname, domain, ip
 	return nil
 }
 
-func (u *OpnsenseUnbound) deleteOverride(client *http.Client, uuid string) error {
+func (u *OpnsenseUnbound) deleteOverride(ctx context.Context, client *http.Client, uuid string) error {
 	url := fmt.Sprintf("%s/api/unbound/settings/delHostOverride/%s", strings.TrimRight(u.Address, "/"), uuid)
 
-	req, err := http.NewRequest("POST", url, bytes.NewBufferString("{}"))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBufferString("{}"))
 	if err != nil {
 		return err
 	}
@@ -345,9 +319,8 @@ func (u *OpnsenseUnbound) deleteOverride(client *http.Client, uuid string) error
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
-	fmt.Printf("delHostOverride response: %s\n", string(body))
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return statusError(resp.StatusCode, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body)))
 	}
 
 	var apiResp opnsenseResponse
@@ -362,10 +335,10 @@ func (u *OpnsenseUnbound) deleteOverride(client *http.Client, uuid string) error
 	return nil
 }
 
-func (u *OpnsenseUnbound) reconfigure(client *http.Client) error {
+func (u *OpnsenseUnbound) reconfigure(ctx context.Context, client *http.Client) error {
 	url := fmt.Sprintf("%s/api/unbound/service/reconfigure", strings.TrimRight(u.Address, "/"))
 
-	req, err := http.NewRequest("POST", url, bytes.NewBufferString("{}"))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBufferString("{}"))
 	if err != nil {
 		return err
 	}
@@ -380,11 +353,9 @@ func (u *OpnsenseUnbound) reconfigure(client *http.Client) error {
 
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("reconfigure failed with status %d: %s", resp.StatusCode, string(body))
+		return statusError(resp.StatusCode, fmt.Errorf("reconfigure failed with status %d: %s", resp.StatusCode, string(body)))
 	}
 
-	fmt.Printf("reconfigure response: %s\n", string(body))
-
 	var apiResp opnsenseResponse
 	if err := json.Unmarshal(body, &apiResp); err == nil {
 		if apiResp.Result != "saved" && apiResp.Result != "deleted" && apiResp.Status != "ok" {
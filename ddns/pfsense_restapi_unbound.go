@@ -2,6 +2,7 @@ package ddns
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
@@ -10,12 +11,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/miguelangel-nubla/ipv6disc"
-	"github.com/xeipuuv/gojsonschema"
 )
 
 type PfsenseRestapiUnbound struct {
@@ -30,14 +30,18 @@ func init() {
 	RegisterProvider("pfsense_restapi_unbound", NewPfsenseRestapiUnbound)
 }
 
-func NewPfsenseRestapiUnbound(settings ProviderSettings) Service {
+func NewPfsenseRestapiUnbound(settings ProviderSettings) (Service, error) {
 	var service PfsenseRestapiUnbound
-	pfsenseRestapiUnboundValidateConfig(settings.(json.RawMessage))
-	json.Unmarshal(settings.(json.RawMessage), &service)
-	return &service
+	if err := pfsenseRestapiUnboundValidateConfig(settings.(json.RawMessage)); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(settings.(json.RawMessage), &service); err != nil {
+		return nil, err
+	}
+	return &service, nil
 }
 
-func pfsenseRestapiUnboundValidateConfig(config json.RawMessage) {
+func pfsenseRestapiUnboundValidateConfig(config json.RawMessage) error {
 	var configSchema = []byte(`
 	{
 		"$schema": "http://json-schema.org/draft-07/schema#",
@@ -73,21 +77,7 @@ func pfsenseRestapiUnboundValidateConfig(config json.RawMessage) {
 	}
 	`)
 
-	schemaLoader := gojsonschema.NewBytesLoader(configSchema)
-	dataLoader := gojsonschema.NewBytesLoader([]byte(config))
-
-	result, err := gojsonschema.Validate(schemaLoader, dataLoader)
-	if err != nil {
-		panic(err.Error())
-	}
-
-	if !result.Valid() {
-		fmt.Printf("PfsenseRestapiUnbound configuration is not valid.\nErrors:\n")
-		for _, desc := range result.Errors() {
-			fmt.Printf("- %s\n", desc)
-		}
-		os.Exit(1)
-	}
+	return validateSettings("PfsenseRestapiUnbound", configSchema, config)
 }
 
 func (u *PfsenseRestapiUnbound) setupClient() *http.Client {
@@ -119,7 +109,7 @@ func (u *PfsenseRestapiUnbound) setupClient() *http.Client {
 			if fp == u.TLSFingerprint {
 				return nil
 			}
-			fmt.Printf("Certificate fingerprint mismatch. Expected: %s, Found: %s\n", u.TLSFingerprint, fp)
+			return fmt.Errorf("certificate verification failed: %w (Fingerprint: %s, expected: %s)", err, fp, u.TLSFingerprint)
 		}
 
 		return fmt.Errorf("certificate verification failed: %w (Fingerprint: %s)", err, fp)
@@ -133,104 +123,101 @@ func (u *PfsenseRestapiUnbound) setupClient() *http.Client {
 	}
 }
 
-func (u *PfsenseRestapiUnbound) Update(hostname string, addrCollection *ipv6disc.AddrCollection) error {
-	client := u.setupClient()
-
+func (u *PfsenseRestapiUnbound) Update(ctx context.Context, hostname string, addrCollection *ipv6disc.AddrCollection) ([]Change, error) {
 	// Note: pfSense does not support wildcard DNS entries (e.g., *.example.com)
 	// Validate that the hostname does not contain wildcards
 	fqdn := FQDN(hostname, u.Zone)
 	if strings.Contains(fqdn, "*") {
-		return fmt.Errorf("pfSense does not support wildcard DNS entries: %s", fqdn)
-	}
-
-	// 1. Fetch existing Host Overrides
-	existingOverrides, err := u.getOverrides(client)
-	if err != nil {
-		return fmt.Errorf("failed to fetch existing Host Overrides: %v", err)
-	}
-
-	// 2. Identify the relevant existing override for this hostname
-	desiredIPs := make(map[string]bool)
-	for _, addr := range addrCollection.Get() {
-		ip := addr.WithZone("").String()
-		desiredIPs[ip] = true
+		return nil, Permanent(fmt.Errorf("pfSense does not support wildcard DNS entries: %s", fqdn))
 	}
 
 	hostPart, domainPart := SplitFQDN(fqdn)
+	records := &pfsenseRestapiRecords{
+		PfsenseRestapiUnbound: u,
+		client:                u.setupClient(),
+		fqdn:                  fqdn,
+		host:                  hostPart,
+		domain:                domainPart,
+	}
+	return Reconcile(ctx, records, addrCollection, u.TTL)
+}
 
-	// Look for an existing override matching host and domain.
-	// pfSense enforces uniqueness for this combination.
-	var existingRecord *pfsenseRestapiOverrideRow
-	for i := range existingOverrides {
-		if existingOverrides[i].Host == hostPart && existingOverrides[i].Domain == domainPart {
-			existingRecord = &existingOverrides[i]
-			break
-		}
-	}
+// pfsenseRestapiRecords is the RecordSet of a hostname in the Unbound Host
+// Overrides. pfSense keeps every address of a host in a single override, so
+// the changes are staged and the override is written on Commit, which then
+// applies the Unbound changes.
+type pfsenseRestapiRecords struct {
+	*PfsenseRestapiUnbound
+	client *http.Client
+	fqdn   string
+	host   string
+	domain string
+	// existing is the override of the hostname as listed, nil if there is none
+	existing *pfsenseRestapiOverrideRow
+	ips      []string
+}
 
-	// 3. Manage IPs
-	desiredIPSlice := make([]string, 0, len(desiredIPs))
-	for ip := range desiredIPs {
-		desiredIPSlice = append(desiredIPSlice, ip)
+func (r *pfsenseRestapiRecords) List(ctx context.Context) ([]Record, error) {
+	existingOverrides, err := r.getOverrides(ctx, r.client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch existing Host Overrides: %w", err)
 	}
 
-	// Helper to compare IP slices (insensitive to order)
-	ipsMatch := func(a, b []string) bool {
-		if len(a) != len(b) {
-			return false
-		}
-		m := make(map[string]bool)
-		for _, x := range a {
-			m[x] = true
+	// pfSense enforces the uniqueness of host and domain
+	var records []Record
+	for i, row := range existingOverrides {
+		if row.Host != r.host || row.Domain != r.domain {
+			continue
 		}
-		for _, x := range b {
-			if !m[x] {
-				return false
-			}
+		r.existing = &existingOverrides[i]
+		r.ips = slices.Clone(row.IP)
+		for _, ip := range row.IP {
+			records = append(records, Record{Type: recordType(ip), Value: ip})
 		}
-		return true
+		break
 	}
+	return records, nil
+}
 
-	changesMade := false
+func (r *pfsenseRestapiRecords) Create(ctx context.Context, record Record) error {
+	r.ips = append(r.ips, record.Value)
+	return nil
+}
 
-	if existingRecord == nil {
-		// No existing record, add New
-		if len(desiredIPSlice) > 0 {
-			err := u.addOverride(client, hostPart, domainPart, desiredIPSlice)
-			if err != nil {
-				return fmt.Errorf("failed to add override %s: %v", fqdn, err)
-			}
-			changesMade = true
-		}
-	} else {
-		// Record exists, update or delete
-		idStr := fmt.Sprintf("%v", existingRecord.ID)
-		if !ipsMatch(existingRecord.IP, desiredIPSlice) {
-			if len(desiredIPSlice) > 0 {
-				err := u.updateOverride(client, idStr, hostPart, domainPart, desiredIPSlice)
-				if err != nil {
-					return fmt.Errorf("failed to update override %s (ID: %s): %v", fqdn, idStr, err)
-				}
-				changesMade = true
-			} else {
-				// No IPs desired anymore, delete
-				err := u.deleteOverride(client, idStr)
-				if err != nil {
-					fmt.Printf("Warning: failed to delete override %s (ID: %s): %v\n", fqdn, idStr, err)
-				} else {
-					changesMade = true
-				}
-			}
-		}
+func (r *pfsenseRestapiRecords) Delete(ctx context.Context, record Record) error {
+	// the first matching address only, duplicates are deleted one at a time
+	if i := slices.Index(r.ips, record.Value); i >= 0 {
+		r.ips = slices.Delete(r.ips, i, i+1)
 	}
+	return nil
+}
+
+// UpdateTTL is never called, the Host Overrides listed carry no TTL.
+func (r *pfsenseRestapiRecords) UpdateTTL(ctx context.Context, record Record) error {
+	return nil
+}
 
-	// 4. Apply Changes if made
-	if changesMade {
-		if err := u.applyChanges(client); err != nil {
-			return fmt.Errorf("failed to apply Unbound changes: %v", err)
+func (r *pfsenseRestapiRecords) Commit(ctx context.Context) error {
+	switch {
+	case r.existing == nil:
+		if err := r.addOverride(ctx, r.client, r.host, r.domain, r.ips); err != nil {
+			return fmt.Errorf("failed to add override %s: %w", r.fqdn, err)
+		}
+	case len(r.ips) == 0:
+		id := fmt.Sprintf("%v", r.existing.ID)
+		if err := r.deleteOverride(ctx, r.client, id); err != nil {
+			return fmt.Errorf("failed to delete override %s (ID: %s): %w", r.fqdn, id, err)
+		}
+	default:
+		id := fmt.Sprintf("%v", r.existing.ID)
+		if err := r.updateOverride(ctx, r.client, id, r.host, r.domain, r.ips); err != nil {
+			return fmt.Errorf("failed to update override %s (ID: %s): %w", r.fqdn, id, err)
 		}
 	}
 
+	if err := r.applyChanges(ctx, r.client); err != nil {
+		return fmt.Errorf("failed to apply Unbound changes: %w", err)
+	}
 	return nil
 }
 
@@ -250,9 +237,9 @@ type pfsenseRestapiResponse struct {
 	Data    json.RawMessage `json:"data"`
 }
 
-func (u *PfsenseRestapiUnbound) getOverrides(client *http.Client) ([]pfsenseRestapiOverrideRow, error) {
+func (u *PfsenseRestapiUnbound) getOverrides(ctx context.Context, client *http.Client) ([]pfsenseRestapiOverrideRow, error) {
 	url := fmt.Sprintf("%s/api/v2/services/dns_resolver/host_overrides", strings.TrimRight(u.Address, "/"))
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -266,7 +253,7 @@ func (u *PfsenseRestapiUnbound) getOverrides(client *http.Client) ([]pfsenseRest
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, statusError(resp.StatusCode, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body)))
 	}
 
 	var apiResp pfsenseRestapiResponse
@@ -286,7 +273,7 @@ func (u *PfsenseRestapiUnbound) getOverrides(client *http.Client) ([]pfsenseRest
 	return rows, nil
 }
 
-func (u *PfsenseRestapiUnbound) addOverride(client *http.Client, host, domain string, ips []string) error {
+func (u *PfsenseRestapiUnbound) addOverride(ctx context.Context, client *http.Client, host, domain string, ips []string) error {
 	url := fmt.Sprintf("%s/api/v2/services/dns_resolver/host_override", strings.TrimRight(u.Address, "/"))
 
 	payload := map[string]interface{}{
@@ -302,7 +289,7 @@ func (u *PfsenseRestapiUnbound) addOverride(client *http.Client, host, domain st
 		return err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(data))
 	if err != nil {
 		return err
 	}
@@ -317,7 +304,7 @@ func (u *PfsenseRestapiUnbound) addOverride(client *http.Client, host, domain st
 
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return statusError(resp.StatusCode, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body)))
 	}
 
 	var apiResp pfsenseRestapiResponse
@@ -335,7 +322,7 @@ func (u *PfsenseRestapiUnbound) addOverride(client *http.Client, host, domain st
 	return nil
 }
 
-func (u *PfsenseRestapiUnbound) updateOverride(client *http.Client, id, host, domain string, ips []string) error {
+func (u *PfsenseRestapiUnbound) updateOverride(ctx context.Context, client *http.Client, id, host, domain string, ips []string) error {
 	url := fmt.Sprintf("%s/api/v2/services/dns_resolver/host_override", strings.TrimRight(u.Address, "/"))
 
 	payload := map[string]interface{}{
@@ -352,7 +339,7 @@ func (u *PfsenseRestapiUnbound) updateOverride(client *http.Client, id, host, do
 		return err
 	}
 
-	req, err := http.NewRequest("PATCH", url, bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewBuffer(data))
 	if err != nil {
 		return err
 	}
@@ -367,7 +354,7 @@ func (u *PfsenseRestapiUnbound) updateOverride(client *http.Client, id, host, do
 
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return statusError(resp.StatusCode, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body)))
 	}
 
 	var apiResp pfsenseRestapiResponse
@@ -382,10 +369,10 @@ func (u *PfsenseRestapiUnbound) updateOverride(client *http.Client, id, host, do
 	return nil
 }
 
-func (u *PfsenseRestapiUnbound) deleteOverride(client *http.Client, id string) error {
+func (u *PfsenseRestapiUnbound) deleteOverride(ctx context.Context, client *http.Client, id string) error {
 	url := fmt.Sprintf("%s/api/v2/services/dns_resolver/host_override?id=%s", strings.TrimRight(u.Address, "/"), id)
 
-	req, err := http.NewRequest("DELETE", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
 	if err != nil {
 		return err
 	}
@@ -399,7 +386,7 @@ func (u *PfsenseRestapiUnbound) deleteOverride(client *http.Client, id string) e
 
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return statusError(resp.StatusCode, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body)))
 	}
 
 	var apiResp pfsenseRestapiResponse
@@ -414,10 +401,10 @@ func (u *PfsenseRestapiUnbound) deleteOverride(client *http.Client, id string) e
 	return nil
 }
 
-func (u *PfsenseRestapiUnbound) applyChanges(client *http.Client) error {
+func (u *PfsenseRestapiUnbound) applyChanges(ctx context.Context, client *http.Client) error {
 	url := fmt.Sprintf("%s/api/v2/services/dns_resolver/apply", strings.TrimRight(u.Address, "/"))
 
-	req, err := http.NewRequest("POST", url, bytes.NewBufferString("{}"))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBufferString("{}"))
 	if err != nil {
 		return err
 	}
@@ -432,7 +419,7 @@ func (u *PfsenseRestapiUnbound) applyChanges(client *http.Client) error {
 
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return statusError(resp.StatusCode, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body)))
 	}
 
 	var apiResp pfsenseRestapiResponse
@@ -0,0 +1,113 @@
+package ddns
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6disc"
+)
+
+// Record is an A or AAAA record published at the provider.
+type Record struct {
+	// ID identifies the record at the provider, empty if it has none.
+	ID    string
+	Type  string
+	Value string
+	// TTL is zero when the provider does not report it.
+	TTL time.Duration
+	// Stale is set by List when provider specific settings of the record,
+	// like proxying, differ from the configured ones.
+	Stale bool
+}
+
+// RecordSet gives access to the A and AAAA records of a single hostname.
+// Providers managing individual records implement it and leave the rest to Reconcile.
+type RecordSet interface {
+	List(ctx context.Context) ([]Record, error)
+	Create(ctx context.Context, record Record) error
+	Delete(ctx context.Context, record Record) error
+	// UpdateTTL sets the TTL and the provider specific settings of an existing record.
+	UpdateTTL(ctx context.Context, record Record) error
+}
+
+// Committer is implemented by the record sets that stage their changes until committed.
+type Committer interface {
+	Commit(ctx context.Context) error
+}
+
+// Reconcile makes the records of a hostname match the addresses, with the
+// given TTL unless zero. Missing records are created before the obsolete and
+// duplicated ones are deleted, so the hostname does not go unresolved while it
+// changes. On dry runs the changes are only computed, and no record is deleted
+// while deletes are held.
+func Reconcile(ctx context.Context, records RecordSet, addrCollection *ipv6disc.AddrCollection, ttl time.Duration) ([]Change, error) {
+	current, err := records.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var desired []string
+	for _, addr := range addrCollection.Get() {
+		ip := addr.WithZone("").String()
+		if !slices.Contains(desired, ip) {
+			desired = append(desired, ip)
+		}
+	}
+
+	var updates, deletes []step
+	existing := make(map[string]bool)
+	for _, record := range current {
+		addr, err := netip.ParseAddr(record.Value)
+		if err != nil || (record.Type != "A" && record.Type != "AAAA") {
+			continue
+		}
+		record.Value = addr.WithZone("").String()
+
+		duplicate := existing[record.Value]
+		existing[record.Value] = true
+
+		switch {
+		case duplicate || !slices.Contains(desired, record.Value):
+			deletes = append(deletes, step{Change{Action: ChangeDelete, Type: record.Type, Value: record.Value}, func() error {
+				return records.Delete(ctx, record)
+			}})
+		case record.Stale || (ttl > 0 && record.TTL > 0 && record.TTL != ttl):
+			if ttl > 0 {
+				record.TTL = ttl
+			}
+			updates = append(updates, step{Change{Action: ChangeUpdate, Type: record.Type, Value: record.Value}, func() error {
+				return records.UpdateTTL(ctx, record)
+			}})
+		}
+	}
+
+	if DeletesHeld(ctx) {
+		deletes = nil
+	}
+
+	var creates []step
+	for _, ip := range desired {
+		if existing[ip] {
+			continue
+		}
+		record := Record{Type: recordType(ip), Value: ip, TTL: ttl}
+		creates = append(creates, step{Change{Action: ChangeCreate, Type: record.Type, Value: ip}, func() error {
+			return records.Create(ctx, record)
+		}})
+	}
+
+	changes, err := applySteps(ctx, slices.Concat(creates, updates, deletes))
+	if err != nil || len(changes) == 0 || IsDryRun(ctx) {
+		return changes, err
+	}
+
+	if committer, ok := records.(Committer); ok {
+		if err := committer.Commit(ctx); err != nil {
+			return changes, err
+		}
+	}
+
+	return changes, nil
+}
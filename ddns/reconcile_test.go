@@ -0,0 +1,147 @@
+package ddns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6disc"
+)
+
+type fakeRecords struct {
+	records   []Record
+	calls     []string
+	committed bool
+}
+
+func (f *fakeRecords) List(ctx context.Context) ([]Record, error) {
+	return f.records, nil
+}
+
+func (f *fakeRecords) Create(ctx context.Context, record Record) error {
+	f.calls = append(f.calls, "create "+record.Value)
+	return nil
+}
+
+func (f *fakeRecords) Delete(ctx context.Context, record Record) error {
+	f.calls = append(f.calls, "delete "+record.ID)
+	return nil
+}
+
+func (f *fakeRecords) UpdateTTL(ctx context.Context, record Record) error {
+	f.calls = append(f.calls, "update "+record.ID+" "+record.TTL.String())
+	return nil
+}
+
+func (f *fakeRecords) Commit(ctx context.Context) error {
+	f.committed = true
+	return nil
+}
+
+func testAddrCollection(ips ...string) *ipv6disc.AddrCollection {
+	addrCollection := ipv6disc.NewAddrCollection()
+	for _, ip := range ips {
+		addrCollection.Add(ipv6disc.NewAddr(net.HardwareAddr{0, 0, 0, 0, 0, 1}, netip.MustParseAddr(ip), "test", time.Hour, nil))
+	}
+	return addrCollection
+}
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name      string
+		records   []Record
+		desired   []string
+		ttl       time.Duration
+		dryRun    bool
+		held      bool
+		wantCalls []string
+		want      []Change
+	}{
+		{
+			name:    "up to date",
+			records: []Record{{ID: "1", Type: "AAAA", Value: "2001:db8::1", TTL: time.Minute}},
+			desired: []string{"2001:db8::1"},
+			ttl:     time.Minute,
+		},
+		{
+			name:      "create before delete",
+			records:   []Record{{ID: "1", Type: "AAAA", Value: "2001:db8::1"}},
+			desired:   []string{"2001:db8::2"},
+			wantCalls: []string{"create 2001:db8::2", "delete 1"},
+			want: []Change{
+				{Action: ChangeCreate, Type: "AAAA", Value: "2001:db8::2"},
+				{Action: ChangeDelete, Type: "AAAA", Value: "2001:db8::1"},
+			},
+		},
+		{
+			name: "duplicates and other types",
+			records: []Record{
+				{ID: "1", Type: "A", Value: "192.0.2.1"},
+				{ID: "2", Type: "A", Value: "192.0.2.1"},
+				{ID: "3", Type: "MX", Value: "mail.example.com"},
+			},
+			desired:   []string{"192.0.2.1"},
+			wantCalls: []string{"delete 2"},
+			want:      []Change{{Action: ChangeDelete, Type: "A", Value: "192.0.2.1"}},
+		},
+		{
+			name: "ttl drift",
+			records: []Record{
+				{ID: "1", Type: "AAAA", Value: "2001:db8::1", TTL: time.Hour},
+				{ID: "2", Type: "AAAA", Value: "2001:db8::2"},
+				{ID: "3", Type: "AAAA", Value: "2001:db8::3", TTL: time.Minute, Stale: true},
+			},
+			desired:   []string{"2001:db8::1", "2001:db8::2", "2001:db8::3"},
+			ttl:       time.Minute,
+			wantCalls: []string{"update 1 1m0s", "update 3 1m0s"},
+			want: []Change{
+				{Action: ChangeUpdate, Type: "AAAA", Value: "2001:db8::1"},
+				{Action: ChangeUpdate, Type: "AAAA", Value: "2001:db8::3"},
+			},
+		},
+		{
+			name:    "dry run",
+			records: []Record{{ID: "1", Type: "AAAA", Value: "2001:db8::1"}},
+			dryRun:  true,
+			want:    []Change{{Action: ChangeDelete, Type: "AAAA", Value: "2001:db8::1"}},
+		},
+		{
+			name:      "deletes held",
+			records:   []Record{{ID: "1", Type: "AAAA", Value: "2001:db8::1"}},
+			desired:   []string{"2001:db8::2"},
+			held:      true,
+			wantCalls: []string{"create 2001:db8::2"},
+			want:      []Change{{Action: ChangeCreate, Type: "AAAA", Value: "2001:db8::2"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := &fakeRecords{records: tt.records}
+			ctx := context.Background()
+			if tt.dryRun {
+				ctx = WithDryRun(ctx)
+			}
+			if tt.held {
+				ctx = WithDeletesHeld(ctx)
+			}
+
+			changes, err := Reconcile(ctx, records, testAddrCollection(tt.desired...), tt.ttl)
+			if err != nil {
+				t.Fatalf("Reconcile failed: %v", err)
+			}
+			if !reflect.DeepEqual(changes, tt.want) {
+				t.Errorf("changes = %v, want %v", changes, tt.want)
+			}
+			if !reflect.DeepEqual(records.calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", records.calls, tt.wantCalls)
+			}
+			if records.committed != (len(tt.wantCalls) > 0) {
+				t.Errorf("committed = %v, want %v", records.committed, len(tt.wantCalls) > 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package ddns
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6disc"
+)
+
+// ReverseService is implemented by the services able to publish PTR records
+// in the reverse zones they host.
+type ReverseService interface {
+	// UpdateReverse makes the PTR records of zone pointing to hostname match
+	// the addresses, all within the zone, and returns the changes it had to make.
+	// Previous are the addresses last published, for the providers that can't
+	// search the records by the hostname they point to.
+	UpdateReverse(ctx context.Context, zone string, hostname string, addresses *ipv6disc.AddrCollection, previous *ipv6disc.AddrCollection) ([]Change, error)
+}
+
+// PTRRecord is a PTR record of a reverse zone pointing to a hostname.
+type PTRRecord struct {
+	// ID identifies the record at the provider, empty if it has none.
+	ID string
+	// Name is the reverse name of the address, as in 1.2.0.192.in-addr.arpa
+	Name string
+	TTL  time.Duration
+}
+
+// PTRSet gives access to the PTR records of a reverse zone pointing to a
+// single hostname. Implementations may stage their changes until committed,
+// see Committer.
+type PTRSet interface {
+	List(ctx context.Context) ([]PTRRecord, error)
+	Create(ctx context.Context, record PTRRecord) error
+	Delete(ctx context.Context, record PTRRecord) error
+}
+
+// UpdateReverse publishes the PTR records of the addresses in the reverse
+// zone containing each of them, through a service implementing ReverseService.
+// Addresses outside every zone are left alone. Previous are the addresses last
+// published, nil if unknown.
+func UpdateReverse(ctx context.Context, service Service, zones []string, hostname string, addrCollection *ipv6disc.AddrCollection, previous *ipv6disc.AddrCollection) ([]Change, error) {
+	if len(zones) == 0 {
+		return nil, nil
+	}
+	reverse, ok := service.(ReverseService)
+	if !ok {
+		return nil, Permanent(fmt.Errorf("the provider can't manage reverse zones"))
+	}
+
+	var changes []Change
+	for _, zone := range zones {
+		zoneChanges, err := reverse.UpdateReverse(ctx, zone, hostname, inReverseZone(addrCollection, zone), inReverseZone(previous, zone))
+		changes = append(changes, zoneChanges...)
+		if err != nil {
+			return changes, fmt.Errorf("reverse zone %s: %w", zone, err)
+		}
+	}
+
+	return changes, nil
+}
+
+// inReverseZone returns the addresses whose PTR records belong to zone.
+func inReverseZone(addrCollection *ipv6disc.AddrCollection, zone string) *ipv6disc.AddrCollection {
+	inZone := ipv6disc.NewAddrCollection()
+	if addrCollection == nil {
+		return inZone
+	}
+	for _, addr := range addrCollection.Get() {
+		if InReverseZone(addr.Addr, zone) {
+			inZone.Add(addr)
+		}
+	}
+	return inZone
+}
+
+// ReconcileReverse makes the PTR records pointing to a hostname match the
+// addresses, with the given TTL. As Reconcile, missing records are created
+// before the obsolete ones are deleted, on dry runs they are only computed and
+// no record is deleted while deletes are held.
+func ReconcileReverse(ctx context.Context, records PTRSet, addrCollection *ipv6disc.AddrCollection, ttl time.Duration) ([]Change, error) {
+	current, err := records.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var desired []string
+	for _, addr := range addrCollection.Get() {
+		name := ReverseName(addr.Addr)
+		if !slices.Contains(desired, name) {
+			desired = append(desired, name)
+		}
+	}
+
+	var deletes []step
+	existing := make(map[string]bool)
+	for _, record := range current {
+		record.Name = strings.ToLower(strings.TrimSuffix(record.Name, "."))
+
+		duplicate := existing[record.Name]
+		existing[record.Name] = true
+
+		if duplicate || !slices.Contains(desired, record.Name) {
+			deletes = append(deletes, step{Change{Action: ChangeDelete, Type: "PTR", Value: record.Name}, func() error {
+				return records.Delete(ctx, record)
+			}})
+		}
+	}
+
+	if DeletesHeld(ctx) {
+		deletes = nil
+	}
+
+	var creates []step
+	for _, name := range desired {
+		if existing[name] {
+			continue
+		}
+		record := PTRRecord{Name: name, TTL: ttl}
+		creates = append(creates, step{Change{Action: ChangeCreate, Type: "PTR", Value: name}, func() error {
+			return records.Create(ctx, record)
+		}})
+	}
+
+	changes, err := applySteps(ctx, slices.Concat(creates, deletes))
+	if err != nil || len(changes) == 0 || IsDryRun(ctx) {
+		return changes, err
+	}
+
+	if committer, ok := records.(Committer); ok {
+		if err := committer.Commit(ctx); err != nil {
+			return changes, err
+		}
+	}
+
+	return changes, nil
+}
+
+// ReverseName returns the name of the PTR record of an address, without the trailing dot.
+func ReverseName(addr netip.Addr) string {
+	addr = addr.Unmap().WithZone("")
+
+	var labels []string
+	if addr.Is4() {
+		ip := addr.As4()
+		for i := len(ip) - 1; i >= 0; i-- {
+			labels = append(labels, strconv.Itoa(int(ip[i])))
+		}
+		return strings.Join(labels, ".") + ".in-addr.arpa"
+	}
+
+	ip := addr.As16()
+	for i := len(ip) - 1; i >= 0; i-- {
+		labels = append(labels, strconv.FormatUint(uint64(ip[i]&0x0f), 16), strconv.FormatUint(uint64(ip[i]>>4), 16))
+	}
+	return strings.Join(labels, ".") + ".ip6.arpa"
+}
+
+// InReverseZone reports whether the PTR record of an address belongs to zone.
+func InReverseZone(addr netip.Addr, zone string) bool {
+	zone = strings.ToLower(strings.Trim(zone, "."))
+	name := ReverseName(addr)
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
@@ -0,0 +1,93 @@
+package ddns
+
+import (
+	"context"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakePTRs struct {
+	records []PTRRecord
+	calls   []string
+}
+
+func (f *fakePTRs) List(ctx context.Context) ([]PTRRecord, error) {
+	return f.records, nil
+}
+
+func (f *fakePTRs) Create(ctx context.Context, record PTRRecord) error {
+	f.calls = append(f.calls, "create "+record.Name)
+	return nil
+}
+
+func (f *fakePTRs) Delete(ctx context.Context, record PTRRecord) error {
+	f.calls = append(f.calls, "delete "+record.Name)
+	return nil
+}
+
+func TestReverseName(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+		zone string
+	}{
+		{"192.0.2.10", "10.2.0.192.in-addr.arpa", "2.0.192.in-addr.arpa"},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa", "8.B.D.0.1.0.0.2.ip6.arpa."},
+	}
+
+	for _, tt := range tests {
+		addr := netip.MustParseAddr(tt.addr)
+		if got := ReverseName(addr); got != tt.want {
+			t.Errorf("ReverseName(%s) = %s, want %s", tt.addr, got, tt.want)
+		}
+		if !InReverseZone(addr, tt.zone) {
+			t.Errorf("expected %s in reverse zone %s", tt.addr, tt.zone)
+		}
+		if InReverseZone(addr, "9.b.d.0.1.0.0.2.ip6.arpa") {
+			t.Errorf("expected %s outside reverse zone 9.b.d.0.1.0.0.2.ip6.arpa", tt.addr)
+		}
+	}
+}
+
+func TestReconcileReverse(t *testing.T) {
+	records := &fakePTRs{records: []PTRRecord{
+		{Name: "1.2.0.192.in-addr.arpa."},
+		{Name: "2.2.0.192.in-addr.arpa"},
+	}}
+
+	changes, err := ReconcileReverse(context.Background(), records, testAddrCollection("192.0.2.1", "192.0.2.3"), time.Minute)
+	if err != nil {
+		t.Fatalf("ReconcileReverse failed: %v", err)
+	}
+
+	want := []Change{
+		{Action: ChangeCreate, Type: "PTR", Value: "3.2.0.192.in-addr.arpa"},
+		{Action: ChangeDelete, Type: "PTR", Value: "2.2.0.192.in-addr.arpa"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+	wantCalls := []string{"create 3.2.0.192.in-addr.arpa", "delete 2.2.0.192.in-addr.arpa"}
+	if !reflect.DeepEqual(records.calls, wantCalls) {
+		t.Errorf("calls = %v, want %v", records.calls, wantCalls)
+	}
+}
+
+func TestReconcileReverseDeletesHeld(t *testing.T) {
+	records := &fakePTRs{records: []PTRRecord{{Name: "2.2.0.192.in-addr.arpa"}}}
+
+	changes, err := ReconcileReverse(WithDeletesHeld(context.Background()), records, testAddrCollection("192.0.2.1"), time.Minute)
+	if err != nil {
+		t.Fatalf("ReconcileReverse failed: %v", err)
+	}
+
+	want := []Change{{Action: ChangeCreate, Type: "PTR", Value: "1.2.0.192.in-addr.arpa"}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+	if wantCalls := []string{"create 1.2.0.192.in-addr.arpa"}; !reflect.DeepEqual(records.calls, wantCalls) {
+		t.Errorf("calls = %v, want %v", records.calls, wantCalls)
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -14,8 +14,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/route53"
 	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/aws/smithy-go"
 	"github.com/miguelangel-nubla/ipv6disc"
-	"github.com/xeipuuv/gojsonschema"
 )
 
 type Route53 struct {
@@ -31,19 +31,27 @@ func init() {
 	RegisterProvider("route53", NewRoute53)
 }
 
-func NewRoute53(settings ProviderSettings) Service {
+// route53ZoneTimeout bounds the hosted zone lookup of NewRoute53, which runs
+// while the configuration is loaded or reloaded and outside of any update.
+const route53ZoneTimeout = 30 * time.Second
+
+func NewRoute53(settings ProviderSettings) (Service, error) {
 	var service Route53
-	route53ValidateConfig(settings.(json.RawMessage))
-	json.Unmarshal(settings.(json.RawMessage), &service)
+	if err := route53ValidateConfig(settings.(json.RawMessage)); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(settings.(json.RawMessage), &service); err != nil {
+		return nil, err
+	}
 
-	ctx := context.TODO()
+	ctx, cancel := context.WithTimeout(context.Background(), route53ZoneTimeout)
+	defer cancel()
 	cfg, err := config.LoadDefaultConfig(ctx,
 		config.WithRegion(service.Region),
 		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(service.AccessKeyID, service.SecretAccessKey, "")),
 	)
 	if err != nil {
-		fmt.Printf("Error loading AWS config: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("error loading AWS config: %v", err)
 	}
 
 	client := route53.NewFromConfig(cfg)
@@ -51,16 +59,15 @@ func NewRoute53(settings ProviderSettings) Service {
 		Id: aws.String(service.HostedZoneID),
 	})
 	if err != nil {
-		fmt.Printf("Error fetching Hosted Zone info for ID %s: %v\n", service.HostedZoneID, err)
-		os.Exit(1)
+		return nil, fmt.Errorf("error fetching Hosted Zone info for ID %s: %v", service.HostedZoneID, err)
 	}
 
 	service.zone = aws.ToString(out.HostedZone.Name)
 
-	return &service
+	return &service, nil
 }
 
-func route53ValidateConfig(config json.RawMessage) {
+func route53ValidateConfig(config json.RawMessage) error {
 	var configSchema = []byte(`
 	{
 		"$schema": "http://json-schema.org/draft-07/schema#",
@@ -97,35 +104,27 @@ func route53ValidateConfig(config json.RawMessage) {
 	}
 	`)
 
-	schemaLoader := gojsonschema.NewBytesLoader(configSchema)
-	dataLoader := gojsonschema.NewBytesLoader([]byte(config))
-
-	result, err := gojsonschema.Validate(schemaLoader, dataLoader)
-	if err != nil {
-		panic(err.Error())
-	}
-
-	if !result.Valid() {
-		fmt.Printf("Route53 configuration is not valid.\nErrors:\n")
-		for _, desc := range result.Errors() {
-			fmt.Printf("- %s\n", desc)
-		}
-		os.Exit(1)
-	}
+	return validateSettings("Route53", configSchema, config)
 }
 
-func (r *Route53) Update(hostname string, addrCollection *ipv6disc.AddrCollection) error {
-	ctx := context.Background()
-
+// client returns a Route53 client with the configured credentials.
+func (r *Route53) client(ctx context.Context) (*route53.Client, error) {
 	cfg, err := config.LoadDefaultConfig(ctx,
 		config.WithRegion(r.Region),
 		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(r.AccessKeyID, r.SecretAccessKey, "")),
 	)
 	if err != nil {
-		return fmt.Errorf("unable to load SDK config, %v", err)
+		return nil, fmt.Errorf("unable to load SDK config, %v", err)
 	}
 
-	client := route53.NewFromConfig(cfg)
+	return route53.NewFromConfig(cfg), nil
+}
+
+func (r *Route53) Update(ctx context.Context, hostname string, addrCollection *ipv6disc.AddrCollection) ([]Change, error) {
+	client, err := r.client(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	// Route53 expects trailing dot for FQDNs
 	dnsName := FQDN(hostname, r.zone)
@@ -133,105 +132,271 @@ func (r *Route53) Update(hostname string, addrCollection *ipv6disc.AddrCollectio
 		dnsName += "."
 	}
 
-	// Separate desired IPs by type
-	desiredA := []string{}
-	desiredAAAA := []string{}
-	for _, addr := range addrCollection.Get() {
-		ip := addr.WithZone("").String()
-		if addr.Addr.Is4() {
-			desiredA = append(desiredA, ip)
-		} else {
-			desiredAAAA = append(desiredAAAA, ip)
-		}
+	records := &route53Records{
+		client:       client,
+		hostedZoneID: r.HostedZoneID,
+		name:         dnsName,
+		sets:         make(map[types.RRType]types.ResourceRecordSet),
+		values:       make(map[types.RRType][]string),
+		ttl:          r.TTL,
 	}
+	return Reconcile(ctx, records, addrCollection, r.TTL)
+}
 
-	changes := []types.Change{}
+// route53Records is the RecordSet of a hostname in a Route53 hosted zone.
+// Route53 keeps the addresses of each type in a single record set, so the
+// changes are staged and sent in a single batch on Commit.
+type route53Records struct {
+	client       *route53.Client
+	hostedZoneID string
+	name         string
+	// sets are the A and AAAA record sets of the hostname, as listed
+	sets map[types.RRType]types.ResourceRecordSet
+	// values are the addresses of each record set once the changes are applied
+	values map[types.RRType][]string
+	// ttl is the one configured, the record sets are written with it
+	ttl time.Duration
+}
+
+func (r *route53Records) List(ctx context.Context) ([]Record, error) {
+	// record sets are sorted by name and type, A and AAAA come first
+	output, err := r.client.ListResourceRecordSets(ctx, &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(r.hostedZoneID),
+		StartRecordName: aws.String(r.name),
+		MaxItems:        aws.Int32(10),
+	})
+	if err != nil {
+		return nil, route53Error(fmt.Errorf("failed to list record sets: %w", err))
+	}
 
-	// Helper to create ResourceRecord list
-	toRR := func(ips []string) []types.ResourceRecord {
-		rrs := []types.ResourceRecord{}
-		for _, ip := range ips {
-			rrs = append(rrs, types.ResourceRecord{Value: aws.String(ip)})
+	var records []Record
+	for _, rs := range output.ResourceRecordSets {
+		if aws.ToString(rs.Name) != r.name || (rs.Type != types.RRTypeA && rs.Type != types.RRTypeAaaa) {
+			continue
+		}
+		r.sets[rs.Type] = rs
+		for _, rr := range rs.ResourceRecords {
+			value := aws.ToString(rr.Value)
+			r.values[rs.Type] = append(r.values[rs.Type], value)
+			records = append(records, Record{Type: string(rs.Type), Value: value, TTL: time.Duration(aws.ToInt64(rs.TTL)) * time.Second})
 		}
-		return rrs
 	}
+	return records, nil
+}
 
-	// Process A records
-	if len(desiredA) > 0 {
-		changes = append(changes, types.Change{
-			Action: types.ChangeActionUpsert,
-			ResourceRecordSet: &types.ResourceRecordSet{
-				Name:            aws.String(dnsName),
-				Type:            types.RRTypeA,
-				TTL:             aws.Int64(int64(r.TTL.Seconds())),
-				ResourceRecords: toRR(desiredA),
-			},
-		})
+func (r *route53Records) Create(ctx context.Context, record Record) error {
+	rrType := types.RRType(record.Type)
+	r.values[rrType] = append(r.values[rrType], record.Value)
+	return nil
+}
+
+func (r *route53Records) Delete(ctx context.Context, record Record) error {
+	rrType := types.RRType(record.Type)
+	// the first matching address only, duplicates are deleted one at a time
+	if i := slices.Index(r.values[rrType], record.Value); i >= 0 {
+		r.values[rrType] = slices.Delete(r.values[rrType], i, i+1)
 	}
+	return nil
+}
+
+// UpdateTTL stages nothing, every record set changed is written with the configured TTL.
+func (r *route53Records) UpdateTTL(ctx context.Context, record Record) error {
+	return nil
+}
+
+func (r *route53Records) Commit(ctx context.Context) error {
+	var batch []types.Change
+	for _, rrType := range []types.RRType{types.RRTypeA, types.RRTypeAaaa} {
+		rs, exists := r.sets[rrType]
+		values := r.values[rrType]
+
+		var current []string
+		for _, rr := range rs.ResourceRecords {
+			current = append(current, aws.ToString(rr.Value))
+		}
+		ttl := int64(r.ttl.Seconds())
+		if slices.Equal(current, values) && ttl == aws.ToInt64(rs.TTL) {
+			continue
+		}
+
+		if len(values) == 0 {
+			if exists {
+				batch = append(batch, types.Change{Action: types.ChangeActionDelete, ResourceRecordSet: &rs})
+			}
+			continue
+		}
 
-	// Process AAAA records
-	if len(desiredAAAA) > 0 {
-		changes = append(changes, types.Change{
+		resourceRecords := make([]types.ResourceRecord, 0, len(values))
+		for _, value := range values {
+			resourceRecords = append(resourceRecords, types.ResourceRecord{Value: aws.String(value)})
+		}
+		batch = append(batch, types.Change{
 			Action: types.ChangeActionUpsert,
 			ResourceRecordSet: &types.ResourceRecordSet{
-				Name:            aws.String(dnsName),
-				Type:            types.RRTypeAaaa,
-				TTL:             aws.Int64(int64(r.TTL.Seconds())),
-				ResourceRecords: toRR(desiredAAAA),
+				Name:            aws.String(r.name),
+				Type:            rrType,
+				TTL:             aws.Int64(ttl),
+				ResourceRecords: resourceRecords,
 			},
 		})
 	}
+	if len(batch) == 0 {
+		return nil
+	}
 
-	// To handle deletion of records that are no longer needed, we need to list existing records for this name.
-	listInput := &route53.ListResourceRecordSetsInput{
-		HostedZoneId:    aws.String(r.HostedZoneID),
-		StartRecordName: aws.String(dnsName),
-		MaxItems:        aws.Int32(10),
+	_, err := r.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(r.hostedZoneID),
+		ChangeBatch:  &types.ChangeBatch{Changes: batch},
+	})
+	if err != nil {
+		return route53Error(fmt.Errorf("failed to change record sets: %w", err))
 	}
+	return nil
+}
 
-	output, err := client.ListResourceRecordSets(ctx, listInput)
+// UpdateReverse publishes the PTR records of the addresses in a reverse zone
+// hosted in the account. Route53 can't search the records by the hostname they
+// point to, so only the PTR records of the addresses and the previous ones are
+// looked up.
+func (r *Route53) UpdateReverse(ctx context.Context, zone string, hostname string, addrCollection *ipv6disc.AddrCollection, previous *ipv6disc.AddrCollection) ([]Change, error) {
+	client, err := r.client(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to list record sets: %v", err)
+		return nil, err
 	}
 
-	for _, rs := range output.ResourceRecordSets {
-		if aws.ToString(rs.Name) != dnsName {
-			continue
+	zoneName := strings.Trim(zone, ".") + "."
+	zones, err := client.ListHostedZonesByName(ctx, &route53.ListHostedZonesByNameInput{
+		DNSName:  aws.String(zoneName),
+		MaxItems: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, route53Error(fmt.Errorf("failed to find hosted zone %s: %w", zoneName, err))
+	}
+	if len(zones.HostedZones) == 0 || aws.ToString(zones.HostedZones[0].Name) != zoneName {
+		return nil, Permanent(fmt.Errorf("hosted zone %s could not be found", zoneName))
+	}
+
+	var names []string
+	for _, addr := range slices.Concat(addrCollection.Get(), previous.Get()) {
+		if name := ReverseName(addr.Addr); !slices.Contains(names, name) {
+			names = append(names, name)
 		}
+	}
+
+	records := &route53PTRs{
+		client:       client,
+		hostedZoneID: aws.ToString(zones.HostedZones[0].Id),
+		target:       FQDN(hostname, r.zone) + ".",
+		names:        names,
+		sets:         make(map[string]types.ResourceRecordSet),
+	}
+	return ReconcileReverse(ctx, records, addrCollection, r.TTL)
+}
+
+// route53PTRs is the PTRSet of a hostname in a Route53 reverse zone. Other
+// hostnames sharing a PTR record set are kept, and the changes are sent in a
+// single batch on Commit.
+type route53PTRs struct {
+	client       *route53.Client
+	hostedZoneID string
+	target       string
+	// names are the PTR records looked up, instead of the whole zone
+	names []string
+	// sets are the PTR record sets of the zone by name, as listed
+	sets  map[string]types.ResourceRecordSet
+	batch []types.Change
+}
 
-		if rs.Type == types.RRTypeA && len(desiredA) == 0 {
-			changes = append(changes, types.Change{
-				Action:            types.ChangeActionDelete,
-				ResourceRecordSet: &rs,
-			})
+func (r *route53PTRs) List(ctx context.Context) ([]PTRRecord, error) {
+	var records []PTRRecord
+	for _, name := range r.names {
+		// the record sets are sorted by name and type, the first one is the PTR of name if it exists
+		output, err := r.client.ListResourceRecordSets(ctx, &route53.ListResourceRecordSetsInput{
+			HostedZoneId:    aws.String(r.hostedZoneID),
+			StartRecordName: aws.String(name + "."),
+			StartRecordType: types.RRTypePtr,
+			MaxItems:        aws.Int32(1),
+		})
+		if err != nil {
+			return nil, route53Error(fmt.Errorf("failed to list record sets of %s: %w", name, err))
 		}
-		if rs.Type == types.RRTypeAaaa && len(desiredAAAA) == 0 {
-			changes = append(changes, types.Change{
-				Action:            types.ChangeActionDelete,
-				ResourceRecordSet: &rs,
-			})
+
+		for _, rs := range output.ResourceRecordSets {
+			if rs.Type != types.RRTypePtr || !strings.EqualFold(strings.TrimSuffix(aws.ToString(rs.Name), "."), name) {
+				continue
+			}
+			r.sets[name] = rs
+			for _, rr := range rs.ResourceRecords {
+				if strings.EqualFold(aws.ToString(rr.Value), r.target) {
+					records = append(records, PTRRecord{Name: name, TTL: time.Duration(aws.ToInt64(rs.TTL)) * time.Second})
+				}
+			}
 		}
 	}
+	return records, nil
+}
 
-	if len(changes) == 0 {
+func (r *route53PTRs) Create(ctx context.Context, record PTRRecord) error {
+	values := append(r.otherValues(record.Name), types.ResourceRecord{Value: aws.String(r.target)})
+	r.batch = append(r.batch, types.Change{
+		Action: types.ChangeActionUpsert,
+		ResourceRecordSet: &types.ResourceRecordSet{
+			Name:            aws.String(record.Name + "."),
+			Type:            types.RRTypePtr,
+			TTL:             aws.Int64(int64(record.TTL.Seconds())),
+			ResourceRecords: values,
+		},
+	})
+	return nil
+}
+
+func (r *route53PTRs) Delete(ctx context.Context, record PTRRecord) error {
+	rs := r.sets[record.Name]
+	values := r.otherValues(record.Name)
+	if len(values) == 0 {
+		r.batch = append(r.batch, types.Change{Action: types.ChangeActionDelete, ResourceRecordSet: &rs})
 		return nil
 	}
 
-	input := &route53.ChangeResourceRecordSetsInput{
-		HostedZoneId: aws.String(r.HostedZoneID),
-		ChangeBatch: &types.ChangeBatch{
-			Changes: changes,
-		},
+	rs.ResourceRecords = values
+	r.batch = append(r.batch, types.Change{Action: types.ChangeActionUpsert, ResourceRecordSet: &rs})
+	return nil
+}
+
+// otherValues returns the values of the PTR record set with the given name pointing to other hostnames.
+func (r *route53PTRs) otherValues(name string) []types.ResourceRecord {
+	var values []types.ResourceRecord
+	for _, rr := range r.sets[name].ResourceRecords {
+		if !strings.EqualFold(aws.ToString(rr.Value), r.target) {
+			values = append(values, rr)
+		}
 	}
+	return values
+}
 
-	_, err = client.ChangeResourceRecordSets(ctx, input)
+func (r *route53PTRs) Commit(ctx context.Context) error {
+	_, err := r.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(r.hostedZoneID),
+		ChangeBatch:  &types.ChangeBatch{Changes: r.batch},
+	})
 	if err != nil {
-		return fmt.Errorf("failed to change record sets: %v", err)
+		return route53Error(fmt.Errorf("failed to change record sets: %w", err))
 	}
-
 	return nil
 }
 
+// route53Error marks err as permanent when AWS rejected the credentials or the hosted zone does not exist.
+func route53Error(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "AccessDenied", "InvalidClientTokenId", "SignatureDoesNotMatch", "UnrecognizedClientException", "NoSuchHostedZone":
+			return Permanent(err)
+		}
+	}
+	return err
+}
+
 func (r *Route53) PrettyPrint(prefix string) ([]byte, error) {
 	return json.MarshalIndent(r, prefix, "    ")
 }
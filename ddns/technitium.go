@@ -1,6 +1,7 @@
 package ddns
 
 import (
+	"context"
 	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
@@ -10,14 +11,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/netip"
 	"net/url"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/miguelangel-nubla/ipv6disc"
-	"github.com/xeipuuv/gojsonschema"
 )
 
 type Technitium struct {
@@ -32,14 +31,18 @@ func init() {
 	RegisterProvider("technitium", NewTechnitium)
 }
 
-func NewTechnitium(settings ProviderSettings) Service {
+func NewTechnitium(settings ProviderSettings) (Service, error) {
 	var service Technitium
-	technitiumValidateConfig(settings.(json.RawMessage))
-	json.Unmarshal(settings.(json.RawMessage), &service)
-	return &service
+	if err := technitiumValidateConfig(settings.(json.RawMessage)); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(settings.(json.RawMessage), &service); err != nil {
+		return nil, err
+	}
+	return &service, nil
 }
 
-func technitiumValidateConfig(config json.RawMessage) {
+func technitiumValidateConfig(config json.RawMessage) error {
 	var configSchema = []byte(`
 	{
 		"$schema": "http://json-schema.org/draft-07/schema#",
@@ -75,21 +78,7 @@ func technitiumValidateConfig(config json.RawMessage) {
 	}
 	`)
 
-	schemaLoader := gojsonschema.NewBytesLoader(configSchema)
-	dataLoader := gojsonschema.NewBytesLoader([]byte(config))
-
-	result, err := gojsonschema.Validate(schemaLoader, dataLoader)
-	if err != nil {
-		panic(err.Error())
-	}
-
-	if !result.Valid() {
-		fmt.Printf("Technitium configuration is not valid.\nErrors:\n")
-		for _, desc := range result.Errors() {
-			fmt.Printf("- %s\n", desc)
-		}
-		os.Exit(1)
-	}
+	return validateSettings("Technitium", configSchema, config)
 }
 
 type technitiumResponse struct {
@@ -97,6 +86,7 @@ type technitiumResponse struct {
 	ErrorMessage string `json:"errorMessage"`
 	Response     struct {
 		Records []struct {
+			Name  string          `json:"name"`
 			Type  string          `json:"type"`
 			TTL   int             `json:"ttl"`
 			RData json.RawMessage `json:"rData"`
@@ -104,11 +94,45 @@ type technitiumResponse struct {
 	} `json:"response"`
 }
 
+// err returns the API error, the "invalid-token" status is not worth retrying soon.
+func (r technitiumResponse) err() error {
+	if r.Status == "invalid-token" {
+		return Permanent(fmt.Errorf("invalid token: %s", r.ErrorMessage))
+	}
+	return errors.New(r.ErrorMessage)
+}
+
 type technitiumRDataIP struct {
 	IPAddress string `json:"ipAddress"`
 }
 
-func (t *Technitium) Update(hostname string, addrCollection *ipv6disc.AddrCollection) error {
+type technitiumRDataPTR struct {
+	PTRName string `json:"ptrName"`
+}
+
+func (t *Technitium) Update(ctx context.Context, hostname string, addrCollection *ipv6disc.AddrCollection) ([]Change, error) {
+	records := &technitiumRecords{
+		Technitium: t,
+		client:     t.client(),
+		fqdn:       FQDN(hostname, t.Zone),
+	}
+	return Reconcile(ctx, records, addrCollection, t.TTL)
+}
+
+// UpdateReverse publishes the PTR records of the addresses in a reverse zone hosted by the server.
+func (t *Technitium) UpdateReverse(ctx context.Context, zone string, hostname string, addrCollection *ipv6disc.AddrCollection, previous *ipv6disc.AddrCollection) ([]Change, error) {
+	records := &technitiumPTRs{
+		Technitium: t,
+		client:     t.client(),
+		zone:       strings.Trim(zone, "."),
+		target:     FQDN(hostname, t.Zone),
+	}
+	return ReconcileReverse(ctx, records, addrCollection, t.TTL)
+}
+
+// client returns an HTTP client verifying the server certificate, or its
+// fingerprint if it can't be verified.
+func (t *Technitium) client() *http.Client {
 	tlsConfig := &tls.Config{}
 
 	// Use custom verification to support fallback to fingerprint
@@ -144,176 +168,213 @@ func (t *Technitium) Update(hostname string, addrCollection *ipv6disc.AddrCollec
 				// Fingerprint matched
 				return nil
 			}
-			fmt.Printf("Certificate fingerprint mismatch. Expected: %s, Found: %s\n", t.TLSFingerprint, fp)
+			return fmt.Errorf("certificate verification failed: %w (fingerprint mismatch, expected: %s, found: %s)", err, t.TLSFingerprint, fp)
 		}
 
 		// Both methods failed
 		return fmt.Errorf("certificate verification failed: %w (Fingerprint: %s)", err, fp)
 	}
 
-	client := &http.Client{
+	return &http.Client{
 		Transport: &http.Transport{
 			TLSClientConfig: tlsConfig,
 		},
 		Timeout: 30 * time.Second,
 	}
+}
 
-	fqdn := FQDN(hostname, t.Zone)
+// technitiumRecords is the RecordSet of a hostname in a Technitium zone.
+type technitiumRecords struct {
+	*Technitium
+	client *http.Client
+	fqdn   string
+}
 
-	// 1. Get current records
-	currentIPs, err := t.getRecords(client, fqdn)
+func (r *technitiumRecords) List(ctx context.Context) ([]Record, error) {
+	records, err := r.getRecords(ctx, r.client, r.fqdn)
 	if err != nil {
-		return fmt.Errorf("failed to get records: %v", err)
+		return nil, fmt.Errorf("failed to get records: %w", err)
 	}
+	return records, nil
+}
 
-	// 2. Identify desired IPs
-	desiredIPs := make(map[string]string) // IP -> Type("A" or "AAAA")
-	for _, addr := range addrCollection.Get() {
-		recordType := "AAAA"
-		if addr.Addr.Is4() {
-			recordType = "A"
-		}
-		ip := addr.WithZone("").String()
-		desiredIPs[ip] = recordType
+func (r *technitiumRecords) Create(ctx context.Context, record Record) error {
+	if err := r.addRecord(ctx, r.client, r.fqdn, record.Type, record.Value); err != nil {
+		return fmt.Errorf("failed to add record %s (%s): %w", r.fqdn, record.Value, err)
 	}
+	return nil
+}
 
-	// 3. Calculate Diff
-	// Delete records that are not in desired
-	for ip, recordType := range currentIPs {
-		if _, needed := desiredIPs[ip]; !needed {
-			if err := t.deleteRecord(client, fqdn, recordType, ip); err != nil {
-				return fmt.Errorf("failed to delete record %s (%s): %v", fqdn, ip, err)
-			}
-		}
+func (r *technitiumRecords) Delete(ctx context.Context, record Record) error {
+	if err := r.deleteRecord(ctx, r.client, r.fqdn, record.Type, record.Value); err != nil {
+		return fmt.Errorf("failed to delete record %s (%s): %w", r.fqdn, record.Value, err)
 	}
+	return nil
+}
 
-	// Add records that are in desired but not current
-	for ip, recordType := range desiredIPs {
-		if _, exists := currentIPs[ip]; !exists {
-			if err := t.addRecord(client, fqdn, recordType, ip); err != nil {
-				return fmt.Errorf("failed to add record %s (%s): %v", fqdn, ip, err)
-			}
-		} else {
-			// Optional: Update TTL if needed.
-			// Current implementation simplifies by only adding missing ones.
-			// To strictly enforce TTL, we could call updateRecord here.
-			// Let's stick to add/delete for now unless we track TTL in currentIPs.
-		}
+func (r *technitiumRecords) UpdateTTL(ctx context.Context, record Record) error {
+	if err := r.updateRecord(ctx, r.client, r.fqdn, record); err != nil {
+		return fmt.Errorf("failed to update record %s (%s): %w", r.fqdn, record.Value, err)
 	}
-
 	return nil
 }
 
-func (t *Technitium) getRecords(client *http.Client, domain string) (map[string]string, error) {
-	u, err := url.Parse(t.Address)
+// technitiumPTRs is the PTRSet of a hostname in a Technitium reverse zone.
+type technitiumPTRs struct {
+	*Technitium
+	client *http.Client
+	zone   string
+	target string
+}
+
+func (r *technitiumPTRs) List(ctx context.Context) ([]PTRRecord, error) {
+	apiResp, err := r.call(ctx, r.client, "/api/zones/records/get", url.Values{
+		"domain":   {r.zone},
+		"zone":     {r.zone},
+		"listZone": {"true"},
+	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to get records of %s: %w", r.zone, err)
 	}
-	u.Path, _ = url.JoinPath(u.Path, "/api/zones/records/get")
-	q := u.Query()
-	q.Set("token", t.Token)
-	q.Set("domain", domain)
-	q.Set("zone", t.Zone)
-	u.RawQuery = q.Encode()
 
-	resp, err := client.Get(u.String())
-	if err != nil {
-		return nil, err
+	var records []PTRRecord
+	for _, rec := range apiResp.Response.Records {
+		var rData technitiumRDataPTR
+		if rec.Type != "PTR" || json.Unmarshal(rec.RData, &rData) != nil {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSuffix(rData.PTRName, "."), r.target) {
+			records = append(records, PTRRecord{Name: rec.Name, TTL: time.Duration(rec.TTL) * time.Second})
+		}
 	}
-	defer resp.Body.Close()
+	return records, nil
+}
 
-	body, _ := io.ReadAll(resp.Body)
+func (r *technitiumPTRs) Create(ctx context.Context, record PTRRecord) error {
+	_, err := r.call(ctx, r.client, "/api/zones/records/add", url.Values{
+		"domain":  {record.Name},
+		"zone":    {r.zone},
+		"type":    {"PTR"},
+		"ptrName": {r.target},
+		"ttl":     {strconv.Itoa(int(record.TTL.Seconds()))},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add PTR record %s (%s): %w", record.Name, r.target, err)
+	}
+	return nil
+}
 
-	var apiResp technitiumResponse
-	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return nil, err
+func (r *technitiumPTRs) Delete(ctx context.Context, record PTRRecord) error {
+	_, err := r.call(ctx, r.client, "/api/zones/records/delete", url.Values{
+		"domain":  {record.Name},
+		"zone":    {r.zone},
+		"type":    {"PTR"},
+		"ptrName": {r.target},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete PTR record %s (%s): %w", record.Name, r.target, err)
 	}
+	return nil
+}
 
-	if apiResp.Status != "ok" {
-		return nil, errors.New(apiResp.ErrorMessage)
+func (t *Technitium) getRecords(ctx context.Context, client *http.Client, domain string) ([]Record, error) {
+	apiResp, err := t.call(ctx, client, "/api/zones/records/get", url.Values{
+		"domain": {domain},
+		"zone":   {t.Zone},
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	currentIPs := make(map[string]string)
+	var records []Record
 	for _, rec := range apiResp.Response.Records {
 		if rec.Type == "A" || rec.Type == "AAAA" {
 			var rData technitiumRDataIP
 			if err := json.Unmarshal(rec.RData, &rData); err == nil {
-				if _, err := netip.ParseAddr(rData.IPAddress); err == nil {
-					currentIPs[rData.IPAddress] = rec.Type
-				}
+				records = append(records, Record{Type: rec.Type, Value: rData.IPAddress, TTL: time.Duration(rec.TTL) * time.Second})
 			}
 		}
 	}
 
-	return currentIPs, nil
+	return records, nil
 }
 
-func (t *Technitium) addRecord(client *http.Client, domain, recordType, ip string) error {
-	u, err := url.Parse(t.Address)
-	if err != nil {
-		return err
-	}
-	u.Path, _ = url.JoinPath(u.Path, "/api/zones/records/add")
-	q := u.Query()
-	q.Set("token", t.Token)
-	q.Set("domain", domain)
-	q.Set("zone", t.Zone)
-	q.Set("type", recordType)
-	q.Set("ipAddress", ip)
-	q.Set("ttl", strconv.Itoa(int(t.TTL.Seconds())))
-	q.Set("overwrite", "false") // We manage duplicates manually by deleting first
-	u.RawQuery = q.Encode()
-
-	resp, err := client.Get(u.String())
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var apiResp technitiumResponse
-	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return err
-	}
+func (t *Technitium) addRecord(ctx context.Context, client *http.Client, domain, recordType, ip string) error {
+	_, err := t.call(ctx, client, "/api/zones/records/add", url.Values{
+		"domain":    {domain},
+		"zone":      {t.Zone},
+		"type":      {recordType},
+		"ipAddress": {ip},
+		"ttl":       {strconv.Itoa(int(t.TTL.Seconds()))},
+		"overwrite": {"false"}, // We manage duplicates manually by deleting first
+	})
+	return err
+}
 
-	if apiResp.Status != "ok" {
-		return errors.New(apiResp.ErrorMessage)
-	}
+// updateRecord sets the TTL of an existing record, keeping its address.
+func (t *Technitium) updateRecord(ctx context.Context, client *http.Client, domain string, record Record) error {
+	_, err := t.call(ctx, client, "/api/zones/records/update", url.Values{
+		"domain":       {domain},
+		"zone":         {t.Zone},
+		"type":         {record.Type},
+		"ipAddress":    {record.Value},
+		"newIpAddress": {record.Value},
+		"ttl":          {strconv.Itoa(int(record.TTL.Seconds()))},
+	})
+	return err
+}
 
-	return nil
+func (t *Technitium) deleteRecord(ctx context.Context, client *http.Client, domain, recordType, ip string) error {
+	_, err := t.call(ctx, client, "/api/zones/records/delete", url.Values{
+		"domain":    {domain},
+		"zone":      {t.Zone},
+		"type":      {recordType},
+		"ipAddress": {ip},
+	})
+	return err
 }
 
-func (t *Technitium) deleteRecord(client *http.Client, domain, recordType, ip string) error {
+// call runs an API call with the token and the given parameters, returning
+// an error unless its status is ok.
+func (t *Technitium) call(ctx context.Context, client *http.Client, path string, params url.Values) (technitiumResponse, error) {
+	var apiResp technitiumResponse
+
 	u, err := url.Parse(t.Address)
 	if err != nil {
-		return err
+		return apiResp, err
 	}
-	u.Path, _ = url.JoinPath(u.Path, "/api/zones/records/delete")
+	u.Path, _ = url.JoinPath(u.Path, path)
 	q := u.Query()
 	q.Set("token", t.Token)
-	q.Set("domain", domain)
-	q.Set("zone", t.Zone)
-	q.Set("type", recordType)
-	q.Set("ipAddress", ip)
+	for key, values := range params {
+		q[key] = values
+	}
 	u.RawQuery = q.Encode()
 
-	resp, err := client.Get(u.String())
+	resp, err := technitiumGet(ctx, client, u.String())
 	if err != nil {
-		return err
+		return apiResp, err
 	}
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
-	var apiResp technitiumResponse
 	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return err
+		return apiResp, err
 	}
 
 	if apiResp.Status != "ok" {
-		return errors.New(apiResp.ErrorMessage)
+		return apiResp, apiResp.err()
 	}
 
-	return nil
+	return apiResp, nil
+}
+
+func technitiumGet(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
 }
 
 func (t *Technitium) PrettyPrint(prefix string) ([]byte, error) {
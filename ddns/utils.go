@@ -1,7 +1,14 @@
 package ddns
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
 	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+	"golang.org/x/crypto/ssh"
 )
 
 // FQDN returns a fully qualified domain name given a hostname and a zone.
@@ -35,3 +42,45 @@ func SplitFQDN(fqdn string) (string, string) {
 
 	return fqdn, ""
 }
+
+// validateSettings checks the provider settings against the provider JSON schema.
+func validateSettings(provider string, schema []byte, settings json.RawMessage) error {
+	schemaLoader := gojsonschema.NewBytesLoader(schema)
+	dataLoader := gojsonschema.NewBytesLoader([]byte(settings))
+
+	result, err := gojsonschema.Validate(schemaLoader, dataLoader)
+	if err != nil {
+		return fmt.Errorf("%s configuration could not be validated: %w", provider, err)
+	}
+
+	if !result.Valid() {
+		var errs strings.Builder
+		for _, desc := range result.Errors() {
+			fmt.Fprintf(&errs, "\n- %s", desc)
+		}
+		return fmt.Errorf("%s configuration is not valid:%s", provider, errs.String())
+	}
+
+	return nil
+}
+
+// sshDial connects to an SSH server. Once ctx is done the connection is
+// closed, interrupting the dial and any running session.
+func sshDial(ctx context.Context, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	dialer := net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
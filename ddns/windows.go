@@ -2,10 +2,10 @@ package ddns
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"net/netip"
 	"os"
 	"os/exec"
 	"strings"
@@ -13,7 +13,6 @@ import (
 	"unicode/utf16"
 
 	"github.com/miguelangel-nubla/ipv6disc"
-	"github.com/xeipuuv/gojsonschema"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -30,14 +29,18 @@ func init() {
 	RegisterProvider("windows", NewWindowsDNS)
 }
 
-func NewWindowsDNS(settings ProviderSettings) Service {
+func NewWindowsDNS(settings ProviderSettings) (Service, error) {
 	var service WindowsDNS
-	windowsValidateConfig(settings.(json.RawMessage))
-	json.Unmarshal(settings.(json.RawMessage), &service)
-	return &service
+	if err := windowsValidateConfig(settings.(json.RawMessage)); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(settings.(json.RawMessage), &service); err != nil {
+		return nil, err
+	}
+	return &service, nil
 }
 
-func windowsValidateConfig(config json.RawMessage) {
+func windowsValidateConfig(config json.RawMessage) error {
 	var configSchema = []byte(`
 	{
 		"$schema": "http://json-schema.org/draft-07/schema#",
@@ -57,38 +60,39 @@ func windowsValidateConfig(config json.RawMessage) {
 	}
 	`)
 
-	schemaLoader := gojsonschema.NewBytesLoader(configSchema)
-	dataLoader := gojsonschema.NewBytesLoader([]byte(config))
-
-	result, err := gojsonschema.Validate(schemaLoader, dataLoader)
-	if err != nil {
-		panic(err.Error())
-	}
-
-	if !result.Valid() {
-		fmt.Printf("Windows configuration is not valid.\nErrors:\n")
-		for _, desc := range result.Errors() {
-			fmt.Printf("- %s\n", desc)
-		}
-		os.Exit(1)
-	}
+	return validateSettings("Windows", configSchema, config)
 }
 
-func (w *WindowsDNS) Update(hostname string, addrCollection *ipv6disc.AddrCollection) error {
+func (w *WindowsDNS) Update(ctx context.Context, hostname string, addrCollection *ipv6disc.AddrCollection) ([]Change, error) {
 	var runner WindowsRunner
 	var err error
 
 	if w.Address != "" {
-		runner, err = NewSSHRunner(w.Address, w.Username, w.Password, w.SSHKey)
+		runner, err = NewSSHRunner(ctx, w.Address, w.Username, w.Password, w.SSHKey)
 	} else {
 		runner = &LocalRunner{}
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer runner.Close()
 
-	// 1. Get current IPs (both A and AAAA)
+	records := &windowsRecords{
+		WindowsDNS: w,
+		runner:     runner,
+		hostname:   hostname,
+	}
+	return Reconcile(ctx, records, addrCollection, w.TTL)
+}
+
+// windowsRecords is the RecordSet of a hostname in a Windows DNS Server zone.
+type windowsRecords struct {
+	*WindowsDNS
+	runner   WindowsRunner
+	hostname string
+}
+
+func (r *windowsRecords) List(ctx context.Context) ([]Record, error) {
 	psScript := fmt.Sprintf(`
 try {
     $output = @()
@@ -107,11 +111,11 @@ try {
     Write-Error $_.Exception.Message
     exit 1
 }
-`, w.Zone, hostname, w.Zone, hostname)
+`, r.Zone, r.hostname, r.Zone, r.hostname)
 
-	output, err := runner.RunPS(psScript)
+	output, err := r.runner.RunPS(ctx, psScript)
 	if err != nil {
-		return fmt.Errorf("failed to get records: %v, output: %s", err, string(output))
+		return nil, fmt.Errorf("failed to get records: %v, output: %s", err, string(output))
 	}
 
 	var currentIPs []string
@@ -120,95 +124,54 @@ try {
 		// Can be string or array of strings
 		if strings.HasPrefix(trimmedOutput, "[") {
 			if err := json.Unmarshal([]byte(trimmedOutput), &currentIPs); err != nil {
-				return fmt.Errorf("failed to parse array json: %v, output: %s", err, trimmedOutput)
+				return nil, fmt.Errorf("failed to parse array json: %v, output: %s", err, trimmedOutput)
 			}
 		} else {
 			var ip string
 			if err := json.Unmarshal([]byte(trimmedOutput), &ip); err != nil {
-				return fmt.Errorf("failed to parse string json: %v, output: %s", err, trimmedOutput)
+				return nil, fmt.Errorf("failed to parse string json: %v, output: %s", err, trimmedOutput)
 			}
 			currentIPs = append(currentIPs, ip)
 		}
 	}
 
-	// 2. Calculate Diff
-	desiredIPs := make(map[string]bool)
-	for _, addr := range addrCollection.Get() {
-		desiredIPs[addr.WithZone("").String()] = true
-	}
-
-	existingMap := make(map[string]bool)
+	var records []Record
 	for _, ip := range currentIPs {
-		existingMap[ip] = true
+		records = append(records, Record{Type: recordType(ip), Value: ip})
 	}
+	return records, nil
+}
 
-	var toAdd []string
-	var toDelete []string
-
-	for ip := range desiredIPs {
-		if !existingMap[ip] {
-			toAdd = append(toAdd, ip)
-		}
+func (r *windowsRecords) Create(ctx context.Context, record Record) error {
+	typeSwitch := "-A"
+	ipParam := "-IPv4Address"
+	if record.Type == "AAAA" {
+		typeSwitch = "-Aaaa"
+		ipParam = "-IPv6Address"
 	}
 
-	for ip := range existingMap {
-		if !desiredIPs[ip] {
-			toDelete = append(toDelete, ip)
-		}
-	}
+	cmd := fmt.Sprintf("Add-DnsServerResourceRecord -ZoneName '%s' -Name '%s' %s %s '%s'", r.Zone, r.hostname, typeSwitch, ipParam, record.Value)
 
-	if len(toAdd) == 0 && len(toDelete) == 0 {
-		return nil
+	if record.TTL > 0 {
+		ttlStr := fmt.Sprintf("%02d:%02d:%02d", int(record.TTL.Hours()), int(record.TTL.Minutes())%60, int(record.TTL.Seconds())%60)
+		cmd += fmt.Sprintf(" -TimeToLive '%s'", ttlStr)
 	}
-
-	// 3. Apply Changes
-	for _, ip := range toDelete {
-		ipAddr, err := netip.ParseAddr(ip)
-		if err != nil {
-			return fmt.Errorf("failed to parse IP %s for deletion: %v", ip, err)
-		}
-
-		var rrType string
-		if ipAddr.Is4() {
-			rrType = "A"
-		} else {
-			rrType = "AAAA"
-		}
-
-		cmd := fmt.Sprintf("Remove-DnsServerResourceRecord -ZoneName '%s' -Name '%s' -RRType %s -RecordData '%s' -Force", w.Zone, hostname, rrType, ip)
-		if out, err := runner.RunPS(cmd); err != nil {
-			return fmt.Errorf("failed to delete record %s: %v, output: %s", ip, err, string(out))
-		}
+	if out, err := r.runner.RunPS(ctx, cmd); err != nil {
+		return fmt.Errorf("failed to add record %s: %v, output: %s", record.Value, err, string(out))
 	}
+	return nil
+}
 
-	for _, ip := range toAdd {
-		ipAddr, err := netip.ParseAddr(ip)
-		if err != nil {
-			return fmt.Errorf("failed to parse IP %s for addition: %v", ip, err)
-		}
-
-		var typeSwitch string
-		var ipParam string
-
-		if ipAddr.Is4() {
-			typeSwitch = "-A"
-			ipParam = "-IPv4Address"
-		} else {
-			typeSwitch = "-Aaaa"
-			ipParam = "-IPv6Address"
-		}
-
-		cmd := fmt.Sprintf("Add-DnsServerResourceRecord -ZoneName '%s' -Name '%s' %s %s '%s'", w.Zone, hostname, typeSwitch, ipParam, ip)
-
-		if w.TTL > 0 {
-			ttlStr := fmt.Sprintf("%02d:%02d:%02d", int(w.TTL.Hours()), int(w.TTL.Minutes())%60, int(w.TTL.Seconds())%60)
-			cmd += fmt.Sprintf(" -TimeToLive '%s'", ttlStr)
-		}
-		if out, err := runner.RunPS(cmd); err != nil {
-			return fmt.Errorf("failed to add record %s: %v, output: %s", ip, err, string(out))
-		}
+func (r *windowsRecords) Delete(ctx context.Context, record Record) error {
+	cmd := fmt.Sprintf("Remove-DnsServerResourceRecord -ZoneName '%s' -Name '%s' -RRType %s -RecordData '%s' -Force", r.Zone, r.hostname, record.Type, record.Value)
+	if out, err := r.runner.RunPS(ctx, cmd); err != nil {
+		return fmt.Errorf("failed to delete record %s: %v, output: %s", record.Value, err, string(out))
 	}
+	return nil
+}
 
+// UpdateTTL is never called, the records listed carry no TTL.
+func (r *windowsRecords) UpdateTTL(ctx context.Context, record Record) error {
 	return nil
 }
 
@@ -264,7 +227,7 @@ func (w *WindowsDNS) MarshalJSON() ([]byte, error) {
 }
 
 type WindowsRunner interface {
-	RunPS(psScript string) ([]byte, error)
+	RunPS(ctx context.Context, psScript string) ([]byte, error)
 	Close() error
 }
 
@@ -288,7 +251,7 @@ func preparePowerShellCommand(psScript string) (string, error) {
 
 type LocalRunner struct{}
 
-func (l *LocalRunner) RunPS(psScript string) ([]byte, error) {
+func (l *LocalRunner) RunPS(ctx context.Context, psScript string) ([]byte, error) {
 	encoded, err := preparePowerShellCommand(psScript)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode powershell command: %v", err)
@@ -300,7 +263,7 @@ func (l *LocalRunner) RunPS(psScript string) ([]byte, error) {
 		bin = "pwsh"
 	}
 
-	command := exec.Command(bin, "-NoProfile", "-NonInteractive", "-EncodedCommand", encoded)
+	command := exec.CommandContext(ctx, bin, "-NoProfile", "-NonInteractive", "-EncodedCommand", encoded)
 	// Capture stderr independently
 	var stderr bytes.Buffer
 	command.Stderr = &stderr
@@ -321,7 +284,7 @@ type SSHRunner struct {
 	client *ssh.Client
 }
 
-func NewSSHRunner(address, username, password, keyPath string) (*SSHRunner, error) {
+func NewSSHRunner(ctx context.Context, address, username, password, keyPath string) (*SSHRunner, error) {
 	config := &ssh.ClientConfig{
 		User:            username,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
@@ -331,17 +294,17 @@ func NewSSHRunner(address, username, password, keyPath string) (*SSHRunner, erro
 	if keyPath != "" {
 		key, err := os.ReadFile(keyPath)
 		if err != nil {
-			return nil, fmt.Errorf("unable to read private key: %v", err)
+			return nil, Permanent(fmt.Errorf("unable to read private key: %v", err))
 		}
 		signer, err := ssh.ParsePrivateKey(key)
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse private key: %v", err)
+			return nil, Permanent(fmt.Errorf("unable to parse private key: %v", err))
 		}
 		config.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
 	} else if password != "" {
 		config.Auth = []ssh.AuthMethod{ssh.Password(password)}
 	} else {
-		return nil, fmt.Errorf("no authentication method provided for SSH")
+		return nil, Permanent(fmt.Errorf("no authentication method provided for SSH"))
 	}
 
 	// Default port 22
@@ -349,15 +312,21 @@ func NewSSHRunner(address, username, password, keyPath string) (*SSHRunner, erro
 		address = address + ":22"
 	}
 
-	client, err := ssh.Dial("tcp", address, config)
+	client, err := sshDial(ctx, address, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to dial ssh: %v", err)
+		return nil, sshError(fmt.Errorf("failed to dial ssh: %w", err))
 	}
 
 	return &SSHRunner{client: client}, nil
 }
 
-func (s *SSHRunner) RunPS(psScript string) ([]byte, error) {
+// RunPS runs the script in a new session. The connection is closed once the
+// context used to create the runner is done, which also ends the session.
+func (s *SSHRunner) RunPS(ctx context.Context, psScript string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	session, err := s.client.NewSession()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create session: %v", err)
@@ -0,0 +1,192 @@
+package ipv6ddns
+
+import (
+	"encoding/hex"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+	"github.com/miguelangel-nubla/ipv6disc"
+)
+
+// templateKey identifies a hostname template of a task endpoint.
+type templateKey struct {
+	task     string
+	endpoint string
+	template string
+}
+
+// options returns the hostname options of the template in cfg, if still there.
+func (k templateKey) options(cfg config.Config) (config.Hostname, bool) {
+	for _, hostname := range cfg.Tasks[k.task].Endpoints[k.endpoint] {
+		if hostname.Template == k.template {
+			return hostname, true
+		}
+	}
+	return config.Hostname{}, false
+}
+
+// generatedNames are the hostnames currently created by a template.
+type generatedNames struct {
+	names map[string]bool
+	// capped is set when the limit of the template left devices out
+	capped bool
+}
+
+// templateData is what hostname templates are rendered with, once for every
+// address of a device.
+type templateData struct {
+	// MAC is the hardware address of the device, as in 00:11:22:33:44:55
+	MAC string
+	// Address is the IP address, without zone
+	Address string
+	// Sources are the discovery sources that reported the address
+	Sources []string
+	// IIDHex is the interface identifier of IPv6 addresses, the lower 64 bits
+	// in hex, empty for IPv4
+	IIDHex string
+}
+
+func newTemplateData(addr *ipv6disc.Addr) templateData {
+	data := templateData{
+		MAC:     addr.Hw.String(),
+		Address: addr.WithZone("").String(),
+		Sources: addr.Sources,
+	}
+	if addr.Is6() && !addr.Is4In6() {
+		ip := addr.As16()
+		data.IIDHex = hex.EncodeToString(ip[8:])
+	}
+	return data
+}
+
+// generateHostnames renders the template for every address, grouping the
+// addresses by the resulting name. Names that are not valid hostnames are
+// ignored. The names in previous are kept first, then the new ones are added
+// in order up to max unless zero, reporting whether some were left out.
+func generateHostnames(tmpl *template.Template, addrs []*ipv6disc.Addr, previous map[string]bool, max int) (map[string]*ipv6disc.AddrCollection, bool) {
+	rendered := make(map[string]*ipv6disc.AddrCollection)
+	for _, addr := range addrs {
+		var name strings.Builder
+		if err := tmpl.Execute(&name, newTemplateData(addr)); err != nil {
+			continue
+		}
+		hostname := strings.ToLower(strings.TrimSpace(name.String()))
+		if !config.ValidHostname(hostname) {
+			continue
+		}
+
+		if _, ok := rendered[hostname]; !ok {
+			rendered[hostname] = ipv6disc.NewAddrCollection()
+		}
+		rendered[hostname].Add(addr)
+	}
+
+	if max == 0 || len(rendered) <= max {
+		return rendered, false
+	}
+
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if previous[names[i]] != previous[names[j]] {
+			return previous[names[i]]
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names[max:] {
+		delete(rendered, name)
+	}
+	return rendered, true
+}
+
+// updateGenerated adds the devices of a task to desired under the names
+// generated by a template of one of its endpoints, tearing down the names no
+// longer generated. The caller must hold configMutex.
+func (w *Worker) updateGenerated(taskName string, task config.Task, endpointKey string, options config.Hostname, devices *ipv6disc.AddrCollection, desired map[hostnameRef]desiredAddrs) {
+	tmpl, err := options.ParseTemplate()
+	if err != nil {
+		// already checked when the configuration was loaded
+		w.logger.Errorf("endpoint %s: %s", endpointKey, err)
+		return
+	}
+
+	key := templateKey{task: taskName, endpoint: endpointKey, template: options.Template}
+
+	w.generatedMutex.Lock()
+	previous := w.generated[key]
+	hostnames, capped := generateHostnames(tmpl, devices.FilterValid().Get(), previous.names, options.MaxHostnames)
+	if len(hostnames) == 0 && task.EmptyPolicy != "" && task.EmptyPolicy != config.EmptyPublish {
+		// without devices the previous names stay, for the empty policy to apply to them
+		for name := range previous.names {
+			hostnames[name] = ipv6disc.NewAddrCollection()
+		}
+		capped = previous.capped
+	}
+	current := generatedNames{names: make(map[string]bool), capped: capped}
+	for name := range hostnames {
+		current.names[name] = true
+	}
+	w.generated[key] = current
+	w.generatedMutex.Unlock()
+
+	if capped && !previous.capped {
+		w.logger.Warnf("endpoint %s template %s reached its limit of %d hostnames, ignoring the other devices", endpointKey, options.Template, options.MaxHostnames)
+	}
+
+	for name, addrCollection := range hostnames {
+		if !previous.names[name] {
+			w.logger.Infof("endpoint %s hostname %s generated by template %s", endpointKey, name, options.Template)
+		}
+		addrCollection = selectAddrs(task.Selection, addrCollection, w.changes.firstSeen)
+		desired[hostnameRef{endpoint: endpointKey, name: name}] = desiredAddrs{options, publishedTypes(task.HostnameRecordTypes(options), addrCollection)}
+	}
+
+	var removed []string
+	for name := range previous.names {
+		if !current.names[name] {
+			removed = append(removed, name)
+		}
+	}
+	w.removeGenerated(w.config, endpointKey, removed)
+}
+
+// removeGenerated tears down the hostnames no longer generated by a template,
+// unless configured or generated by another one. Their records are left to
+// the cleanup policy, as for the hostnames removed from the configuration.
+func (w *Worker) removeGenerated(cfg config.Config, endpointKey string, names []string) {
+	if len(names) == 0 {
+		return
+	}
+
+	inUse := cfg.Hostnames()
+	w.addGenerated(inUse)
+
+	providerKey := cfg.Credentials[endpointKey].Provider
+	for _, name := range names {
+		if inUse[endpointKey][name] {
+			continue
+		}
+		w.logger.Infof("endpoint %s hostname %s no longer generated, tearing it down", endpointKey, name)
+		w.State.removeHostname(providerKey, endpointKey, name)
+	}
+}
+
+// addGenerated adds the hostnames currently generated by templates to hostnames, by endpoint.
+func (w *Worker) addGenerated(hostnames map[string]map[string]bool) {
+	w.generatedMutex.Lock()
+	defer w.generatedMutex.Unlock()
+
+	for key, generated := range w.generated {
+		for name := range generated.names {
+			if hostnames[key.endpoint] == nil {
+				hostnames[key.endpoint] = make(map[string]bool)
+			}
+			hostnames[key.endpoint][name] = true
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package ipv6ddns
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+	"github.com/miguelangel-nubla/ipv6disc"
+)
+
+func TestGenerateHostnames(t *testing.T) {
+	a1 := newTestAddr("00:11:22:33:44:aa", "2001:db8::1", time.Hour)
+	a2 := newTestAddr("00:11:22:33:44:aa", "2001:db8::2", time.Hour)
+	b := newTestAddr("00:11:22:33:44:bb", "2001:db8::3", time.Hour)
+	c := newTestAddr("00:11:22:33:44:cc", "2001:db8::4", time.Hour)
+	all := []*ipv6disc.Addr{a1, a2, b, c}
+
+	tests := []struct {
+		name       string
+		template   string
+		previous   map[string]bool
+		max        int
+		want       map[string][]string
+		wantCapped bool
+	}{
+		{"Groups By Name", `dev-{{ .MAC | replace ":" "" }}`, nil, 0, map[string][]string{
+			"dev-0011223344aa": {"2001:db8::1", "2001:db8::2"},
+			"dev-0011223344bb": {"2001:db8::3"},
+			"dev-0011223344cc": {"2001:db8::4"},
+		}, false},
+		{"Lowercases Names", `DEV-{{ .IIDHex }}`, nil, 0, map[string][]string{
+			"dev-0000000000000001": {"2001:db8::1"},
+			"dev-0000000000000002": {"2001:db8::2"},
+			"dev-0000000000000003": {"2001:db8::3"},
+			"dev-0000000000000004": {"2001:db8::4"},
+		}, false},
+		{"Skips Invalid Names", `{{ .Address }}`, nil, 0, map[string][]string{}, false},
+		{"Under The Limit", `dev-{{ .MAC | replace ":" "" }}`, nil, 3, map[string][]string{
+			"dev-0011223344aa": {"2001:db8::1", "2001:db8::2"},
+			"dev-0011223344bb": {"2001:db8::3"},
+			"dev-0011223344cc": {"2001:db8::4"},
+		}, false},
+		{"Capped In Order", `dev-{{ .MAC | replace ":" "" }}`, nil, 2, map[string][]string{
+			"dev-0011223344aa": {"2001:db8::1", "2001:db8::2"},
+			"dev-0011223344bb": {"2001:db8::3"},
+		}, true},
+		{"Capped Keeping Previous", `dev-{{ .MAC | replace ":" "" }}`, map[string]bool{"dev-0011223344cc": true}, 2, map[string][]string{
+			"dev-0011223344aa": {"2001:db8::1", "2001:db8::2"},
+			"dev-0011223344cc": {"2001:db8::4"},
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := config.Hostname{Template: tt.template}.ParseTemplate()
+			if err != nil {
+				t.Fatalf("ParseTemplate() failed: %v", err)
+			}
+
+			hostnames, capped := generateHostnames(tmpl, all, tt.previous, tt.max)
+
+			got := make(map[string][]string)
+			for name, addrCollection := range hostnames {
+				got[name] = addrCollection.Strings()
+				slices.Sort(got[name])
+			}
+			if !maps.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("generateHostnames() = %v, want %v", got, tt.want)
+			}
+			if capped != tt.wantCapped {
+				t.Errorf("capped = %v, want %v", capped, tt.wantCapped)
+			}
+		})
+	}
+}
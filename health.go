@@ -0,0 +1,120 @@
+package ipv6ddns
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// scanStallTimeout is how long the loop looking for changes can go without
+// finishing a scan before it is considered stuck.
+const scanStallTimeout = 2 * fullScanInterval
+
+// HealthReport is the result of the liveness or readiness checks, served as
+// JSON by the web server, see docs/api.md.
+type HealthReport struct {
+	OK     bool          `json:"ok"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+type HealthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func (r *HealthReport) add(name string, ok bool, detail string) {
+	r.Checks = append(r.Checks, HealthCheck{Name: name, OK: ok, Detail: detail})
+	r.OK = r.OK && ok
+}
+
+// Health checks that the discovery worker was started, the loop looking for
+// changes keeps scanning and the IPv4 handlers keep running their commands.
+func (w *Worker) Health() HealthReport {
+	report := HealthReport{OK: true, Checks: []HealthCheck{}}
+
+	if w.discoveryStarted.Load() {
+		report.add("discovery", true, "")
+	} else {
+		report.add("discovery", false, "not started")
+	}
+
+	lastScan := w.lastScan.Load()
+	switch {
+	case lastScan == 0:
+		report.add("scan", false, "no scan finished yet")
+	case time.Since(time.Unix(0, lastScan)) > scanStallTimeout:
+		report.add("scan", false, fmt.Sprintf("last scan finished %s ago", time.Since(time.Unix(0, lastScan)).Round(time.Second)))
+	default:
+		report.add("scan", true, "")
+	}
+
+	w.configMutex.RLock()
+	defer w.configMutex.RUnlock()
+	for _, taskName := range sortedKeys(w.config.Tasks) {
+		handler := w.config.Tasks[taskName].IPv4
+		if handler == nil {
+			continue
+		}
+		if handler.Alive() {
+			report.add("ipv4 "+taskName, true, "")
+		} else {
+			report.add("ipv4 "+taskName, false, fmt.Sprintf("command %s not run in the last %s", handler.Command, 2*handler.Interval))
+		}
+	}
+
+	return report
+}
+
+// Ready checks that the warm-up is over, so the published records reflect
+// the network, and that no endpoint has hostnames whose updates have been
+// failing for longer than failureThreshold. Zero disables the latter.
+func (w *Worker) Ready(failureThreshold time.Duration) HealthReport {
+	report := HealthReport{OK: true, Checks: []HealthCheck{}}
+
+	if remaining := time.Until(w.warmupEnd); remaining > 0 {
+		report.add("warmup", false, fmt.Sprintf("%s remaining", remaining.Round(time.Second)))
+	} else if w.lastScan.Load() == 0 {
+		report.add("warmup", false, "no scan finished yet")
+	} else {
+		report.add("warmup", true, "")
+	}
+
+	if failureThreshold <= 0 {
+		return report
+	}
+
+	failing := make(map[string][]string)
+	s := w.State
+	s.providersMutex.RLock()
+	for _, provider := range s.providers {
+		provider.endpointsMutex.RLock()
+		for endpointKey, endpoint := range provider.endpoints {
+			failing[endpointKey] = nil
+			endpoint.hostnamesMutex.RLock()
+			for _, hostname := range endpoint.hostnames {
+				hostname.mutex.RLock()
+				if !hostname.failingSince.IsZero() && time.Since(hostname.failingSince) > failureThreshold {
+					failing[endpointKey] = append(failing[endpointKey], hostname.domain)
+				}
+				hostname.mutex.RUnlock()
+			}
+			endpoint.hostnamesMutex.RUnlock()
+		}
+		provider.endpointsMutex.RUnlock()
+	}
+	s.providersMutex.RUnlock()
+
+	for _, endpointKey := range sortedKeys(failing) {
+		domains := failing[endpointKey]
+		if len(domains) == 0 {
+			report.add("endpoint "+endpointKey, true, "")
+			continue
+		}
+		slices.Sort(domains)
+		report.add("endpoint "+endpointKey, false, fmt.Sprintf("failing for over %s: %s", failureThreshold, strings.Join(domains, ", ")))
+	}
+
+	return report
+}
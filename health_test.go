@@ -0,0 +1,122 @@
+package ipv6ddns
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+)
+
+// failingCheck returns the detail of the named check, whether it failed and whether it was found.
+func failingCheck(report HealthReport, name string) (string, bool, bool) {
+	for _, check := range report.Checks {
+		if check.Name == name {
+			return check.Detail, !check.OK, true
+		}
+	}
+	return "", false, false
+}
+
+func TestWorkerHealth(t *testing.T) {
+	t.Run("Before Start", func(t *testing.T) {
+		w := newTestWorker(t, &testService{})
+
+		report := w.Health()
+		if report.OK {
+			t.Errorf("Health() = %+v, want a failure before start", report)
+		}
+		if _, failed, _ := failingCheck(report, "discovery"); !failed {
+			t.Errorf("discovery check passed, want it failing before start")
+		}
+		if detail, failed, _ := failingCheck(report, "scan"); !failed || detail != "no scan finished yet" {
+			t.Errorf("scan check = %q, %v, want no scan finished yet", detail, failed)
+		}
+	})
+
+	t.Run("Scanning", func(t *testing.T) {
+		w := newTestWorker(t, &testService{})
+		w.discoveryStarted.Store(true)
+		w.lastScan.Store(time.Now().UnixNano())
+
+		if report := w.Health(); !report.OK || len(report.Checks) != 2 {
+			t.Errorf("Health() = %+v, want the discovery and scan checks passing", report)
+		}
+	})
+
+	t.Run("Stalled Scan", func(t *testing.T) {
+		w := newTestWorker(t, &testService{})
+		w.discoveryStarted.Store(true)
+		w.lastScan.Store(time.Now().Add(-2 * scanStallTimeout).UnixNano())
+
+		if detail, failed, _ := failingCheck(w.Health(), "scan"); !failed || !strings.HasPrefix(detail, "last scan finished") {
+			t.Errorf("scan check = %q, %v, want it stalled", detail, failed)
+		}
+	})
+
+	t.Run("IPv4 Handler Not Running", func(t *testing.T) {
+		w := newTestWorker(t, &testService{})
+		w.discoveryStarted.Store(true)
+		w.lastScan.Store(time.Now().UnixNano())
+		task := w.config.Tasks["task"]
+		task.IPv4 = &config.IPv4Handler{Interval: time.Minute, Command: "curl"}
+		w.config.Tasks["task"] = task
+
+		report := w.Health()
+		if detail, failed, found := failingCheck(report, "ipv4 task"); !found || !failed || !strings.Contains(detail, "curl") {
+			t.Errorf("ipv4 check = %q, %v, want it failing naming the command", detail, failed)
+		}
+		if report.OK {
+			t.Errorf("Health() passed, want the IPv4 handler to fail it")
+		}
+	})
+}
+
+func TestWorkerReady(t *testing.T) {
+	t.Run("During Warm-up", func(t *testing.T) {
+		w := newTestWorker(t, &testService{})
+		w.warmupEnd = time.Now().Add(time.Hour)
+		w.lastScan.Store(time.Now().UnixNano())
+
+		if detail, failed, _ := failingCheck(w.Ready(time.Hour), "warmup"); !failed || !strings.HasSuffix(detail, "remaining") {
+			t.Errorf("warmup check = %q, %v, want the time remaining", detail, failed)
+		}
+	})
+
+	t.Run("Before The First Scan", func(t *testing.T) {
+		w := newTestWorker(t, &testService{})
+
+		if detail, failed, _ := failingCheck(w.Ready(time.Hour), "warmup"); !failed || detail != "no scan finished yet" {
+			t.Errorf("warmup check = %q, %v, want no scan finished yet", detail, failed)
+		}
+	})
+
+	t.Run("Failing Hostnames", func(t *testing.T) {
+		w := newTestWorker(t, &testService{}, "host", "other")
+		w.lastScan.Store(time.Now().UnixNano())
+
+		if report := w.Ready(time.Hour); !report.OK {
+			t.Fatalf("Ready() = %+v, want ready without failures", report)
+		}
+
+		for _, selected := range w.State.selectHostnames("endpoint", nil) {
+			selected.mutex.Lock()
+			if selected.hostnameKey == "host" {
+				selected.failingSince = time.Now().Add(-2 * time.Hour)
+			} else {
+				selected.failingSince = time.Now().Add(-time.Minute)
+			}
+			selected.mutex.Unlock()
+		}
+
+		report := w.Ready(time.Hour)
+		detail, failed, _ := failingCheck(report, "endpoint endpoint")
+		if report.OK || !failed || !strings.HasSuffix(detail, ": host.example.com") {
+			t.Errorf("endpoint check = %q, %v, want only the hostname failing for over an hour", detail, failed)
+		}
+
+		if report := w.Ready(0); !report.OK || len(report.Checks) != 1 {
+			t.Errorf("Ready(0) = %+v, want the failures ignored", report)
+		}
+	})
+}
@@ -1,74 +1,294 @@
 package ipv6ddns
 
 import (
+	"errors"
 	"sync"
 	"time"
 
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+	"github.com/miguelangel-nubla/ipv6ddns/ddns"
 	"github.com/miguelangel-nubla/ipv6disc"
 )
 
+var errHostnameStopped = errors.New("hostname is being removed")
+
 type Hostname struct {
 	ipv6disc.AddrCollection
 
 	mutex sync.RWMutex
 
+	// domain is the name the records are published under
+	domain string
+
 	updatedTime time.Time
 
 	nextUpdateTime  time.Time
 	nextUpdateTimer *time.Timer
 
+	// pendingSince is when the first change not picked up by an update happened
+	pendingSince time.Time
+
+	// resyncPending is set when the scheduled update is a periodic resync
+	resyncPending bool
+	resyncTime    time.Time
+	driftTime     time.Time
+
+	// plannedChanges are the changes the last dry run would have made
+	plannedChanges []ddns.Change
+	plannedTime    time.Time
+
 	updateRunning bool
-	updateError   error
+	// updateDone is closed when the running update finishes
+	updateDone chan struct{}
+	// rerun is set when a scheduled update found another one running
+	rerun          bool
+	updateError    error
+	updateAttempts int
+	updateFailed   bool
+	// failingSince is when the updates started failing, zero if the last one succeeded
+	failingSince time.Time
 
-	updateAction        func(*ipv6disc.AddrCollection) error
-	updateDebounceTime  time.Duration
-	updateRetryInterval time.Duration
+	// stats are the counters of every update, exposed as metrics
+	stats updateStats
+	// publishedCount is the number of addresses last published successfully
+	publishedCount int
+
+	stopped bool
+	// holdUntil is the end of the warm-up, until then addresses are only
+	// added and no record is deleted, so the ones discovery has not seen again
+	// yet stay published
+	holdUntil time.Time
+
+	updateAction func(addrCollection *ipv6disc.AddrCollection, resync bool) ([]ddns.Change, error)
+	credential   config.Credential
 }
 
 func (h *Hostname) SetAddrCollection(addrCollection *ipv6disc.AddrCollection) {
 	addrCollection = addrCollection.FilterValid()
-	if !h.AddrCollection.Equal(addrCollection) {
-		h.AddrCollection.Join(addrCollection)
-		h.ScheduleUpdate(h.updateDebounceTime)
-	}
 
 	h.mutex.Lock()
-	h.AddrCollection = *h.AddrCollection.FilterValid()
+	if time.Now().Before(h.holdUntil) {
+		held := ipv6disc.NewAddrCollection()
+		held.Join(h.AddrCollection.FilterValid())
+		held.Join(addrCollection)
+		addrCollection = held
+	}
+	changed := !h.AddrCollection.Equal(addrCollection)
+	// replace rather than join, so addresses left out by the task selection are dropped
+	h.AddrCollection = *addrCollection
 	h.mutex.Unlock()
+
+	if changed {
+		h.debounce()
+	}
+}
+
+// restore sets the addresses published before a restart, so they are only
+// updated again once discovery finds something different.
+func (h *Hostname) restore(addrCollection *ipv6disc.AddrCollection, updatedTime time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.AddrCollection = *addrCollection
+	h.updatedTime = updatedTime
+	h.publishedCount = len(addrCollection.Get())
+}
+
+// debounce schedules an update once the changes settle for the debounce time,
+// but no later than the max delay after the first change still pending.
+func (h *Hostname) debounce() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.pendingSince.IsZero() {
+		h.pendingSince = time.Now()
+	}
+	timeout := min(h.credential.DebounceTime, time.Until(h.pendingSince.Add(h.credential.MaxDelay)))
+
+	h.schedule(max(timeout, 0), false)
 }
 
 func (h *Hostname) ScheduleUpdate(timeout time.Duration) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
+	h.schedule(timeout, false)
+}
+
+// schedule replaces the pending update, if any. The caller must hold the mutex.
+func (h *Hostname) schedule(timeout time.Duration, resync bool) {
+	if h.stopped {
+		return
+	}
+
 	// stop the current update timer if it exists
 	if h.nextUpdateTimer != nil {
 		h.nextUpdateTimer.Stop()
-		h.nextUpdateTime = time.Time{}
 	}
 
 	h.nextUpdateTimer = time.AfterFunc(timeout, h.update)
 	h.nextUpdateTime = time.Now().Add(timeout)
+	h.resyncPending = resync
 }
 
 func (h *Hostname) update() {
+	h.mutex.Lock()
+	if h.stopped {
+		h.mutex.Unlock()
+		return
+	}
+	if h.updateRunning {
+		// run again once the running one, maybe started by hand, finishes
+		h.rerun = true
+		h.mutex.Unlock()
+		return
+	}
+	h.run(h.resyncPending)
+}
+
+// UpdateNow runs an update right away, replacing the scheduled one, and
+// returns the result of the provider call. A resync compares the addresses
+// with the live records, as the periodic ones.
+func (h *Hostname) UpdateNow(resync bool) ([]ddns.Change, error) {
+	h.mutex.Lock()
+	// let the running update finish, so the provider is not called twice at once
+	for h.updateRunning {
+		done := h.updateDone
+		h.mutex.Unlock()
+		<-done
+		h.mutex.Lock()
+	}
+	if h.stopped {
+		h.mutex.Unlock()
+		return nil, errHostnameStopped
+	}
+	if h.nextUpdateTimer != nil {
+		h.nextUpdateTimer.Stop()
+	}
+	return h.run(resync)
+}
+
+// Reset clears the error and backoff of the failed updates, retrying right
+// away if a retry was scheduled or the retries had given up.
+func (h *Hostname) Reset() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	retry := h.updateFailed || (h.updateError != nil && !h.nextUpdateTime.IsZero())
+	h.updateError = nil
+	h.updateAttempts = 0
+	h.updateFailed = false
+	h.failingSince = time.Time{}
+	if retry && !h.updateRunning {
+		h.schedule(0, false)
+	}
+}
+
+// run updates the hostname, it must be called holding the mutex and returns
+// with it released.
+func (h *Hostname) run(resync bool) ([]ddns.Change, error) {
 	h.updateRunning = true
+	h.pendingSince = time.Time{}
+	h.nextUpdateTime = time.Time{}
+	h.resyncPending = false
+	h.updateDone = make(chan struct{})
+	// the addresses can be replaced while the update runs
+	addrCollection := ipv6disc.NewAddrCollection()
+	addrCollection.Join(&h.AddrCollection)
+	count := len(addrCollection.Get())
+	h.mutex.Unlock()
+
+	start := time.Now()
+	changes, err := h.updateAction(addrCollection, resync)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
 
-	h.updateError = h.updateAction(&h.AddrCollection)
-	if h.updateError == nil {
-		h.updatedTime = time.Now()
+	h.stats.record(time.Since(start), err)
+	h.updateError = err
+	if err == nil {
+		h.updateAttempts = 0
+		h.updateFailed = false
+		h.failingSince = time.Time{}
+		if h.credential.DryRun {
+			h.plannedChanges = changes
+			h.plannedTime = time.Now()
+		} else {
+			h.publishedCount = count
+			h.updatedTime = time.Now()
+			if resync {
+				h.resyncTime = h.updatedTime
+				if len(changes) > 0 {
+					h.driftTime = h.updatedTime
+				}
+			}
+		}
 	} else {
-		h.ScheduleUpdate(h.updateRetryInterval)
+		if h.failingSince.IsZero() {
+			h.failingSince = time.Now()
+		}
+		h.updateAttempts++
+		// give up retrying until the addresses change again
+		h.updateFailed = h.credential.Retry.MaxAttempts > 0 && h.updateAttempts >= h.credential.Retry.MaxAttempts
 	}
-
 	h.updateRunning = false
+	close(h.updateDone)
+
+	if h.rerun {
+		h.rerun = false
+		h.schedule(0, h.resyncPending)
+		return changes, err
+	}
+	// a change seen while updating has already scheduled the next update
+	if !h.nextUpdateTime.IsZero() {
+		return changes, err
+	}
+	if err != nil && !h.updateFailed {
+		h.schedule(h.credential.RetryInterval(h.updateAttempts, ddns.IsPermanent(err)), false)
+	}
+	if err == nil && start.Before(h.holdUntil) {
+		// delete the records held back once the warm-up is over
+		h.schedule(time.Until(h.holdUntil), false)
+		return changes, err
+	}
+	if err == nil && h.credential.ResyncInterval > 0 {
+		h.schedule(h.credential.ResyncInterval, true)
+	}
+
+	return changes, err
+}
+
+// Stop cancels the pending update, if any, and prevents new ones from being scheduled.
+func (h *Hostname) Stop() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.stopped = true
+	if h.nextUpdateTimer != nil {
+		h.nextUpdateTimer.Stop()
+	}
+	h.nextUpdateTime = time.Time{}
+}
+
+// Wait blocks until the running update, if any, has finished.
+func (h *Hostname) Wait() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for h.updateRunning {
+		done := h.updateDone
+		h.mutex.Unlock()
+		<-done
+		h.mutex.Lock()
+	}
 }
 
-func NewHostname(updateAction func(*ipv6disc.AddrCollection) error, updateDebounceTime time.Duration, updateRetryInterval time.Duration) *Hostname {
+func NewHostname(domain string, updateAction func(addrCollection *ipv6disc.AddrCollection, resync bool) ([]ddns.Change, error), credential config.Credential, holdUntil time.Time) *Hostname {
 	return &Hostname{
-		AddrCollection:      *ipv6disc.NewAddrCollection(),
-		updateAction:        updateAction,
-		updateDebounceTime:  updateDebounceTime,
-		updateRetryInterval: updateRetryInterval,
+		AddrCollection: *ipv6disc.NewAddrCollection(),
+		domain:         domain,
+		updateAction:   updateAction,
+		credential:     credential,
+		holdUntil:      holdUntil,
 	}
 }
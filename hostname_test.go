@@ -0,0 +1,104 @@
+package ipv6ddns
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+	"github.com/miguelangel-nubla/ipv6ddns/ddns"
+	"github.com/miguelangel-nubla/ipv6disc"
+)
+
+func newTestCollection(addrs ...*ipv6disc.Addr) *ipv6disc.AddrCollection {
+	addrCollection := ipv6disc.NewAddrCollection()
+	for _, addr := range addrs {
+		addrCollection.Add(addr)
+	}
+	return addrCollection
+}
+
+func newTestHostname(holdUntil time.Time, action func(*ipv6disc.AddrCollection, bool) ([]ddns.Change, error)) *Hostname {
+	if action == nil {
+		action = func(*ipv6disc.AddrCollection, bool) ([]ddns.Change, error) { return nil, nil }
+	}
+	return NewHostname("host.example.com", action, config.Credential{DebounceTime: time.Hour, MaxDelay: time.Hour}, holdUntil)
+}
+
+func TestHostnameSetAddrCollection(t *testing.T) {
+	a := newTestAddr("00:11:22:33:44:55", "2001:db8::1", time.Hour)
+	b := newTestAddr("00:11:22:33:44:66", "2001:db8::2", time.Hour)
+
+	t.Run("Replaces Addresses", func(t *testing.T) {
+		h := newTestHostname(time.Time{}, nil)
+		defer h.Stop()
+		h.restore(newTestCollection(a), time.Now())
+
+		h.SetAddrCollection(newTestCollection(b))
+		if got := h.Strings(); !slices.Equal(got, []string{"2001:db8::2"}) {
+			t.Errorf("addresses = %v, want only the new one", got)
+		}
+		if h.nextUpdateTime.IsZero() {
+			t.Errorf("no update was scheduled")
+		}
+	})
+
+	t.Run("Only Adds During Warm-up", func(t *testing.T) {
+		h := newTestHostname(time.Now().Add(3*time.Hour), nil)
+		defer h.Stop()
+		h.restore(newTestCollection(a), time.Now())
+
+		h.SetAddrCollection(newTestCollection(b))
+		if got := h.Strings(); !slices.Equal(got, []string{"2001:db8::1", "2001:db8::2"}) {
+			t.Errorf("addresses = %v, want the restored and the new one", got)
+		}
+		if !h.nextUpdateTime.Before(time.Now().Add(2 * time.Hour)) {
+			t.Errorf("update scheduled at %v, want the addition not held until the warm-up end", h.nextUpdateTime)
+		}
+
+		h.SetAddrCollection(ipv6disc.NewAddrCollection())
+		if got := h.Strings(); len(got) != 2 {
+			t.Errorf("addresses = %v, want the removal held", got)
+		}
+	})
+}
+
+func TestHostnameConcurrentUpdates(t *testing.T) {
+	var running, overlaps, calls atomic.Int32
+	h := newTestHostname(time.Time{}, func(*ipv6disc.AddrCollection, bool) ([]ddns.Change, error) {
+		if running.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		calls.Add(1)
+		time.Sleep(time.Millisecond)
+		running.Add(-1)
+		return nil, nil
+	})
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := h.UpdateNow(false); err != nil {
+				t.Errorf("UpdateNow() = %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			h.update()
+		}()
+	}
+	wg.Wait()
+	h.Stop()
+	h.Wait()
+
+	if got := overlaps.Load(); got > 0 {
+		t.Errorf("the provider was called %d times while another update was running", got)
+	}
+	if got := calls.Load(); got < 10 {
+		t.Errorf("the provider was called %d times, want at least one per UpdateNow", got)
+	}
+}
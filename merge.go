@@ -0,0 +1,42 @@
+package ipv6ddns
+
+import (
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+	"github.com/miguelangel-nubla/ipv6disc"
+)
+
+// hostnameRef identifies a hostname of an endpoint.
+type hostnameRef struct {
+	endpoint string
+	name     string
+}
+
+// desiredAddrs are the addresses a task wants published for a hostname, with
+// the options the hostname is configured or generated with.
+type desiredAddrs struct {
+	options        config.Hostname
+	addrCollection *ipv6disc.AddrCollection
+}
+
+// publishDesired sets on each of the given hostnames the union of the
+// addresses every task wants published for it, so the tasks sharing a
+// hostname add up instead of replacing each other. Hostnames no task wants
+// anymore are left to be torn down. The caller must hold configMutex.
+func (w *Worker) publishDesired(refs map[hostnameRef]bool) {
+	for ref := range refs {
+		var options config.Hostname
+		var wanted bool
+		union := ipv6disc.NewAddrCollection()
+		for _, desired := range w.desired {
+			if d, ok := desired[ref]; ok {
+				options = d.options
+				wanted = true
+				union.Join(d.addrCollection)
+			}
+		}
+
+		if wanted {
+			w.hostname(ref.endpoint, ref.name, options).SetAddrCollection(union)
+		}
+	}
+}
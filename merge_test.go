@@ -0,0 +1,46 @@
+package ipv6ddns
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+)
+
+func TestPublishDesired(t *testing.T) {
+	w := newTestWorker(t, &testService{})
+	shared := hostnameRef{endpoint: "endpoint", name: "shared"}
+	single := hostnameRef{endpoint: "endpoint", name: "single"}
+	unwanted := hostnameRef{endpoint: "endpoint", name: "unwanted"}
+
+	w.desired = map[string]map[hostnameRef]desiredAddrs{
+		"ipv4": {
+			shared: {config.Hostname{Name: "shared"}, newTestCollection(newTestAddr("00:11:22:33:44:55", "192.0.2.1", time.Hour))},
+		},
+		"ipv6": {
+			shared: {config.Hostname{Name: "shared"}, newTestCollection(newTestAddr("00:11:22:33:44:55", "2001:db8::1", time.Hour))},
+			single: {config.Hostname{Name: "single"}, newTestCollection(newTestAddr("00:11:22:33:44:66", "2001:db8::2", time.Hour))},
+		},
+	}
+
+	w.configMutex.RLock()
+	w.publishDesired(map[hostnameRef]bool{shared: true, single: true, unwanted: true})
+	w.configMutex.RUnlock()
+
+	published := make(map[string][]string)
+	for _, selected := range w.State.selectHostnames("endpoint", nil) {
+		published[selected.hostnameKey] = selected.Strings()
+		slices.Sort(published[selected.hostnameKey])
+	}
+
+	if got, want := published["shared"], []string{"192.0.2.1", "2001:db8::1"}; !slices.Equal(got, want) {
+		t.Errorf("addresses of shared = %v, want the union %v", got, want)
+	}
+	if got, want := published["single"], []string{"2001:db8::2"}; !slices.Equal(got, want) {
+		t.Errorf("addresses of single = %v, want %v", got, want)
+	}
+	if _, ok := published["unwanted"]; ok {
+		t.Errorf("hostname unwanted was created, want it left alone as no task wants it")
+	}
+}
@@ -0,0 +1,219 @@
+package ipv6ddns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6ddns/ddns"
+)
+
+// updateDurationBuckets are the upper bounds in seconds of the update latency histogram.
+var updateDurationBuckets = [...]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// updateStats are the counters of the updates of a hostname since it was created.
+type updateStats struct {
+	attempts  uint64
+	successes uint64
+	// failures by error class, see errorClass
+	failures map[string]uint64
+
+	// durationBuckets counts the updates that took at most each of updateDurationBuckets
+	durationBuckets [len(updateDurationBuckets)]uint64
+	durationSum     float64
+}
+
+func (s *updateStats) record(duration time.Duration, err error) {
+	s.attempts++
+	if err == nil {
+		s.successes++
+	} else {
+		if s.failures == nil {
+			s.failures = make(map[string]uint64)
+		}
+		s.failures[errorClass(err)]++
+	}
+
+	seconds := duration.Seconds()
+	s.durationSum += seconds
+	for i, bound := range updateDurationBuckets {
+		if seconds <= bound {
+			s.durationBuckets[i]++
+		}
+	}
+}
+
+// errorClass groups update errors by how they are handled: permanent errors
+// are retried at the slower pace, timeouts and cancellations are reported
+// apart from the temporary errors returned by the provider.
+func errorClass(err error) string {
+	switch {
+	case ddns.IsPermanent(err):
+		return "permanent"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "temporary"
+	}
+}
+
+// hostnameMetrics is a snapshot of the metrics of a hostname.
+type hostnameMetrics struct {
+	labels              string
+	stats               updateStats
+	published           int
+	updatedTime         time.Time
+	pending             bool
+	consecutiveFailures int
+	failingSince        time.Time
+}
+
+// metrics returns a snapshot of the metrics of every hostname, sorted by
+// provider, endpoint and hostname.
+func (s *State) metrics() []hostnameMetrics {
+	var result []hostnameMetrics
+
+	s.providersMutex.RLock()
+	defer s.providersMutex.RUnlock()
+	for _, providerKey := range sortedKeys(s.providers) {
+		provider := s.providers[providerKey]
+		provider.endpointsMutex.RLock()
+		for _, endpointKey := range sortedKeys(provider.endpoints) {
+			endpoint := provider.endpoints[endpointKey]
+			endpoint.hostnamesMutex.RLock()
+			for _, hostnameKey := range sortedKeys(endpoint.hostnames) {
+				hostname := endpoint.hostnames[hostnameKey]
+				hostname.mutex.RLock()
+				m := hostnameMetrics{
+					labels:              fmt.Sprintf(`provider="%s",endpoint="%s",hostname="%s"`, escapeLabel(providerKey), escapeLabel(endpointKey), escapeLabel(hostname.domain)),
+					stats:               hostname.stats,
+					published:           hostname.publishedCount,
+					updatedTime:         hostname.updatedTime,
+					pending:             hostname.updateRunning || (!hostname.nextUpdateTime.IsZero() && !hostname.resyncPending),
+					consecutiveFailures: hostname.updateAttempts,
+					failingSince:        hostname.failingSince,
+				}
+				m.stats.failures = make(map[string]uint64, len(hostname.stats.failures))
+				for class, count := range hostname.stats.failures {
+					m.stats.failures[class] = count
+				}
+				hostname.mutex.RUnlock()
+				result = append(result, m)
+			}
+			endpoint.hostnamesMutex.RUnlock()
+		}
+		provider.endpointsMutex.RUnlock()
+	}
+
+	return result
+}
+
+// WriteMetrics writes the metrics of every hostname and of discovery in the
+// Prometheus text exposition format.
+func (w *Worker) WriteMetrics(out io.Writer) {
+	hostnames := w.State.metrics()
+	now := time.Now()
+
+	writeFamily(out, "ipv6ddns_update_attempts_total", "counter", "Updates attempted through the provider.")
+	for _, m := range hostnames {
+		fmt.Fprintf(out, "ipv6ddns_update_attempts_total{%s} %d\n", m.labels, m.stats.attempts)
+	}
+
+	writeFamily(out, "ipv6ddns_update_successes_total", "counter", "Updates that succeeded.")
+	for _, m := range hostnames {
+		fmt.Fprintf(out, "ipv6ddns_update_successes_total{%s} %d\n", m.labels, m.stats.successes)
+	}
+
+	writeFamily(out, "ipv6ddns_update_failures_total", "counter", "Updates that failed, by error class: permanent, temporary, timeout or canceled.")
+	for _, m := range hostnames {
+		for _, class := range sortedKeys(m.stats.failures) {
+			fmt.Fprintf(out, "ipv6ddns_update_failures_total{%s,class=\"%s\"} %d\n", m.labels, class, m.stats.failures[class])
+		}
+	}
+
+	writeFamily(out, "ipv6ddns_update_duration_seconds", "histogram", "Time taken by the updates, including the reverse zones.")
+	for _, m := range hostnames {
+		for i, bound := range updateDurationBuckets {
+			fmt.Fprintf(out, "ipv6ddns_update_duration_seconds_bucket{%s,le=\"%s\"} %d\n", m.labels, formatFloat(bound), m.stats.durationBuckets[i])
+		}
+		fmt.Fprintf(out, "ipv6ddns_update_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", m.labels, m.stats.attempts)
+		fmt.Fprintf(out, "ipv6ddns_update_duration_seconds_sum{%s} %s\n", m.labels, formatFloat(m.stats.durationSum))
+		fmt.Fprintf(out, "ipv6ddns_update_duration_seconds_count{%s} %d\n", m.labels, m.stats.attempts)
+	}
+
+	writeFamily(out, "ipv6ddns_published_addresses", "gauge", "Addresses published by the last successful update.")
+	for _, m := range hostnames {
+		fmt.Fprintf(out, "ipv6ddns_published_addresses{%s} %d\n", m.labels, m.published)
+	}
+
+	writeFamily(out, "ipv6ddns_seconds_since_last_update", "gauge", "Seconds since the last successful update, absent if there was none.")
+	for _, m := range hostnames {
+		if !m.updatedTime.IsZero() {
+			fmt.Fprintf(out, "ipv6ddns_seconds_since_last_update{%s} %s\n", m.labels, formatFloat(now.Sub(m.updatedTime).Seconds()))
+		}
+	}
+
+	writeFamily(out, "ipv6ddns_update_pending", "gauge", "Whether an update of changed addresses, or a retry, is scheduled or running.")
+	for _, m := range hostnames {
+		pending := 0
+		if m.pending {
+			pending = 1
+		}
+		fmt.Fprintf(out, "ipv6ddns_update_pending{%s} %d\n", m.labels, pending)
+	}
+
+	writeFamily(out, "ipv6ddns_update_consecutive_failures", "gauge", "Updates failed since the last successful one.")
+	for _, m := range hostnames {
+		fmt.Fprintf(out, "ipv6ddns_update_consecutive_failures{%s} %d\n", m.labels, m.consecutiveFailures)
+	}
+
+	writeFamily(out, "ipv6ddns_update_failing_seconds", "gauge", "Seconds since the updates started failing, 0 if the last one succeeded.")
+	for _, m := range hostnames {
+		failing := 0.0
+		if !m.failingSince.IsZero() {
+			failing = now.Sub(m.failingSince).Seconds()
+		}
+		fmt.Fprintf(out, "ipv6ddns_update_failing_seconds{%s} %s\n", m.labels, formatFloat(failing))
+	}
+
+	sources := make(map[string]int)
+	for _, addr := range w.discovered() {
+		for _, source := range addr.Sources {
+			sources[source]++
+		}
+	}
+	writeFamily(out, "ipv6ddns_discovered_addresses", "gauge", "Valid addresses known by discovery, by the source they were seen over.")
+	for _, source := range sortedKeys(sources) {
+		fmt.Fprintf(out, "ipv6ddns_discovered_addresses{source=\"%s\"} %d\n", escapeLabel(source), sources[source])
+	}
+}
+
+func writeFamily(out io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,106 @@
+package ipv6ddns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6ddns/ddns"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"Permanent", ddns.Permanent(errors.New("rejected")), "permanent"},
+		{"Timeout", fmt.Errorf("update: %w", context.DeadlineExceeded), "timeout"},
+		{"Canceled", fmt.Errorf("update: %w", context.Canceled), "canceled"},
+		{"Temporary", errors.New("connection refused"), "temporary"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorClass(tt.err); got != tt.want {
+				t.Errorf("errorClass() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateStatsRecord(t *testing.T) {
+	var stats updateStats
+	stats.record(200*time.Millisecond, nil)
+	stats.record(3*time.Second, errors.New("failed"))
+	stats.record(time.Second, ddns.Permanent(errors.New("rejected")))
+
+	if stats.attempts != 3 || stats.successes != 1 {
+		t.Errorf("attempts = %d and successes = %d, want 3 and 1", stats.attempts, stats.successes)
+	}
+	if stats.failures["temporary"] != 1 || stats.failures["permanent"] != 1 {
+		t.Errorf("failures = %v, want one temporary and one permanent", stats.failures)
+	}
+	if stats.durationSum != 4.2 {
+		t.Errorf("durationSum = %v, want 4.2", stats.durationSum)
+	}
+
+	// the buckets are cumulative, as 0.1, 0.25, 0.5, 1, 2.5, 5, ...
+	want := [len(updateDurationBuckets)]uint64{0, 1, 1, 2, 2, 3, 3, 3, 3}
+	if stats.durationBuckets != want {
+		t.Errorf("durationBuckets = %v, want %v", stats.durationBuckets, want)
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	service := &testService{}
+	w := newTestWorker(t, service, "host")
+	hostname := w.State.selectHostnames("endpoint", nil)[0].Hostname
+	hostname.SetAddrCollection(newTestCollection(
+		newTestAddr("00:11:22:33:44:55", "2001:db8::1", time.Hour),
+		newTestAddr("00:11:22:33:44:55", "2001:db8::2", time.Hour),
+	))
+
+	if _, err := hostname.UpdateNow(false); err != nil {
+		t.Fatalf("UpdateNow() failed: %v", err)
+	}
+	service.fail(errors.New("failed"))
+	hostname.UpdateNow(false)
+
+	var out bytes.Buffer
+	w.WriteMetrics(&out)
+	metrics := out.String()
+
+	labels := `provider="test",endpoint="endpoint",hostname="host.example.com"`
+	for _, want := range []string{
+		"# TYPE ipv6ddns_update_attempts_total counter",
+		"ipv6ddns_update_attempts_total{" + labels + "} 2",
+		"ipv6ddns_update_successes_total{" + labels + "} 1",
+		"ipv6ddns_update_failures_total{" + labels + `,class="temporary"} 1`,
+		"ipv6ddns_update_duration_seconds_bucket{" + labels + `,le="+Inf"} 2`,
+		"ipv6ddns_update_duration_seconds_count{" + labels + "} 2",
+		"ipv6ddns_published_addresses{" + labels + "} 2",
+		"ipv6ddns_seconds_since_last_update{" + labels + "} ",
+		"ipv6ddns_update_pending{" + labels + "} 1",
+		"ipv6ddns_update_consecutive_failures{" + labels + "} 1",
+		"ipv6ddns_update_failing_seconds{" + labels + "} ",
+		"# TYPE ipv6ddns_discovered_addresses gauge",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics missing %q, got:\n%s", want, metrics)
+		}
+	}
+	if strings.Contains(metrics, "ipv6ddns_update_failing_seconds{"+labels+"} 0\n") {
+		t.Errorf("ipv6ddns_update_failing_seconds = 0, want the time since the failure")
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if got, want := escapeLabel("a\\b\"c\nd"), `a\\b\"c\nd`; got != want {
+		t.Errorf("escapeLabel() = %s, want %s", got, want)
+	}
+}
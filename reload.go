@@ -0,0 +1,208 @@
+package ipv6ddns
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+	"github.com/miguelangel-nubla/ipv6ddns/ddns"
+)
+
+// newServices creates the DNS service of every credential used by a task,
+// reusing the current services of the credentials unchanged since previous.
+func newServices(cfg config.Config, previous *config.Config, current map[string]ddns.Service) (map[string]ddns.Service, error) {
+	services := make(map[string]ddns.Service)
+	for endpointKey := range cfg.Hostnames() {
+		credential := cfg.Credentials[endpointKey]
+
+		if previous != nil {
+			if old, ok := previous.Credentials[endpointKey]; ok && reflect.DeepEqual(old, credential) {
+				if service, ok := current[endpointKey]; ok {
+					services[endpointKey] = service
+					continue
+				}
+			}
+		}
+
+		service, err := ddns.NewService(credential.Provider, credential.RawSettings)
+		if err != nil {
+			return nil, fmt.Errorf("error creating DNS service for endpoint %s: %w", endpointKey, err)
+		}
+		services[endpointKey] = service
+	}
+
+	for endpointKey, service := range services {
+		if _, ok := service.(ddns.ReverseService); !ok && cfg.UsesReverseZones(endpointKey) {
+			return nil, fmt.Errorf("endpoint %s uses reverse zones, not supported by provider %s", endpointKey, cfg.Credentials[endpointKey].Provider)
+		}
+	}
+
+	return services, nil
+}
+
+// newHostnameServices creates a DNS service for every hostname, or template,
+// overriding the settings of its credential, by endpoint and hostname key.
+func newHostnameServices(cfg config.Config) (map[string]map[string]ddns.Service, error) {
+	services := make(map[string]map[string]ddns.Service)
+	for _, task := range cfg.Tasks {
+		for endpointKey, hostnames := range task.Endpoints {
+			credential := cfg.Credentials[endpointKey]
+			for _, hostname := range hostnames {
+				hostnameKey := hostname.Key()
+				if _, ok := services[endpointKey][hostnameKey]; ok || !hostname.Overrides() {
+					continue
+				}
+
+				settings, err := hostname.ProviderSettings(credential.RawSettings)
+				if err != nil {
+					return nil, fmt.Errorf("error merging settings of endpoint %s hostname %s: %w", endpointKey, hostnameKey, err)
+				}
+				service, err := ddns.NewService(credential.Provider, settings)
+				if err != nil {
+					return nil, fmt.Errorf("error creating DNS service for endpoint %s hostname %s: %w", endpointKey, hostnameKey, err)
+				}
+
+				if services[endpointKey] == nil {
+					services[endpointKey] = make(map[string]ddns.Service)
+				}
+				services[endpointKey][hostnameKey] = service
+			}
+		}
+	}
+
+	return services, nil
+}
+
+// Reload applies newConfig to the running worker. The services of new and
+// changed credentials are created, and the IPv4 handlers of new and changed
+// tasks started, before touching the running state, so an invalid
+// configuration is rejected and the current one keeps running.
+// Removed hostnames and endpoints are torn down, new ones are created on the
+// next scan and the discovery state is preserved.
+func (w *Worker) Reload(newConfig config.Config) error {
+	w.reloadMutex.Lock()
+	defer w.reloadMutex.Unlock()
+
+	w.configMutex.RLock()
+	oldConfig := w.config
+	services, err := newServices(newConfig, &oldConfig, w.services)
+	w.configMutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	hostnameServices, err := newHostnameServices(newConfig)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(oldConfig.SharedHostnames(), newConfig.SharedHostnames()) {
+		logSharedHostnames(w.logger, newConfig)
+	}
+	if warnings := newConfig.Warnings(); !slices.Equal(oldConfig.Warnings(), warnings) {
+		for _, warning := range warnings {
+			w.logger.Warn(warning)
+		}
+	}
+	if !reflect.DeepEqual(oldConfig.Discovery, newConfig.Discovery) {
+		w.logger.Warnf("discovery settings changed, restart to apply them")
+	}
+	if oldConfig.StateFilePath() != newConfig.StateFilePath() {
+		w.logger.Warnf("state file changed, restart to apply it")
+	}
+
+	// Keep the running IPv4 handlers, and the addresses they collected, for
+	// the tasks whose handler settings did not change.
+	for taskName, task := range newConfig.Tasks {
+		if old, ok := oldConfig.Tasks[taskName]; ok && old.IPv4 != nil && old.IPv4.SameSettings(task.IPv4) {
+			task.IPv4 = old.IPv4
+			newConfig.Tasks[taskName] = task
+		}
+	}
+
+	// Start the handlers of the new and changed tasks before committing, the
+	// reload is rejected and the current handlers keep running if one fails.
+	var started []*config.IPv4Handler
+	for taskName, task := range newConfig.Tasks {
+		if task.IPv4 == nil || task.IPv4.Running() {
+			continue
+		}
+		if err := task.IPv4.Start(newConfig.BaseDir, w.logger, w.Notify); err != nil {
+			for _, handler := range started {
+				handler.Stop()
+			}
+			return fmt.Errorf("error starting IPv4 handler for task %s: %w", taskName, err)
+		}
+		started = append(started, task.IPv4)
+	}
+
+	w.configMutex.Lock()
+
+	newHostnames := newConfig.Hostnames()
+	for endpointKey, hostnames := range oldConfig.Hostnames() {
+		providerKey := oldConfig.Credentials[endpointKey].Provider
+
+		// removed or changed credential, the endpoint is recreated with the new service if still used
+		if services[endpointKey] != w.services[endpointKey] {
+			w.logger.Infof("endpoint %s removed or changed, tearing it down", endpointKey)
+			w.State.removeEndpoint(providerKey, endpointKey)
+			continue
+		}
+
+		for hostnameKey := range hostnames {
+			if !newHostnames[endpointKey][hostnameKey] {
+				w.logger.Infof("endpoint %s hostname %s removed, tearing it down", endpointKey, hostnameKey)
+				w.State.removeHostname(providerKey, endpointKey, hostnameKey)
+				continue
+			}
+
+			// changed options, the hostname is recreated with them on the next scan
+			oldHostname, _ := oldConfig.Hostname(endpointKey, hostnameKey)
+			newHostname, _ := newConfig.Hostname(endpointKey, hostnameKey)
+			if !reflect.DeepEqual(oldHostname, newHostname) || !slices.Equal(oldConfig.ReverseZones(endpointKey, hostnameKey), newConfig.ReverseZones(endpointKey, hostnameKey)) {
+				w.logger.Infof("endpoint %s hostname %s changed, tearing it down", endpointKey, hostnameKey)
+				w.State.removeHostname(providerKey, endpointKey, hostnameKey)
+			}
+		}
+	}
+
+	// removed or changed templates, their hostnames are generated again with the new options on the next scan
+	removedGenerated := make(map[string][]string)
+	w.generatedMutex.Lock()
+	for key, generated := range w.generated {
+		oldHostname, _ := key.options(oldConfig)
+		sameZones := slices.Equal(oldConfig.ReverseZones(key.endpoint, key.template), newConfig.ReverseZones(key.endpoint, key.template))
+		if newHostname, ok := key.options(newConfig); ok && reflect.DeepEqual(oldHostname, newHostname) && sameZones {
+			continue
+		}
+		for name := range generated.names {
+			removedGenerated[key.endpoint] = append(removedGenerated[key.endpoint], name)
+		}
+		delete(w.generated, key)
+	}
+	w.generatedMutex.Unlock()
+	for endpointKey, names := range removedGenerated {
+		w.removeGenerated(newConfig, endpointKey, names)
+	}
+
+	for taskName, old := range oldConfig.Tasks {
+		if old.IPv4 == nil {
+			continue
+		}
+		if task, ok := newConfig.Tasks[taskName]; !ok || task.IPv4 != old.IPv4 {
+			old.IPv4.Stop()
+			w.changes.forgetTask(taskName)
+		}
+	}
+
+	w.config = newConfig
+	w.services = services
+	w.hostnameServices = hostnameServices
+
+	w.configMutex.Unlock()
+
+	w.fullScan.Store(true)
+	w.Notify()
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package ipv6ddns
+
+import (
+	"bytes"
+	"net"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+	"github.com/miguelangel-nubla/ipv6ddns/pkg/filter"
+	"github.com/miguelangel-nubla/ipv6disc"
+)
+
+// publishedTypes returns the addresses of the record types published.
+func publishedTypes(recordTypes config.RecordTypes, addrCollection *ipv6disc.AddrCollection) *ipv6disc.AddrCollection {
+	if len(recordTypes) == 0 {
+		return addrCollection
+	}
+
+	result := ipv6disc.NewAddrCollection()
+	if recordTypes.Publishes(config.RecordTypeA) {
+		result.Join(addrCollection.Filter4())
+	}
+	if recordTypes.Publishes(config.RecordTypeAAAA) {
+		result.Join(addrCollection.Filter6())
+	}
+	return result
+}
+
+// withMACs returns the addresses of the devices with the given MAC addresses.
+func withMACs(macs []net.HardwareAddr, addrCollection *ipv6disc.AddrCollection) *ipv6disc.AddrCollection {
+	result := ipv6disc.NewAddrCollection()
+	for _, addr := range addrCollection.Get() {
+		if slices.ContainsFunc(macs, func(mac net.HardwareAddr) bool { return bytes.Equal(mac, addr.Hw) }) {
+			result.Add(addr)
+		}
+	}
+	return result
+}
+
+// selectAddrs applies the selection of a task to its addresses. firstSeen
+// returns since when an address is seen, the zero time if unknown.
+func selectAddrs(selection config.Selection, addrCollection *ipv6disc.AddrCollection, firstSeen func(*ipv6disc.Addr) time.Time) *ipv6disc.AddrCollection {
+	if selection.MaxA == 0 && selection.MaxAAAA == 0 && selection.MaxPerMAC == 0 {
+		return addrCollection
+	}
+
+	addrs := addrCollection.Get()
+	sort.SliceStable(addrs, func(i, j int) bool {
+		for _, prefer := range selection.Prefer {
+			switch prefer {
+			case config.PreferEUI64:
+				a, b := isEUI64(addrs[i]), isEUI64(addrs[j])
+				if a != b {
+					return a
+				}
+			case config.PreferNewest, config.PreferOldest:
+				a, b := firstSeen(addrs[i]), firstSeen(addrs[j])
+				if !a.Equal(b) {
+					return a.After(b) == (prefer == config.PreferNewest)
+				}
+			}
+		}
+		return false
+	})
+
+	selected := ipv6disc.NewAddrCollection()
+	var countA, countAAAA int
+	perMAC := make(map[string]int)
+	for _, addr := range addrs {
+		macKey := addr.Hw.String()
+		if addr.Is4() {
+			if selection.MaxA > 0 && countA >= selection.MaxA {
+				continue
+			}
+			macKey += "|4"
+		} else {
+			if selection.MaxAAAA > 0 && countAAAA >= selection.MaxAAAA {
+				continue
+			}
+			macKey += "|6"
+		}
+		if selection.MaxPerMAC > 0 && perMAC[macKey] >= selection.MaxPerMAC {
+			continue
+		}
+
+		if addr.Is4() {
+			countA++
+		} else {
+			countAAAA++
+		}
+		perMAC[macKey]++
+		selected.Add(addr)
+	}
+
+	return selected
+}
+
+func isEUI64(addr *ipv6disc.Addr) bool {
+	return addr.Is6() && filter.CheckIPType(addr, []string{"eui64"})
+}
@@ -0,0 +1,85 @@
+package ipv6ddns
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+	"github.com/miguelangel-nubla/ipv6disc"
+)
+
+func TestSelectAddrs(t *testing.T) {
+	now := time.Now()
+	// eui64 is derived from its MAC address
+	eui64 := newTestAddr("00:11:22:33:44:55", "2001:db8::211:22ff:fe33:4455", time.Hour)
+	temporary := newTestAddr("00:11:22:33:44:55", "2001:db8::1234", 2*time.Hour)
+	otherDevice := newTestAddr("00:11:22:33:44:66", "2001:db8::5678", 3*time.Hour)
+	ipv4 := newTestAddr("00:11:22:33:44:55", "192.0.2.1", time.Hour)
+	otherIPv4 := newTestAddr("00:11:22:33:44:66", "192.0.2.2", time.Hour)
+
+	firstSeen := map[*ipv6disc.Addr]time.Time{
+		eui64:       now.Add(-3 * time.Hour),
+		temporary:   now.Add(-1 * time.Hour),
+		otherDevice: now.Add(-2 * time.Hour),
+		ipv4:        now.Add(-2 * time.Hour),
+		otherIPv4:   now.Add(-1 * time.Hour),
+	}
+	seen := func(addr *ipv6disc.Addr) time.Time { return firstSeen[addr] }
+	all := newTestCollection(eui64, temporary, otherDevice, ipv4, otherIPv4)
+
+	tests := []struct {
+		name      string
+		selection config.Selection
+		want      []string
+	}{
+		{"No Limits", config.Selection{Prefer: []string{config.PreferNewest}}, all.Strings()},
+		{"Max AAAA Prefer EUI64", config.Selection{Prefer: []string{config.PreferEUI64}, MaxAAAA: 1}, []string{"192.0.2.1", "192.0.2.2", "2001:db8::211:22ff:fe33:4455"}},
+		{"Max AAAA Prefer Newest", config.Selection{Prefer: []string{config.PreferNewest}, MaxAAAA: 1}, []string{"192.0.2.1", "192.0.2.2", "2001:db8::1234"}},
+		{"Max AAAA Prefer Oldest", config.Selection{Prefer: []string{config.PreferOldest}, MaxAAAA: 2}, []string{"192.0.2.1", "192.0.2.2", "2001:db8::211:22ff:fe33:4455", "2001:db8::5678"}},
+		{"Max A Prefer Newest", config.Selection{Prefer: []string{config.PreferNewest}, MaxA: 1}, []string{"192.0.2.2", "2001:db8::1234", "2001:db8::211:22ff:fe33:4455", "2001:db8::5678"}},
+		{"Max A Prefer Oldest", config.Selection{Prefer: []string{config.PreferOldest}, MaxA: 1}, []string{"192.0.2.1", "2001:db8::1234", "2001:db8::211:22ff:fe33:4455", "2001:db8::5678"}},
+		{"Max Per MAC Prefer EUI64", config.Selection{Prefer: []string{config.PreferEUI64}, MaxPerMAC: 1}, []string{"192.0.2.1", "192.0.2.2", "2001:db8::211:22ff:fe33:4455", "2001:db8::5678"}},
+		{"Max Per MAC Prefer Newest", config.Selection{Prefer: []string{config.PreferNewest}, MaxPerMAC: 1}, []string{"192.0.2.1", "192.0.2.2", "2001:db8::1234", "2001:db8::5678"}},
+		{"Preferences In Order", config.Selection{Prefer: []string{config.PreferEUI64, config.PreferNewest}, MaxAAAA: 2}, []string{"192.0.2.1", "192.0.2.2", "2001:db8::211:22ff:fe33:4455", "2001:db8::1234"}},
+		{"All Limits", config.Selection{Prefer: []string{config.PreferOldest}, MaxA: 1, MaxAAAA: 1, MaxPerMAC: 1}, []string{"192.0.2.1", "2001:db8::211:22ff:fe33:4455"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := selectAddrs(tt.selection, all, seen).Strings()
+			slices.Sort(got)
+			want := slices.Sorted(slices.Values(tt.want))
+			if !slices.Equal(got, want) {
+				t.Errorf("selectAddrs() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPublishedTypes(t *testing.T) {
+	addrCollection := newTestCollection(
+		newTestAddr("00:11:22:33:44:55", "2001:db8::1", time.Hour),
+		newTestAddr("00:11:22:33:44:55", "192.0.2.1", time.Hour),
+	)
+
+	tests := []struct {
+		name        string
+		recordTypes config.RecordTypes
+		want        []string
+	}{
+		{"Both", nil, []string{"192.0.2.1", "2001:db8::1"}},
+		{"A", config.RecordTypes{config.RecordTypeA}, []string{"192.0.2.1"}},
+		{"AAAA", config.RecordTypes{config.RecordTypeAAAA}, []string{"2001:db8::1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := publishedTypes(tt.recordTypes, addrCollection).Strings()
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("publishedTypes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -51,8 +51,8 @@ func (s *State) PrettyPrint(prefix string, hideSensible bool) string {
 			sort.Strings(hostnamesKeys)
 
 			for _, hostnameKey := range hostnamesKeys {
-				fmt.Fprintf(&result, "%s            %s:", prefix, endpoint.Domain(hostnameKey))
 				hostname := endpoint.hostnames[hostnameKey]
+				fmt.Fprintf(&result, "%s            %s:", prefix, hostname.domain)
 				hostname.mutex.RLock()
 
 				if hostname.updateRunning {
@@ -64,6 +64,15 @@ func (s *State) PrettyPrint(prefix string, hideSensible bool) string {
 				if !hostname.updatedTime.IsZero() {
 					fmt.Fprintf(&result, " (last update: %s)", hostname.updatedTime.Format(time.RFC3339))
 				}
+				if !hostname.resyncTime.IsZero() {
+					fmt.Fprintf(&result, " (last resync: %s)", hostname.resyncTime.Format(time.RFC3339))
+				}
+				if !hostname.driftTime.IsZero() {
+					fmt.Fprintf(&result, " (drift corrected: %s)", hostname.driftTime.Format(time.RFC3339))
+				}
+				if !hostname.plannedTime.IsZero() {
+					fmt.Fprintf(&result, " (last dry run: %s)", hostname.plannedTime.Format(time.RFC3339))
+				}
 				if hostname.updateError != nil {
 					err := hostname.updateError
 					if hideSensible {
@@ -71,6 +80,11 @@ func (s *State) PrettyPrint(prefix string, hideSensible bool) string {
 					}
 					fmt.Fprintf(&result, " (last update error: %s)", err)
 				}
+				if hostname.updateFailed {
+					fmt.Fprintf(&result, " (failed after %d attempts)", hostname.updateAttempts)
+				} else if hostname.updateAttempts > 0 {
+					fmt.Fprintf(&result, " (failed attempts: %d)", hostname.updateAttempts)
+				}
 
 				var lastIp string
 				var lastHw string
@@ -97,6 +111,10 @@ func (s *State) PrettyPrint(prefix string, hideSensible bool) string {
 					}
 				}
 
+				for _, change := range hostname.plannedChanges {
+					fmt.Fprintf(&result, "\n%s                would %s", prefix, change)
+				}
+
 				fmt.Fprint(&result, "\n")
 
 				hostname.mutex.RUnlock()
@@ -111,6 +129,90 @@ func (s *State) PrettyPrint(prefix string, hideSensible bool) string {
 	return result.String()
 }
 
+// hostnames returns every Hostname of every endpoint of every provider.
+func (s *State) hostnames() []*Hostname {
+	var result []*Hostname
+
+	s.providersMutex.RLock()
+	defer s.providersMutex.RUnlock()
+	for _, provider := range s.providers {
+		provider.endpointsMutex.RLock()
+		for _, endpoint := range provider.endpoints {
+			endpoint.hostnamesMutex.RLock()
+			for _, hostname := range endpoint.hostnames {
+				result = append(result, hostname)
+			}
+			endpoint.hostnamesMutex.RUnlock()
+		}
+		provider.endpointsMutex.RUnlock()
+	}
+
+	return result
+}
+
+// removeHostname stops and removes a hostname, removing its endpoint and
+// provider as well when they are left empty.
+func (s *State) removeHostname(providerKey string, endpointKey string, hostnameKey string) {
+	s.providersMutex.Lock()
+	defer s.providersMutex.Unlock()
+
+	provider, ok := s.providers[providerKey]
+	if !ok {
+		return
+	}
+
+	provider.endpointsMutex.Lock()
+	defer provider.endpointsMutex.Unlock()
+
+	endpoint, ok := provider.endpoints[endpointKey]
+	if !ok {
+		return
+	}
+
+	endpoint.hostnamesMutex.Lock()
+	if hostname, ok := endpoint.hostnames[hostnameKey]; ok {
+		hostname.Stop()
+		delete(endpoint.hostnames, hostnameKey)
+	}
+	empty := len(endpoint.hostnames) == 0
+	endpoint.hostnamesMutex.Unlock()
+
+	if empty {
+		delete(provider.endpoints, endpointKey)
+	}
+	if len(provider.endpoints) == 0 {
+		delete(s.providers, providerKey)
+	}
+}
+
+// removeEndpoint stops and removes every hostname of an endpoint and the
+// endpoint itself, removing its provider as well when it is left empty.
+func (s *State) removeEndpoint(providerKey string, endpointKey string) {
+	s.providersMutex.Lock()
+	defer s.providersMutex.Unlock()
+
+	provider, ok := s.providers[providerKey]
+	if !ok {
+		return
+	}
+
+	provider.endpointsMutex.Lock()
+	defer provider.endpointsMutex.Unlock()
+
+	if endpoint, ok := provider.endpoints[endpointKey]; ok {
+		endpoint.hostnamesMutex.Lock()
+		for _, hostname := range endpoint.hostnames {
+			hostname.Stop()
+		}
+		endpoint.hostnamesMutex.Unlock()
+		delete(provider.endpoints, endpointKey)
+	}
+
+	if len(provider.endpoints) == 0 {
+		delete(s.providers, providerKey)
+	}
+}
+
 func NewState() *State {
 	return &State{
 		providers: make(map[string]*Provider),
@@ -0,0 +1,257 @@
+package ipv6ddns
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6disc"
+)
+
+// Store persists the hostnames published on each endpoint and their addresses,
+// so they survive restarts and the records of hostnames removed from the
+// configuration can still be found. Without a path it is only kept in memory.
+type Store struct {
+	mutex sync.Mutex
+	path  string
+	// dirty is set when the contents changed since they were last saved
+	dirty     bool
+	Endpoints map[string]map[string]*StoredHostname `json:"endpoints"`
+}
+
+type StoredHostname struct {
+	// Addresses are the ones last published successfully, UpdatedTime is
+	// when they were first published.
+	Addresses   []StoredAddress `json:"addresses"`
+	UpdatedTime time.Time       `json:"updated_time"`
+	StoredOrigin
+
+	// OrphanedSince is when the hostname was first found missing from the configuration.
+	OrphanedSince time.Time `json:"orphaned_since"`
+}
+
+// StoredOrigin is how a hostname was published, so its records can be removed
+// the same way once it is no longer configured.
+type StoredOrigin struct {
+	// Options is the key of the configured hostname, the template for the generated ones.
+	Options string `json:"options,omitempty"`
+	// Overrides are the provider settings overridden by the hostname.
+	Overrides    json.RawMessage `json:"overrides,omitempty"`
+	ReverseZones []string        `json:"reverse_zones,omitempty"`
+}
+
+type StoredAddress struct {
+	IP      string   `json:"ip"`
+	Hw      string   `json:"hw"`
+	Sources []string `json:"sources"`
+}
+
+type orphan struct {
+	endpointKey   string
+	hostnameKey   string
+	orphanedSince time.Time
+	origin        StoredOrigin
+}
+
+// published records the addresses successfully published for the hostname on
+// the endpoint, unless they are the ones already recorded.
+func (s *Store) published(endpointKey string, hostnameKey string, origin StoredOrigin, addrCollection *ipv6disc.AddrCollection, updatedTime time.Time) {
+	addresses := make([]StoredAddress, 0)
+	for _, addr := range addrCollection.Get() {
+		addresses = append(addresses, StoredAddress{
+			IP:      addr.WithZone("").String(),
+			Hw:      addr.Hw.String(),
+			Sources: addr.Sources,
+		})
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stored, ok := s.Endpoints[endpointKey][hostnameKey]; ok && stored.OrphanedSince.IsZero() &&
+		reflect.DeepEqual(stored.Addresses, addresses) && reflect.DeepEqual(stored.StoredOrigin, origin) {
+		return
+	}
+
+	if s.Endpoints[endpointKey] == nil {
+		s.Endpoints[endpointKey] = make(map[string]*StoredHostname)
+	}
+	s.dirty = true
+	s.Endpoints[endpointKey][hostnameKey] = &StoredHostname{
+		Addresses:    addresses,
+		UpdatedTime:  updatedTime,
+		StoredOrigin: origin,
+	}
+}
+
+// restore returns the addresses last published for the hostname on the
+// endpoint as seen now, valid for lifetime, and when they were published.
+func (s *Store) restore(endpointKey string, hostnameKey string, lifetime time.Duration) (*ipv6disc.AddrCollection, time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, ok := s.Endpoints[endpointKey][hostnameKey]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+
+	addrCollection := ipv6disc.NewAddrCollection()
+	for _, address := range stored.Addresses {
+		ip, err := netip.ParseAddr(address.IP)
+		if err != nil {
+			continue
+		}
+		hw, err := net.ParseMAC(address.Hw)
+		if err != nil {
+			hw = net.HardwareAddr{0, 0, 0, 0, 0, 0}
+		}
+		sources := address.Sources
+		if len(sources) == 0 {
+			sources = []string{"state"}
+		}
+
+		addr := ipv6disc.NewAddr(hw, ip, sources[0], lifetime, nil)
+		for _, source := range sources[1:] {
+			addr.Seen(source)
+		}
+		addrCollection.Add(addr)
+	}
+
+	return addrCollection, stored.UpdatedTime, true
+}
+
+// forget removes the hostname once its records are gone from the endpoint.
+func (s *Store) forget(endpointKey string, hostnameKey string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.Endpoints[endpointKey][hostnameKey]; !ok {
+		return
+	}
+	s.dirty = true
+	delete(s.Endpoints[endpointKey], hostnameKey)
+	if len(s.Endpoints[endpointKey]) == 0 {
+		delete(s.Endpoints, endpointKey)
+	}
+}
+
+// orphans returns the known hostnames missing from configured, marking when
+// they were first found missing and clearing the mark of the ones configured
+// again. changed reports whether any mark was set or cleared.
+func (s *Store) orphans(configured map[string]map[string]bool) (result []orphan, changed bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for endpointKey, hostnames := range s.Endpoints {
+		for hostnameKey, stored := range hostnames {
+			if configured[endpointKey][hostnameKey] {
+				if !stored.OrphanedSince.IsZero() {
+					stored.OrphanedSince = time.Time{}
+					changed = true
+				}
+				continue
+			}
+
+			if stored.OrphanedSince.IsZero() {
+				stored.OrphanedSince = time.Now()
+				changed = true
+			}
+			result = append(result, orphan{
+				endpointKey:   endpointKey,
+				hostnameKey:   hostnameKey,
+				orphanedSince: stored.OrphanedSince,
+				origin:        stored.StoredOrigin,
+			})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].endpointKey != result[j].endpointKey {
+			return result[i].endpointKey < result[j].endpointKey
+		}
+		return result[i].hostnameKey < result[j].hostnameKey
+	})
+
+	s.dirty = s.dirty || changed
+	return result, changed
+}
+
+// Save writes the store to disk if it changed since it was last saved,
+// replacing the previous file atomically. It does nothing without a path.
+func (s *Store) Save() error {
+	s.mutex.Lock()
+	if s.path == "" || !s.dirty {
+		s.mutex.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "    ")
+	s.dirty = false
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = s.write(data)
+	if err != nil {
+		// try again on the next save
+		s.mutex.Lock()
+		s.dirty = true
+		s.mutex.Unlock()
+	}
+	return err
+}
+
+func (s *Store) write(data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// LoadStore reads the store from path, a missing file or empty path results
+// in an empty store.
+func LoadStore(path string) (*Store, error) {
+	store := &Store{
+		path:      path,
+		Endpoints: make(map[string]map[string]*StoredHostname),
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, store)
+	if err != nil {
+		return nil, err
+	}
+	if store.Endpoints == nil {
+		store.Endpoints = make(map[string]map[string]*StoredHostname)
+	}
+
+	return store, nil
+}
@@ -0,0 +1,108 @@
+package ipv6ddns
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	store, err := LoadStore(path)
+	if err != nil {
+		t.Fatalf("LoadStore() failed for a missing file: %v", err)
+	}
+
+	updatedTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	origin := StoredOrigin{Options: "host", ReverseZones: []string{"8.b.d.0.1.0.0.2.ip6.arpa"}}
+	addr := newTestAddr("00:11:22:33:44:55", "2001:db8::1", time.Hour)
+	addr.Seen("plugin")
+	store.published("endpoint", "host", origin, newTestCollection(addr), updatedTime)
+	if err := store.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	loaded, err := LoadStore(path)
+	if err != nil {
+		t.Fatalf("LoadStore() failed: %v", err)
+	}
+	addrCollection, restoredTime, ok := loaded.restore("endpoint", "host", time.Hour)
+	if !ok {
+		t.Fatalf("restore() found nothing, want the saved hostname")
+	}
+	if got := addrCollection.Strings(); !slices.Equal(got, []string{"2001:db8::1"}) {
+		t.Errorf("restored addresses = %v, want 2001:db8::1", got)
+	}
+	restored := addrCollection.Get()[0]
+	if restored.Hw.String() != "00:11:22:33:44:55" || !slices.Equal(restored.Sources, []string{"ndp", "plugin"}) {
+		t.Errorf("restored address = %s %v, want its MAC and sources", restored.Hw, restored.Sources)
+	}
+	if !restoredTime.Equal(updatedTime) {
+		t.Errorf("restored time = %v, want %v", restoredTime, updatedTime)
+	}
+	if got := loaded.Endpoints["endpoint"]["host"].StoredOrigin; got.Options != origin.Options || !slices.Equal(got.ReverseZones, origin.ReverseZones) {
+		t.Errorf("restored origin = %+v, want %+v", got, origin)
+	}
+	if _, _, ok := loaded.restore("endpoint", "other", time.Hour); ok {
+		t.Errorf("restore() found a hostname never saved")
+	}
+}
+
+func TestStoreSaveOnlyChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, _ := LoadStore(path)
+	addrCollection := newTestCollection(newTestAddr("00:11:22:33:44:55", "2001:db8::1", time.Hour))
+
+	store.published("endpoint", "host", StoredOrigin{Options: "host"}, addrCollection, time.Now())
+	if err := store.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	// the same addresses again leave nothing to save
+	os.Remove(path)
+	store.published("endpoint", "host", StoredOrigin{Options: "host"}, addrCollection, time.Now())
+	store.Save()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Save() wrote the file without changes")
+	}
+
+	store.forget("endpoint", "host")
+	store.Save()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Save() did not write the forgotten hostname: %v", err)
+	}
+
+	memory, _ := LoadStore("")
+	memory.published("endpoint", "host", StoredOrigin{Options: "host"}, addrCollection, time.Now())
+	if err := memory.Save(); err != nil {
+		t.Errorf("Save() = %v without a path, want nothing done", err)
+	}
+}
+
+func TestStoreOrphans(t *testing.T) {
+	store, _ := LoadStore("")
+	addrCollection := newTestCollection(newTestAddr("00:11:22:33:44:55", "2001:db8::1", time.Hour))
+	store.published("endpoint", "host", StoredOrigin{Options: "host"}, addrCollection, time.Now())
+	store.published("endpoint", "removed", StoredOrigin{Options: "removed"}, addrCollection, time.Now())
+
+	configured := map[string]map[string]bool{"endpoint": {"host": true}}
+	orphans, changed := store.orphans(configured)
+	if len(orphans) != 1 || orphans[0].hostnameKey != "removed" || orphans[0].origin.Options != "removed" || !changed {
+		t.Fatalf("orphans() = %+v, %v, want removed newly marked", orphans, changed)
+	}
+	orphanedSince := orphans[0].orphanedSince
+
+	orphans, changed = store.orphans(configured)
+	if len(orphans) != 1 || !orphans[0].orphanedSince.Equal(orphanedSince) || changed {
+		t.Errorf("orphans() = %+v, %v, want removed still marked since %v", orphans, changed, orphanedSince)
+	}
+
+	configured["endpoint"]["removed"] = true
+	orphans, changed = store.orphans(configured)
+	if len(orphans) != 0 || !changed {
+		t.Errorf("orphans() = %+v, %v, want the mark cleared once configured again", orphans, changed)
+	}
+}
@@ -0,0 +1,129 @@
+package ipv6ddns
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ErrNoHostnames is returned when no hostname matches the ones to update or reset.
+var ErrNoHostnames = errors.New("no matching hostnames")
+
+// UpdateResult is the outcome of an update of a hostname triggered by hand.
+type UpdateResult struct {
+	Endpoint string   `json:"endpoint"`
+	Hostname string   `json:"hostname"`
+	Domain   string   `json:"domain"`
+	Changes  []string `json:"changes"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// selectedHostname is a hostname picked by selectHostnames.
+type selectedHostname struct {
+	endpointKey string
+	hostnameKey string
+	*Hostname
+}
+
+// selectHostnames returns the hostnames of the endpoint, only the one with
+// hostnameKey if set, or every hostname if endpointKey is empty.
+func (s *State) selectHostnames(endpointKey string, hostnameKey *string) []selectedHostname {
+	var result []selectedHostname
+
+	s.providersMutex.RLock()
+	defer s.providersMutex.RUnlock()
+	for _, provider := range s.providers {
+		provider.endpointsMutex.RLock()
+		for key, endpoint := range provider.endpoints {
+			if endpointKey != "" && key != endpointKey {
+				continue
+			}
+			endpoint.hostnamesMutex.RLock()
+			for name, hostname := range endpoint.hostnames {
+				if hostnameKey == nil || name == *hostnameKey {
+					result = append(result, selectedHostname{key, name, hostname})
+				}
+			}
+			endpoint.hostnamesMutex.RUnlock()
+		}
+		provider.endpointsMutex.RUnlock()
+	}
+
+	return result
+}
+
+// UpdateNow updates right away, bypassing the debounce and any backoff, the
+// hostnames of the endpoint, only the one with hostnameKey if set, or every
+// hostname if endpointKey is empty. It waits for the provider calls and
+// returns their results, with the errors hidden if hideSensible is set.
+func (w *Worker) UpdateNow(endpointKey string, hostnameKey *string, resync bool, hideSensible bool) ([]UpdateResult, error) {
+	hostnames := w.State.selectHostnames(endpointKey, hostnameKey)
+	if len(hostnames) == 0 {
+		return nil, ErrNoHostnames
+	}
+
+	results := make([]UpdateResult, len(hostnames))
+	var wg sync.WaitGroup
+	for i, hostname := range hostnames {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			w.logger.Infof("endpoint %s update of %s triggered by hand", hostname.endpointKey, hostname.hostnameKey)
+			changes, err := hostname.UpdateNow(resync)
+
+			result := UpdateResult{
+				Endpoint: hostname.endpointKey,
+				Hostname: hostname.hostnameKey,
+				Domain:   hostname.domain,
+				Changes:  make([]string, 0, len(changes)),
+			}
+			for _, change := range changes {
+				result.Changes = append(result.Changes, change.String())
+			}
+			if err != nil {
+				result.Error = err.Error()
+				if hideSensible {
+					result.Error = "<sensible data hidden>"
+				}
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	sortResults(results)
+	return results, nil
+}
+
+// Reset clears the error and backoff of the selected hostnames, as UpdateNow
+// selects them, so the failed ones are retried right away.
+func (w *Worker) Reset(endpointKey string, hostnameKey *string) ([]UpdateResult, error) {
+	hostnames := w.State.selectHostnames(endpointKey, hostnameKey)
+	if len(hostnames) == 0 {
+		return nil, ErrNoHostnames
+	}
+
+	results := make([]UpdateResult, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		w.logger.Infof("endpoint %s errors of %s cleared by hand", hostname.endpointKey, hostname.hostnameKey)
+		hostname.Reset()
+		results = append(results, UpdateResult{
+			Endpoint: hostname.endpointKey,
+			Hostname: hostname.hostnameKey,
+			Domain:   hostname.domain,
+			Changes:  []string{},
+		})
+	}
+
+	sortResults(results)
+	return results, nil
+}
+
+func sortResults(results []UpdateResult) {
+	slices.SortFunc(results, func(a, b UpdateResult) int {
+		return cmp.Or(strings.Compare(a.Endpoint, b.Endpoint), strings.Compare(a.Hostname, b.Hostname))
+	})
+}
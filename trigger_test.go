@@ -0,0 +1,140 @@
+package ipv6ddns
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSelectHostnames(t *testing.T) {
+	w := newTestWorker(t, &testService{}, "", "host", "other")
+	host, apex := "host", ""
+
+	tests := []struct {
+		name        string
+		endpointKey string
+		hostnameKey *string
+		want        []string
+	}{
+		{"Every Hostname", "", nil, []string{"", "host", "other"}},
+		{"Every Hostname Of The Endpoint", "endpoint", nil, []string{"", "host", "other"}},
+		{"One Hostname", "endpoint", &host, []string{"host"}},
+		{"Apex Hostname", "endpoint", &apex, []string{""}},
+		{"Unknown Endpoint", "unknown", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, selected := range w.State.selectHostnames(tt.endpointKey, tt.hostnameKey) {
+				got = append(got, selected.hostnameKey)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selectHostnames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkerUpdateNow(t *testing.T) {
+	t.Run("Updates The Selected Hostnames", func(t *testing.T) {
+		w := newTestWorker(t, &testService{}, "host", "other")
+
+		host := "host"
+		results, err := w.UpdateNow("endpoint", &host, false, false)
+		if err != nil {
+			t.Fatalf("UpdateNow() failed: %v", err)
+		}
+		if len(results) != 1 || results[0].Hostname != "host" || results[0].Domain != "host.example.com" {
+			t.Fatalf("UpdateNow() = %+v, want the result of host", results)
+		}
+
+		results, err = w.UpdateNow("", nil, false, false)
+		if err != nil {
+			t.Fatalf("UpdateNow() failed: %v", err)
+		}
+		if len(results) != 2 || results[0].Hostname != "host" || results[1].Hostname != "other" {
+			t.Errorf("UpdateNow() = %+v, want the sorted results of every hostname", results)
+		}
+	})
+
+	t.Run("Reports The Changes", func(t *testing.T) {
+		w := newTestWorker(t, &testService{}, "host")
+		w.State.selectHostnames("endpoint", nil)[0].SetAddrCollection(newTestCollection(newTestAddr("00:11:22:33:44:55", "2001:db8::1", time.Hour)))
+
+		results, err := w.UpdateNow("endpoint", nil, false, false)
+		if err != nil {
+			t.Fatalf("UpdateNow() failed: %v", err)
+		}
+		if want := []string{"create AAAA 2001:db8::1"}; !slices.Equal(results[0].Changes, want) {
+			t.Errorf("changes = %v, want %v", results[0].Changes, want)
+		}
+	})
+
+	t.Run("Hides Errors", func(t *testing.T) {
+		w := newTestWorker(t, &testService{err: errors.New("token abc rejected")}, "host")
+
+		results, err := w.UpdateNow("endpoint", nil, false, false)
+		if err != nil {
+			t.Fatalf("UpdateNow() failed: %v", err)
+		}
+		if results[0].Error != "token abc rejected" {
+			t.Errorf("error = %q, want the update error", results[0].Error)
+		}
+
+		results, _ = w.UpdateNow("endpoint", nil, false, true)
+		if results[0].Error != "<sensible data hidden>" {
+			t.Errorf("error = %q, want it hidden", results[0].Error)
+		}
+	})
+
+	t.Run("No Matching Hostnames", func(t *testing.T) {
+		w := newTestWorker(t, &testService{}, "host")
+		if _, err := w.UpdateNow("unknown", nil, false, false); !errors.Is(err, ErrNoHostnames) {
+			t.Errorf("UpdateNow() = %v, want %v", err, ErrNoHostnames)
+		}
+	})
+}
+
+func TestWorkerReset(t *testing.T) {
+	service := &testService{err: errors.New("failed")}
+	w := newTestWorker(t, service, "host")
+	if _, err := w.UpdateNow("endpoint", nil, false, false); err != nil {
+		t.Fatalf("UpdateNow() failed: %v", err)
+	}
+
+	hostname := w.State.selectHostnames("endpoint", nil)[0].Hostname
+	hostname.mutex.RLock()
+	attempts, retry := hostname.updateAttempts, hostname.nextUpdateTime
+	hostname.mutex.RUnlock()
+	if attempts != 1 || retry.Before(time.Now().Add(30*time.Minute)) {
+		t.Fatalf("after a failed update attempts = %d and retry at %v, want 1 and a retry in an hour", attempts, retry)
+	}
+
+	service.fail(nil)
+
+	results, err := w.Reset("endpoint", nil)
+	if err != nil {
+		t.Fatalf("Reset() failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Hostname != "host" {
+		t.Errorf("Reset() = %+v, want the result of host", results)
+	}
+
+	hostname.mutex.RLock()
+	if hostname.updateError != nil || hostname.updateAttempts != 0 || !hostname.failingSince.IsZero() {
+		t.Errorf("error = %v and attempts = %d after Reset(), want them cleared", hostname.updateError, hostname.updateAttempts)
+	}
+	hostname.mutex.RUnlock()
+
+	// the retry runs right away instead of in an hour
+	deadline := time.Now().Add(time.Second)
+	for hostname.report("host", false).LastUpdate == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hostname.report("host", false).LastUpdate == nil {
+		t.Errorf("no update after Reset(), want the retry right away")
+	}
+}
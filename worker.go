@@ -1,14 +1,18 @@
 package ipv6ddns
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net"
+	"net/netip"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miguelangel-nubla/ipv6ddns/config"
 	"github.com/miguelangel-nubla/ipv6ddns/ddns"
-	"github.com/miguelangel-nubla/ipv6ddns/pkg/filter"
 	"github.com/miguelangel-nubla/ipv6disc"
 	"go.uber.org/zap"
 )
@@ -21,135 +25,456 @@ func (e *InvalidInterfaceError) Error() string {
 	return fmt.Sprintf("invalid interface: %s", e.iface.Name)
 }
 
+const (
+	// changeScanInterval is how often the discovery table is diffed against
+	// the previous snapshot to find the addresses that changed, as discovery
+	// does not notify its changes.
+	changeScanInterval = 1 * time.Second
+	// fullScanInterval is how often every task is re-evaluated even if no
+	// change was detected, as a safety net for missed events.
+	fullScanInterval = 1 * time.Minute
+)
+
 type Worker struct {
 	*State
 	discWorker *ipv6disc.Worker
 	logger     *zap.SugaredLogger
-	config     config.Config
+
+	configMutex sync.RWMutex
+	reloadMutex sync.Mutex
+	config      config.Config
+	services    map[string]ddns.Service
+	// hostnameServices are the services of the hostnames overriding their credential settings
+	hostnameServices map[string]map[string]ddns.Service
+
+	// desired are the addresses each task wants published for its hostnames,
+	// only used by lookForChanges
+	desired map[string]map[hostnameRef]desiredAddrs
+
+	generatedMutex sync.Mutex
+	// generated are the hostnames created by each template
+	generated map[templateKey]generatedNames
+
+	store        *Store
+	cleanupMutex sync.Mutex
+	// cleanups are the running cleanups of orphaned hostnames, waited for by Stop
+	cleanups sync.WaitGroup
+	// lifetime of the addresses restored from the store until discovery sees them again
+	lifetime  time.Duration
+	warmupEnd time.Time
+	// warmupTimer publishes the removals held back once the warm-up is over, stopped by Stop
+	warmupTimer *time.Timer
+
+	changes  *changeTracker
+	notify   chan struct{}
+	fullScan atomic.Bool
+	// lastScan is when the last scan finished, in Unix nanoseconds, zero before the first
+	lastScan         atomic.Int64
+	discoveryStarted atomic.Bool
+	cancel           context.CancelFunc
+	running          sync.WaitGroup
+
+	// updates is the parent context of every provider update, cancelled when
+	// Stop gives up waiting for them
+	updates       context.Context
+	cancelUpdates context.CancelFunc
 }
 
-func (w *Worker) Start() error {
-	for _, task := range w.config.Tasks {
+// Start starts the IPv4 handlers, the discovery worker and the loop looking
+// for changes, which keeps running until ctx is done or Stop is called.
+func (w *Worker) Start(ctx context.Context) error {
+	ctx, w.cancel = context.WithCancel(ctx)
+
+	w.configMutex.RLock()
+	for taskName, task := range w.config.Tasks {
 		if task.IPv4 != nil && !task.IPv4.Running() {
-			err := task.IPv4.Start(w.config.BaseDir, w.logger)
+			err := task.IPv4.Start(w.config.BaseDir, w.logger, w.Notify)
 			if err != nil {
-				return fmt.Errorf("error starting IPv4 handler for task %s: %w", task.Name, err)
+				w.configMutex.RUnlock()
+				return fmt.Errorf("error starting IPv4 handler for task %s: %w", taskName, err)
 			}
 		}
 	}
+	w.warmupEnd = time.Now().Add(w.config.Warmup)
+	if w.config.Warmup > 0 {
+		w.logger.Infof("holding DNS record removals for %s while discovery warms up", w.config.Warmup)
+		// publish the removals held back as soon as the warm-up is over
+		w.warmupTimer = time.AfterFunc(w.config.Warmup, func() {
+			w.fullScan.Store(true)
+			w.Notify()
+		})
+	}
+	w.configMutex.RUnlock()
+
+	w.running.Add(1)
+	go func() {
+		defer w.running.Done()
+		w.run(ctx)
+	}()
+
+	if err := w.discWorker.Start(); err != nil {
+		return err
+	}
+	w.discoveryStarted.Store(true)
+	return nil
+}
+
+// Stop stops scheduling new updates and the IPv4 handlers, then waits for the
+// running updates and cleanups to finish until ctx is done.
+func (w *Worker) Stop(ctx context.Context) error {
+	w.cancel()
+	w.running.Wait()
+	if w.warmupTimer != nil {
+		w.warmupTimer.Stop()
+	}
+
+	w.configMutex.RLock()
+	for _, task := range w.config.Tasks {
+		if task.IPv4 != nil && task.IPv4.Running() {
+			task.IPv4.Stop()
+		}
+	}
+	w.configMutex.RUnlock()
 
+	hostnames := w.State.hostnames()
+	for _, hostname := range hostnames {
+		hostname.Stop()
+	}
+
+	done := make(chan struct{})
 	go func() {
-		for {
-			// @TODO: instead of proactively searching, be notified from ipv6disc.AddrCollection.Seen
-			w.lookForChanges()
-			time.Sleep(1 * time.Second)
+		for _, hostname := range hostnames {
+			hostname.Wait()
 		}
+		w.cleanups.Wait()
+		close(done)
 	}()
 
-	return w.discWorker.Start()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		w.cancelUpdates()
+		return fmt.Errorf("timed out waiting for running updates: %w", ctx.Err())
+	}
 }
 
 func (w *Worker) RegisterPlugin(p ipv6disc.Plugin) {
 	w.discWorker.RegisterPlugin(p)
 }
 
-func (w *Worker) lookForChanges() {
+// Notify wakes up the worker to look for changes without waiting for the next scan.
+func (w *Worker) Notify() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// run polls the discovery table for changes every changeScanInterval, scans
+// right away when notified by an IPv4 handler, a reload or the warm-up ending,
+// and every fullScanInterval as a safety net.
+func (w *Worker) run(ctx context.Context) {
+	w.scan(true)
+
+	changeScan := time.NewTicker(changeScanInterval)
+	defer changeScan.Stop()
+	fullScan := time.NewTicker(fullScanInterval)
+	defer fullScan.Stop()
+	// wakeup fires when an address held back by the stability settings
+	// becomes publishable or a lost one is forgotten
+	wakeup := time.NewTimer(0)
+	defer wakeup.Stop()
+
+	for {
+		// the tracker is guarded by configMutex, reload forgets tasks on it
+		w.configMutex.RLock()
+		next, ok := w.changes.nextWakeup()
+		w.configMutex.RUnlock()
+		if ok {
+			wakeup.Reset(time.Until(next))
+		} else {
+			wakeup.Stop()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.notify:
+			w.scan(w.fullScan.Swap(false))
+		case <-changeScan.C:
+			w.scan(w.fullScan.Swap(false))
+		case <-wakeup.C:
+			w.scan(w.fullScan.Swap(false))
+		case <-fullScan.C:
+			w.scan(true)
+		}
+	}
+}
+
+// scan looks for changes, on full scans the orphaned hostnames are cleaned up as well.
+func (w *Worker) scan(full bool) {
+	w.lookForChanges(full)
+	w.lastScan.Store(time.Now().UnixNano())
+	if full {
+		w.cleanups.Add(1)
+		go func() {
+			defer w.cleanups.Done()
+			w.cleanupOrphans()
+		}()
+	}
+}
+
+// discovered returns every valid address currently known by the discovery worker.
+func (w *Worker) discovered() []*ipv6disc.Addr {
+	var addrs []*ipv6disc.Addr
+	for _, collection := range w.discWorker.GetAll() {
+		addrs = append(addrs, collection.FilterValid().Get()...)
+	}
+	return addrs
+}
+
+// lookForChanges re-evaluates the tasks affected by the addresses that changed
+// since the last call, or every task if full is set.
+func (w *Worker) lookForChanges(full bool) {
+	w.configMutex.RLock()
+	defer w.configMutex.RUnlock()
+
+	var retainLost time.Duration
 	for _, task := range w.config.Tasks {
-		for endpointKey, hostnames := range task.Endpoints {
-			// Provider creation
-			credential := w.config.Credentials[endpointKey]
-			w.State.providersMutex.Lock()
-			if _, ok := w.State.providers[credential.Provider]; !ok {
-				w.State.providers[credential.Provider] = NewProvider()
+		retainLost = max(retainLost, task.Stability.RemovalGrace)
+	}
+
+	discovered := w.discovered()
+	changed := w.changes.scan(discovered, retainLost)
+
+	// hostnames whose union of desired addresses may have changed
+	touched := make(map[hostnameRef]bool)
+	for taskName, desired := range w.desired {
+		if _, ok := w.config.Tasks[taskName]; !ok {
+			for ref := range desired {
+				touched[ref] = true
 			}
-			provider := w.State.providers[credential.Provider]
-			w.State.providersMutex.Unlock()
-
-			// Endpoint creation
-			provider.endpointsMutex.Lock()
-			if _, ok := provider.endpoints[endpointKey]; !ok {
-				service, err := ddns.NewService(credential.Provider, credential.RawSettings)
-				if err != nil {
-					panic(fmt.Sprintf("Error creating DNS Service for endpoint %s: %v\n", endpointKey, err))
-				}
+			delete(w.desired, taskName)
+		}
+	}
+
+	for taskName, task := range w.config.Tasks {
+		affected := w.changes.affects(taskName, task, changed)
+		if !full && !affected {
+			continue
+		}
 
-				provider.endpoints[endpointKey] = NewEndpoint(service)
+		devices := ipv6disc.NewAddrCollection()
+		for _, addr := range discovered {
+			if matchesFilters(addr, task.Filters) && w.changes.stable(taskName, task.Stability, addr) {
+				devices.Add(addr)
 			}
-			endpoint := provider.endpoints[endpointKey]
-			provider.endpointsMutex.Unlock()
-
-			for _, hostnameKey := range hostnames {
-				// Hostname creation
-				endpoint.hostnamesMutex.Lock()
-				if _, ok := endpoint.hostnames[hostnameKey]; !ok {
-					// capture references to the current values
-					currenthostnameKey := hostnameKey
-					currentEndpoint := endpoint
-					updateAction := func(addrCollection *ipv6disc.AddrCollection) error {
-						w.logger.Debugf("endpoint %s starting update of: %s", endpointKey, currenthostnameKey)
-
-						err := currentEndpoint.Update(currenthostnameKey, addrCollection)
-						if err != nil {
-							w.logger.Errorf("endpoint %s error updating %s: %s", endpointKey, currenthostnameKey, err)
-						} else {
-							w.logger.Infof("endpoint %s successfully updated %s: %v", endpointKey, currenthostnameKey, addrCollection.Strings())
-						}
-
-						return err
-					}
-					endpoint.hostnames[hostnameKey] = NewHostname(updateAction, credential.DebounceTime, credential.RetryTime)
-				}
-				hostname := endpoint.hostnames[hostnameKey]
-				endpoint.hostnamesMutex.Unlock()
-
-				currentHosts := ipv6disc.NewAddrCollection()
-				for _, collection := range w.discWorker.GetAll() {
-					for _, addr := range collection.Get() {
-						match := false
-						for _, f := range task.Filters {
-							if !filter.CheckMAC(addr.Hw, f.MAC.Address) {
-								continue
-							}
-							if !filter.CheckMACMask(addr.Hw, f.MAC.Mask) {
-								continue
-							}
-							if !filter.CheckMACType(addr.Hw, f.MAC.Type) {
-								continue
-							}
-							if !filter.CheckIPType(addr, f.IP.Type) {
-								continue
-							}
-							if !filter.CheckPrefix(addr.Addr, f.IP.Prefix) {
-								continue
-							}
-							if !filter.CheckSuffix(addr.Addr, f.IP.Suffix) {
-								continue
-							}
-							if !filter.CheckMask(addr.Addr, f.IP.Mask) {
-								continue
-							}
-							if !filter.CheckSource(addr, f.Source) {
-								continue
-							}
-							match = true
-							break
-						}
-
-						if match {
-							currentHosts.Add(addr)
-						}
-					}
+		}
+		if task.Stability.RemovalGrace > 0 {
+			for _, addr := range w.changes.lostWithinGrace(task.Stability) {
+				if matchesFilters(addr, task.Filters) {
+					devices.Add(addr)
 				}
-				if task.IPv4 != nil {
-					currentHosts.Join(task.IPv4.AddrCollection)
+			}
+		}
+
+		currentHosts := ipv6disc.NewAddrCollection()
+		currentHosts.Join(devices)
+		if task.IPv4 != nil {
+			currentHosts.Join(task.IPv4.AddrCollection)
+		}
+		currentHosts = selectAddrs(task.Selection, currentHosts, w.changes.firstSeen)
+
+		desired := make(map[hostnameRef]desiredAddrs)
+		for endpointKey, hostnames := range task.Endpoints {
+			for _, hostname := range hostnames {
+				ref := hostnameRef{endpoint: endpointKey, name: hostname.Name}
+				switch {
+				case hostname.Template != "":
+					w.updateGenerated(taskName, task, endpointKey, hostname, devices, desired)
+				case len(hostname.MACs) > 0:
+					addrCollection := selectAddrs(task.Selection, withMACs(hostname.MACs, devices), w.changes.firstSeen)
+					desired[ref] = desiredAddrs{hostname, publishedTypes(task.HostnameRecordTypes(hostname), addrCollection)}
+				default:
+					desired[ref] = desiredAddrs{hostname, publishedTypes(task.HostnameRecordTypes(hostname), currentHosts)}
 				}
-				hostname.SetAddrCollection(currentHosts)
 			}
 		}
+
+		kept := w.applyEmptyPolicy(taskName, task, desired)
+
+		for ref := range w.desired[taskName] {
+			touched[ref] = true
+		}
+		for ref := range desired {
+			touched[ref] = true
+		}
+		for ref := range kept {
+			// left untouched so their last records stay published
+			delete(touched, ref)
+		}
+		w.desired[taskName] = desired
+	}
+
+	w.publishDesired(touched)
+}
+
+// applyEmptyPolicy applies the empty policy of the task to each of its
+// hostnames left without addresses by the selection and record types. It
+// returns the hostnames whose previous addresses are kept. The caller must
+// hold configMutex.
+func (w *Worker) applyEmptyPolicy(taskName string, task config.Task, desired map[hostnameRef]desiredAddrs) map[hostnameRef]bool {
+	kept := make(map[hostnameRef]bool)
+	for ref, d := range desired {
+		if len(d.addrCollection.FilterValid().Get()) > 0 {
+			continue
+		}
+
+		switch task.EmptyPolicy {
+		case config.EmptyKeep:
+			if previous, ok := w.desired[taskName][ref]; ok {
+				desired[ref] = previous
+			} else {
+				delete(desired, ref)
+			}
+			kept[ref] = true
+		case config.EmptyStatic:
+			desired[ref] = desiredAddrs{d.options, publishedTypes(task.HostnameRecordTypes(d.options), staticAddrCollection(task.StaticAddresses))}
+		}
+	}
+	return kept
+}
+
+// staticAddrCollection returns the static addresses of a task, valid until
+// shortly after the next full scan so they go away once discovery finds hosts again.
+func staticAddrCollection(addrs []netip.Addr) *ipv6disc.AddrCollection {
+	addrCollection := ipv6disc.NewAddrCollection()
+	for _, addr := range addrs {
+		addrCollection.Add(ipv6disc.NewAddr(net.HardwareAddr{0, 0, 0, 0, 0, 0}, addr, "static", 2*fullScanInterval, nil))
+	}
+	return addrCollection
+}
+
+// hostname returns the Hostname for the given endpoint, creating it and its
+// parent Provider and Endpoint on first use with the options it is configured
+// or generated with. The caller must hold configMutex.
+func (w *Worker) hostname(endpointKey string, hostnameKey string, options config.Hostname) *Hostname {
+	// Provider creation
+	credential := w.config.Credentials[endpointKey]
+	w.State.providersMutex.Lock()
+	if _, ok := w.State.providers[credential.Provider]; !ok {
+		w.State.providers[credential.Provider] = NewProvider()
+	}
+	provider := w.State.providers[credential.Provider]
+	w.State.providersMutex.Unlock()
+
+	// Endpoint creation
+	provider.endpointsMutex.Lock()
+	if _, ok := provider.endpoints[endpointKey]; !ok {
+		provider.endpoints[endpointKey] = NewEndpoint(w.services[endpointKey])
+	}
+	endpoint := provider.endpoints[endpointKey]
+	provider.endpointsMutex.Unlock()
+
+	// Hostname creation
+	endpoint.hostnamesMutex.Lock()
+	defer endpoint.hostnamesMutex.Unlock()
+	if _, ok := endpoint.hostnames[hostnameKey]; !ok {
+		service := endpoint.Service
+		if hostnameService, ok := w.hostnameServices[endpointKey][options.Key()]; ok {
+			service = hostnameService
+		}
+		reverseZones := w.config.ReverseZones(endpointKey, options.Key())
+		origin := StoredOrigin{Options: options.Key(), ReverseZones: reverseZones}
+		if options.Overrides() {
+			// only the overridden settings, the credential ones can change
+			origin.Overrides, _ = options.ProviderSettings(json.RawMessage("{}"))
+		}
+
+		updateAction := func(addrCollection *ipv6disc.AddrCollection, resync bool) ([]ddns.Change, error) {
+			w.logger.Debugf("endpoint %s starting update of: %s", endpointKey, hostnameKey)
+
+			ctx, cancel := w.updateContext(credential)
+			defer cancel()
+			if time.Now().Before(w.warmupEnd) {
+				// the records of the hosts discovery has not seen again yet are kept
+				ctx = ddns.WithDeletesHeld(ctx)
+			}
+
+			previous, _, _ := w.store.restore(endpointKey, hostnameKey, w.lifetime)
+			changes, err := w.update(ctx, service, reverseZones, hostnameKey, addrCollection, previous)
+			switch {
+			case err != nil:
+				w.logger.Errorf("endpoint %s error updating %s: %s", endpointKey, hostnameKey, err)
+			case credential.DryRun && len(changes) > 0:
+				w.logger.Infof("endpoint %s dry run, would apply to %s: %v", endpointKey, hostnameKey, changes)
+			case credential.DryRun:
+				w.logger.Debugf("endpoint %s dry run, %s is up to date", endpointKey, hostnameKey)
+			case resync && len(changes) > 0:
+				w.logger.Warnf("endpoint %s corrected drift on %s: %v", endpointKey, hostnameKey, changes)
+			case resync:
+				w.logger.Debugf("endpoint %s found no drift on %s", endpointKey, hostnameKey)
+			default:
+				w.logger.Infof("endpoint %s successfully updated %s: %v", endpointKey, hostnameKey, addrCollection.Strings())
+			}
+			if err == nil && !credential.DryRun {
+				w.store.published(endpointKey, hostnameKey, origin, addrCollection, time.Now())
+				w.saveStore()
+			}
+
+			return changes, err
+		}
+		hostname := NewHostname(service.Domain(hostnameKey), updateAction, credential, w.warmupEnd)
+		if addrCollection, updatedTime, ok := w.store.restore(endpointKey, hostnameKey, w.lifetime); ok {
+			hostname.restore(addrCollection, updatedTime)
+		}
+		endpoint.hostnames[hostnameKey] = hostname
+	}
+
+	return endpoint.hostnames[hostnameKey]
+}
+
+// update publishes the addresses of a hostname through the service, and
+// their PTR records in the reverse zones if any. Previous are the addresses
+// last published, nil if unknown.
+func (w *Worker) update(ctx context.Context, service ddns.Service, reverseZones []string, hostnameKey string, addrCollection *ipv6disc.AddrCollection, previous *ipv6disc.AddrCollection) ([]ddns.Change, error) {
+	changes, err := service.Update(ctx, hostnameKey, addrCollection)
+	if err != nil || len(reverseZones) == 0 {
+		return changes, err
+	}
+
+	reverseChanges, err := ddns.UpdateReverse(ctx, service, reverseZones, hostnameKey, addrCollection, previous)
+	return append(changes, reverseChanges...), err
+}
+
+// logSharedHostnames reports the hostnames listed by several tasks, which
+// publish the addresses of all of them.
+func logSharedHostnames(logger *zap.SugaredLogger, cfg config.Config) {
+	for endpointKey, hostnames := range cfg.SharedHostnames() {
+		for hostnameKey, taskNames := range hostnames {
+			logger.Infof("endpoint %s hostname %s is listed by tasks %s, publishing the addresses of all of them", endpointKey, hostnameKey, strings.Join(taskNames, ", "))
+		}
+	}
+}
+
+// updateContext returns the context for an update through the credential,
+// bounded by its timeout unless that is zero and flagged as a dry run if set.
+func (w *Worker) updateContext(credential config.Credential) (context.Context, context.CancelFunc) {
+	ctx := w.updates
+	if credential.DryRun {
+		ctx = ddns.WithDryRun(ctx)
 	}
+	if credential.Timeout > 0 {
+		return context.WithTimeout(ctx, credential.Timeout)
+	}
+	return context.WithCancel(ctx)
 }
 
 func (w *Worker) PrettyPrint(prefix string, hideSensible bool) string {
+	w.configMutex.RLock()
+	defer w.configMutex.RUnlock()
+
 	var result strings.Builder
 	fmt.Fprint(&result, w.State.PrettyPrint(prefix, hideSensible))
 	fmt.Fprint(&result, w.discWorker.State.PrettyPrint(prefix, hideSensible))
@@ -158,11 +483,44 @@ func (w *Worker) PrettyPrint(prefix string, hideSensible bool) string {
 	return result.String()
 }
 
-func NewWorker(logger *zap.SugaredLogger, rediscover time.Duration, lifetime time.Duration, config config.Config) *Worker {
+func NewWorker(logger *zap.SugaredLogger, rediscover time.Duration, lifetime time.Duration, config config.Config) (*Worker, error) {
+	services, err := newServices(config, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	hostnameServices, err := newHostnameServices(config)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := LoadStore(config.StateFilePath())
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file: %w", err)
+	}
+
+	updates, cancelUpdates := context.WithCancel(context.Background())
+
+	logSharedHostnames(logger, config)
+	for _, warning := range config.Warnings() {
+		logger.Warn(warning)
+	}
+
 	return &Worker{
 		State:      NewState(),
 		discWorker: ipv6disc.NewWorker(logger, rediscover, lifetime, config.Discovery.Listen, config.Discovery.Active),
 		logger:     logger,
 		config:     config,
-	}
+		services:   services,
+
+		hostnameServices: hostnameServices,
+		store:            store,
+		desired:          make(map[string]map[hostnameRef]desiredAddrs),
+		generated:        make(map[templateKey]generatedNames),
+		changes:          newChangeTracker(),
+		notify:           make(chan struct{}, 1),
+		lifetime:         lifetime,
+
+		updates:       updates,
+		cancelUpdates: cancelUpdates,
+	}, nil
 }
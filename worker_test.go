@@ -0,0 +1,243 @@
+package ipv6ddns
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miguelangel-nubla/ipv6ddns/config"
+	"github.com/miguelangel-nubla/ipv6ddns/ddns"
+	"github.com/miguelangel-nubla/ipv6disc"
+	"go.uber.org/zap"
+)
+
+func init() {
+	ddns.RegisterProvider("test", func(ddns.ProviderSettings) (ddns.Service, error) { return &testService{}, nil })
+}
+
+// testService publishes under example.com, creating a record for every
+// address, or fails with err if set.
+type testService struct {
+	mutex sync.Mutex
+	err   error
+}
+
+func (s *testService) fail(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.err = err
+}
+
+func (s *testService) Update(ctx context.Context, hostname string, addresses *ipv6disc.AddrCollection) ([]ddns.Change, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var changes []ddns.Change
+	for _, addr := range addresses.Strings() {
+		changes = append(changes, ddns.Change{Action: ddns.ChangeCreate, Type: "AAAA", Value: addr})
+	}
+	return changes, nil
+}
+
+func (s *testService) PrettyPrint(prefix string) ([]byte, error) {
+	return nil, nil
+}
+
+func (s *testService) Domain(hostname string) string {
+	return ddns.FQDN(hostname, "example.com")
+}
+
+// recordsService keeps the records of every hostname in memory, reconciling
+// them as the providers managing individual records do.
+type recordsService struct {
+	mutex   sync.Mutex
+	records map[string][]string
+}
+
+func (s *recordsService) Update(ctx context.Context, hostname string, addresses *ipv6disc.AddrCollection) ([]ddns.Change, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return ddns.Reconcile(ctx, &testRecords{s, hostname}, addresses, 0)
+}
+
+func (s *recordsService) values(hostname string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Sorted(slices.Values(s.records[hostname]))
+}
+
+func (s *recordsService) PrettyPrint(prefix string) ([]byte, error) {
+	return nil, nil
+}
+
+func (s *recordsService) Domain(hostname string) string {
+	return ddns.FQDN(hostname, "example.com")
+}
+
+// testRecords is the RecordSet of a hostname of a recordsService, called holding its mutex.
+type testRecords struct {
+	*recordsService
+	hostname string
+}
+
+func (r *testRecords) List(ctx context.Context) ([]ddns.Record, error) {
+	var records []ddns.Record
+	for _, value := range r.records[r.hostname] {
+		records = append(records, ddns.Record{Type: "AAAA", Value: value})
+	}
+	return records, nil
+}
+
+func (r *testRecords) Create(ctx context.Context, record ddns.Record) error {
+	r.records[r.hostname] = append(r.records[r.hostname], record.Value)
+	return nil
+}
+
+func (r *testRecords) Delete(ctx context.Context, record ddns.Record) error {
+	r.records[r.hostname] = slices.DeleteFunc(r.records[r.hostname], func(value string) bool { return value == record.Value })
+	return nil
+}
+
+func (r *testRecords) UpdateTTL(ctx context.Context, record ddns.Record) error {
+	return nil
+}
+
+// newTestWorker returns a worker, without discovery started, publishing the
+// hostnames of the endpoint of its task through service. Failed updates are
+// only retried after an hour.
+func newTestWorker(t *testing.T, service ddns.Service, hostnames ...string) *Worker {
+	t.Helper()
+
+	credential := config.Credential{
+		Provider:     "test",
+		DebounceTime: time.Hour,
+		MaxDelay:     time.Hour,
+		RetryTime:    time.Hour,
+		Retry:        config.RetryPolicy{MaxTime: time.Hour, Multiplier: 2, PermanentTime: time.Hour},
+		RawSettings:  json.RawMessage("{}"),
+	}
+	var options []config.Hostname
+	for _, name := range hostnames {
+		options = append(options, config.Hostname{Name: name})
+	}
+	cfg := config.Config{
+		Tasks:       map[string]config.Task{"task": {Endpoints: map[string][]config.Hostname{"endpoint": options}}},
+		Credentials: map[string]config.Credential{"endpoint": credential},
+	}
+
+	w, err := NewWorker(zap.NewNop().Sugar(), time.Minute, time.Hour, cfg)
+	if err != nil {
+		t.Fatalf("NewWorker failed: %v", err)
+	}
+	w.services["endpoint"] = service
+
+	w.configMutex.RLock()
+	for _, option := range options {
+		w.hostname("endpoint", option.Name, option)
+	}
+	w.configMutex.RUnlock()
+
+	t.Cleanup(func() {
+		for _, hostname := range w.hostnames() {
+			hostname.Stop()
+			hostname.Wait()
+		}
+	})
+	return w
+}
+
+func TestApplyEmptyPolicy(t *testing.T) {
+	published := newTestAddr("00:11:22:33:44:55", "2001:db8::1", time.Hour)
+	static := netip.MustParseAddr("2001:db8::ffff")
+
+	ref := hostnameRef{endpoint: "endpoint", name: "host"}
+	other := hostnameRef{endpoint: "endpoint", name: "other"}
+	previous := map[string]map[hostnameRef]desiredAddrs{
+		"task": {ref: {config.Hostname{Name: "host"}, newTestCollection(published)}},
+	}
+
+	tests := []struct {
+		name   string
+		policy string
+		want   []string
+		kept   bool
+	}{
+		{"Publish", config.EmptyPublish, []string{}, false},
+		{"Keep", config.EmptyKeep, []string{"2001:db8::1"}, true},
+		{"Static", config.EmptyStatic, []string{"2001:db8::ffff"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &Worker{desired: previous}
+			task := config.Task{EmptyPolicy: tt.policy, StaticAddresses: []netip.Addr{static}}
+			desired := map[hostnameRef]desiredAddrs{
+				ref:   {config.Hostname{Name: "host"}, ipv6disc.NewAddrCollection()},
+				other: {config.Hostname{Name: "other"}, newTestCollection(newTestAddr("00:11:22:33:44:66", "2001:db8::2", time.Hour))},
+			}
+
+			kept := w.applyEmptyPolicy("task", task, desired)
+
+			if got := desired[ref].addrCollection.Strings(); !slices.Equal(got, tt.want) {
+				t.Errorf("addresses = %v, want %v", got, tt.want)
+			}
+			if kept[ref] != tt.kept {
+				t.Errorf("kept = %v, want %v", kept[ref], tt.kept)
+			}
+			if got := desired[other].addrCollection.Strings(); !slices.Equal(got, []string{"2001:db8::2"}) {
+				t.Errorf("addresses of the hostname with addresses = %v, want them untouched", got)
+			}
+		})
+	}
+
+	t.Run("Static Filtered By Record Types", func(t *testing.T) {
+		w := &Worker{desired: previous}
+		task := config.Task{EmptyPolicy: config.EmptyStatic, StaticAddresses: []netip.Addr{static}}
+		options := config.Hostname{Name: "host", RecordTypes: config.RecordTypes{config.RecordTypeA}}
+		desired := map[hostnameRef]desiredAddrs{ref: {options, ipv6disc.NewAddrCollection()}}
+
+		w.applyEmptyPolicy("task", task, desired)
+
+		if got := desired[ref].addrCollection.Strings(); len(got) != 0 {
+			t.Errorf("addresses = %v, want no IPv6 static address for an A only hostname", got)
+		}
+	})
+}
+
+func TestWorkerWarmupHoldsDeletes(t *testing.T) {
+	// records published before a restart without a state file, unknown to the worker
+	service := &recordsService{records: map[string][]string{"host": {"2001:db8::1", "2001:db8::2"}}}
+	w := newTestWorker(t, service)
+	w.warmupEnd = time.Now().Add(200 * time.Millisecond)
+
+	w.configMutex.RLock()
+	hostname := w.hostname("endpoint", "host", config.Hostname{Name: "host"})
+	w.configMutex.RUnlock()
+
+	hostname.SetAddrCollection(newTestCollection(
+		newTestAddr("00:11:22:33:44:55", "2001:db8::1", time.Hour),
+		newTestAddr("00:11:22:33:44:55", "2001:db8::3", time.Hour),
+	))
+	if _, err := hostname.UpdateNow(false); err != nil {
+		t.Fatalf("UpdateNow() failed: %v", err)
+	}
+	if got, want := service.values("host"), []string{"2001:db8::1", "2001:db8::2", "2001:db8::3"}; !slices.Equal(got, want) {
+		t.Fatalf("records during warm-up = %v, want %v", got, want)
+	}
+
+	// the record discovery did not see again is deleted once the warm-up is over
+	want := []string{"2001:db8::1", "2001:db8::3"}
+	deadline := time.Now().Add(2 * time.Second)
+	for !slices.Equal(service.values("host"), want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := service.values("host"); !slices.Equal(got, want) {
+		t.Errorf("records after warm-up = %v, want %v", got, want)
+	}
+}